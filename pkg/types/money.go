@@ -0,0 +1,307 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var ErrMoneyOverflow = errors.New("money overflow")
+var ErrBadMoney = errors.New("bad money value")
+var ErrBadAllocation = errors.New("bad allocation")
+
+// Locale - как сумма записывается для человека: разделитель целой и дробной
+// части и разделитель групп разрядов.
+type Locale struct {
+	Decimal rune
+	Group   rune
+}
+
+// Локали, в которых кошелёк показывает суммы.
+var (
+	LocaleEN = Locale{Decimal: '.', Group: ','}      // 1,000.50
+	LocaleRU = Locale{Decimal: ',', Group: '\u00a0'} // 1 000,50 (через неразрывный пробел)
+	LocaleTJ = LocaleRU
+)
+
+// isSpace - пробелы, которыми люди разделяют разряды: обычный, неразрывный и узкий неразрывный.
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\u00a0' || r == '\u202f'
+}
+
+// FormatMinor записывает сумму в минимальных единицах - так суммы хранятся в выгрузках.
+func (m Money) FormatMinor() string {
+	return strconv.FormatInt(int64(m), 10)
+}
+
+// ParseError - сумму не удалось разобрать. Сравнивается с ErrBadMoney,
+// а слишком большая сумма - ещё и с ErrMoneyOverflow.
+type ParseError struct {
+	Value string
+	Err   error // причина из strconv
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("bad money value %q: %v", e.Value, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ParseError) Is(target error) bool {
+	return target == ErrBadMoney || target == ErrMoneyOverflow && errors.Is(e.Err, strconv.ErrRange)
+}
+
+// ParseMinor читает сумму в минимальных единицах, записанную FormatMinor.
+func ParseMinor(value string) (Money, error) {
+	amount, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, &ParseError{Value: value, Err: err}
+	}
+	return Money(amount), nil
+}
+
+// ParseMoney читает сумму в основных единицах валюты, как её пишут люди:
+// "1000", "1 000.50", "1 000,5", "-3.25". Разряды можно разделять пробелами,
+// дробная часть отделяется точкой или запятой. Пустая валюта - DefaultCurrency.
+func ParseMoney(value string, currency Currency) (Money, error) {
+	units, err := minorUnits(currency)
+	if err != nil {
+		return 0, err
+	}
+
+	digits := strings.Map(func(r rune) rune {
+		if isSpace(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(value))
+	if strings.Count(digits, ".")+strings.Count(digits, ",") > 1 {
+		return 0, fmt.Errorf("%w: %q", ErrBadMoney, value)
+	}
+	return ParseDecimal(strings.Replace(digits, ",", ".", 1), units)
+}
+
+// Parse читает сумму, записанную в локали l: разделитель групп - l.Group
+// или пробел, дробная часть - только после l.Decimal.
+func (l Locale) Parse(value string, currency Currency) (Money, error) {
+	units, err := minorUnits(currency)
+	if err != nil {
+		return 0, err
+	}
+
+	valid := true
+	digits := strings.Map(func(r rune) rune {
+		switch {
+		case r == l.Group || isSpace(r):
+			return -1
+		case r == l.Decimal:
+			return '.'
+		case r == '.' || r == ',':
+			valid = false
+		}
+		return r
+	}, strings.TrimSpace(value))
+	if !valid {
+		return 0, fmt.Errorf("%w: %q", ErrBadMoney, value)
+	}
+	return ParseDecimal(digits, units)
+}
+
+// Format записывает сумму в основных единицах валюты в локали l: "1 000,50".
+func (m Money) Format(currency Currency, l Locale) string {
+	units, err := minorUnits(currency)
+	if err != nil {
+		units = 0
+	}
+	whole, fraction := m.split(units)
+
+	b := strings.Builder{}
+	if m < 0 {
+		b.WriteByte('-')
+	}
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteRune(l.Group)
+		}
+		b.WriteRune(r)
+	}
+	if units > 0 {
+		b.WriteRune(l.Decimal)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
+
+// FormatDecimal записывает сумму десятичной дробью с units знаками после
+// точки и без разделителя разрядов: "-1000.50". ParseDecimal читает её обратно.
+func (m Money) FormatDecimal(units int) string {
+	whole, fraction := m.split(units)
+
+	sign := ""
+	if m < 0 {
+		sign = "-"
+	}
+	if units == 0 {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
+// split делит модуль суммы на целую часть и units знаков дробной.
+func (m Money) split(units int) (string, string) {
+	value := new(big.Int).Abs(big.NewInt(int64(m))).String()
+	if len(value) <= units {
+		value = strings.Repeat("0", units-len(value)+1) + value
+	}
+	return value[:len(value)-units], value[len(value)-units:]
+}
+
+func minorUnits(currency Currency) (int, error) {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	units, ok := currency.MinorUnits()
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown currency %q", ErrBadMoney, currency)
+	}
+	return units, nil
+}
+
+// ParseDecimal разбирает "[-+]целое[.дробь]" в минимальные единицы с units
+// знаками после точки. Знак допускается только перед числом, пробелы и
+// разделители разрядов - нет. Сумма вне Money - ErrMoneyOverflow.
+func ParseDecimal(value string, units int) (Money, error) {
+	number := value
+	negative := strings.HasPrefix(number, "-")
+	if negative || strings.HasPrefix(number, "+") {
+		number = number[1:]
+	}
+
+	parts := strings.SplitN(number, ".", 2)
+	digits := parts[0]
+	if len(parts) == 2 {
+		if len(parts[1]) == 0 || len(parts[1]) > units {
+			return 0, fmt.Errorf("%w: %q", ErrBadMoney, value)
+		}
+		digits += parts[1] + strings.Repeat("0", units-len(parts[1]))
+	} else {
+		digits += strings.Repeat("0", units)
+	}
+	if parts[0] == "" || strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return 0, fmt.Errorf("%w: %q", ErrBadMoney, value)
+	}
+	if negative {
+		digits = "-" + digits
+	}
+	amount, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, &ParseError{Value: value, Err: err}
+	}
+	return Money(amount), nil
+}
+
+// Add возвращает m + other или ErrMoneyOverflow.
+func (m Money) Add(other Money) (Money, error) {
+	sum := m + other
+	if (other > 0 && sum < m) || (other < 0 && sum > m) {
+		return 0, ErrMoneyOverflow
+	}
+	return sum, nil
+}
+
+// Sub возвращает m - other или ErrMoneyOverflow.
+func (m Money) Sub(other Money) (Money, error) {
+	diff := m - other
+	if (other > 0 && diff > m) || (other < 0 && diff < m) {
+		return 0, ErrMoneyOverflow
+	}
+	return diff, nil
+}
+
+// Mul возвращает m * n или ErrMoneyOverflow.
+func (m Money) Mul(n int64) (Money, error) {
+	if m == 0 || n == 0 {
+		return 0, nil
+	}
+	product := int64(m) * n
+	if product/n != int64(m) || (int64(m) == math.MinInt64 && n == -1) {
+		return 0, ErrMoneyOverflow
+	}
+	return Money(product), nil
+}
+
+// Percent возвращает basisPoints сотых долей процента от m (100 - это 1%),
+// округляя половину минимальной единицы от нуля.
+func (m Money) Percent(basisPoints int64) (Money, error) {
+	num := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(basisPoints))
+	return quotient(num, big.NewInt(10_000))
+}
+
+// Split делит m на n частей, отличающихся не больше чем на минимальную
+// единицу; лишние единицы достаются первым частям. Сумма частей равна m.
+func (m Money) Split(n int) ([]Money, error) {
+	if n <= 0 {
+		return nil, ErrBadAllocation
+	}
+	ratios := make([]int64, n)
+	for i := range ratios {
+		ratios[i] = 1
+	}
+	return m.Allocate(ratios...)
+}
+
+// Allocate делит m пропорционально ratios. Остаток от округления раздаётся
+// по минимальной единице первым частям, так что сумма частей равна m.
+func (m Money) Allocate(ratios ...int64) ([]Money, error) {
+	total := big.NewInt(0)
+	for _, ratio := range ratios {
+		if ratio < 0 {
+			return nil, ErrBadAllocation
+		}
+		total.Add(total, big.NewInt(ratio))
+	}
+	if total.Sign() == 0 {
+		return nil, ErrBadAllocation
+	}
+
+	parts := make([]Money, len(ratios))
+	rest := m
+	for i, ratio := range ratios {
+		// Деление с отбрасыванием к нулю, остаток всегда того же знака, что и m.
+		part := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(ratio))
+		part.Quo(part, total)
+		parts[i] = Money(part.Int64())
+		rest -= parts[i]
+	}
+
+	unit := Money(1)
+	if rest < 0 {
+		unit = -1
+	}
+	for i := 0; rest != 0; i = (i + 1) % len(parts) {
+		if ratios[i] == 0 {
+			continue
+		}
+		parts[i] += unit
+		rest -= unit
+	}
+	return parts, nil
+}
+
+// quotient делит num на положительный den, округляя половину от нуля.
+func quotient(num, den *big.Int) (Money, error) {
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	rem.Abs(rem).Lsh(rem, 1)
+	if rem.Cmp(den) >= 0 {
+		quo.Add(quo, big.NewInt(int64(num.Sign())))
+	}
+	if !quo.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+	return Money(quo.Int64()), nil
+}
@@ -0,0 +1,210 @@
+package types
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency Currency
+		want     Money
+	}{
+		{"1 000.50", CurrencyTJS, 1000_50},
+		{"1 000,5", CurrencyTJS, 1000_50},
+		{"-3.25", CurrencyUSD, -3_25},
+		{"+7", "", 7_00},
+		{"1 500", CurrencyJPY, 1500},
+		{"1.005", CurrencyKWD, 1_005},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.value, tt.currency)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q, %s) = %d, %v, want %d", tt.value, tt.currency, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "-", "1.005", "1,000.50", "12a", "--5", "1."} {
+		_, err := ParseMoney(value, CurrencyTJS)
+		if !errors.Is(err, ErrBadMoney) {
+			t.Errorf("ParseMoney(%q): must return ErrBadMoney, returned %v", value, err)
+		}
+	}
+
+	_, err := ParseMoney("999 999 999 999 999 999", CurrencyTJS)
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("ParseMoney(): must return ErrMoneyOverflow, returned %v", err)
+	}
+}
+
+func TestMoney_FormatDecimal(t *testing.T) {
+	tests := []struct {
+		amount Money
+		units  int
+		want   string
+	}{
+		{0, 2, "0.00"},
+		{5, 2, "0.05"},
+		{1000_50, 2, "1000.50"},
+		{-3_25, 2, "-3.25"},
+		{1500, 0, "1500"},
+		{1_005, 3, "1.005"},
+		{math.MinInt64, 2, "-92233720368547758.08"},
+	}
+	for _, tt := range tests {
+		got := tt.amount.FormatDecimal(tt.units)
+		if got != tt.want {
+			t.Errorf("FormatDecimal(%d, %d) = %q, want %q", tt.amount, tt.units, got, tt.want)
+		}
+		parsed, err := ParseDecimal(got, tt.units)
+		if err != nil || parsed != tt.amount {
+			t.Errorf("ParseDecimal(%q, %d) = %d, %v", got, tt.units, parsed, err)
+		}
+	}
+}
+
+func TestParseDecimal(t *testing.T) {
+	got, err := ParseDecimal("+5", 2)
+	if err != nil || got != 5_00 {
+		t.Errorf("ParseDecimal(\"+5\") = %d, %v", got, err)
+	}
+	for _, value := range []string{"1.005", "", ".5", "1.", "--5", "-+5", "+-5", "1.-5", "1.+5", "1 000", "1,5"} {
+		got, err = ParseDecimal(value, 2)
+		if !errors.Is(err, ErrBadMoney) {
+			t.Errorf("ParseDecimal(%q) = %d, must return ErrBadMoney, returned %v", value, got, err)
+		}
+	}
+	got, err = ParseDecimal("100000000000000000.00", 2)
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("ParseDecimal() = %d, must return ErrMoneyOverflow, returned %v", got, err)
+	}
+}
+
+func TestLocale_Format(t *testing.T) {
+	tests := []struct {
+		amount   Money
+		currency Currency
+		locale   Locale
+		want     string
+	}{
+		{1000_50, CurrencyTJS, LocaleEN, "1,000.50"},
+		{1000_50, CurrencyTJS, LocaleRU, "1\u00a0000,50"},
+		{-1234567_05, CurrencyUSD, LocaleEN, "-1,234,567.05"},
+		{5, CurrencyTJS, LocaleEN, "0.05"},
+		{0, CurrencyKWD, LocaleEN, "0.000"},
+		{1500, CurrencyJPY, LocaleEN, "1,500"},
+		{math.MinInt64, CurrencyJPY, LocaleEN, "-9,223,372,036,854,775,808"},
+	}
+	for _, tt := range tests {
+		got := tt.amount.Format(tt.currency, tt.locale)
+		if got != tt.want {
+			t.Errorf("Format(%d, %s) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+			continue
+		}
+		parsed, err := tt.locale.Parse(got, tt.currency)
+		if err != nil || parsed != tt.amount {
+			t.Errorf("Parse(%q) = %d, %v, want %d", got, parsed, err, tt.amount)
+		}
+	}
+
+	_, err := LocaleRU.Parse("1 000.50", CurrencyTJS)
+	if !errors.Is(err, ErrBadMoney) {
+		t.Errorf("LocaleRU.Parse(): must reject '.' as decimal separator, returned %v", err)
+	}
+}
+
+func TestMoney_checkedArithmetic(t *testing.T) {
+	_, err := Money(math.MaxInt64).Add(1)
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Add(): must return ErrMoneyOverflow, returned %v", err)
+	}
+	_, err = Money(math.MinInt64).Sub(1)
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Sub(): must return ErrMoneyOverflow, returned %v", err)
+	}
+	_, err = Money(math.MaxInt64 / 2).Mul(3)
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Mul(): must return ErrMoneyOverflow, returned %v", err)
+	}
+	_, err = Money(math.MinInt64).Mul(-1)
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Mul(): must return ErrMoneyOverflow, returned %v", err)
+	}
+
+	got, err := Money(10_00).Add(-25_00)
+	if err != nil || got != -15_00 {
+		t.Errorf("Add() = %d, %v", got, err)
+	}
+	got, err = Money(10_00).Sub(-25_00)
+	if err != nil || got != 35_00 {
+		t.Errorf("Sub() = %d, %v", got, err)
+	}
+	got, err = Money(-3_33).Mul(3)
+	if err != nil || got != -9_99 {
+		t.Errorf("Mul() = %d, %v", got, err)
+	}
+}
+
+func TestMoney_Percent(t *testing.T) {
+	tests := []struct {
+		amount      Money
+		basisPoints int64
+		want        Money
+	}{
+		{100_00, 150, 1_50},
+		{1_01, 5_000, 51},
+		{-1_01, 5_000, -51},
+		{3_33, 1, 0},
+	}
+	for _, tt := range tests {
+		got, err := tt.amount.Percent(tt.basisPoints)
+		if err != nil || got != tt.want {
+			t.Errorf("Percent(%d, %d) = %d, %v, want %d", tt.amount, tt.basisPoints, got, err, tt.want)
+		}
+	}
+
+	_, err := Money(math.MaxInt64).Percent(20_000)
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Percent(): must return ErrMoneyOverflow, returned %v", err)
+	}
+}
+
+func TestMoney_Allocate(t *testing.T) {
+	tests := []struct {
+		amount Money
+		ratios []int64
+		want   []Money
+	}{
+		{100, []int64{1, 1, 1}, []Money{34, 33, 33}},
+		{-100, []int64{1, 1, 1}, []Money{-34, -33, -33}},
+		{5, []int64{0, 1, 1}, []Money{0, 3, 2}},
+		{100_00, []int64{70, 20, 10}, []Money{70_00, 20_00, 10_00}},
+		{1, []int64{1, 1}, []Money{1, 0}},
+		{math.MaxInt64, []int64{1, 1}, []Money{math.MaxInt64/2 + 1, math.MaxInt64 / 2}},
+	}
+	for _, tt := range tests {
+		got, err := tt.amount.Allocate(tt.ratios...)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Allocate(%d, %v) = %v, %v, want %v", tt.amount, tt.ratios, got, err, tt.want)
+		}
+	}
+
+	got, err := Money(10_00).Split(3)
+	if err != nil || !reflect.DeepEqual(got, []Money{3_34, 3_33, 3_33}) {
+		t.Errorf("Split() = %v, %v", got, err)
+	}
+
+	for _, ratios := range [][]int64{nil, {0, 0}, {1, -1}} {
+		_, err = Money(100).Allocate(ratios...)
+		if !errors.Is(err, ErrBadAllocation) {
+			t.Errorf("Allocate(%v): must return ErrBadAllocation, returned %v", ratios, err)
+		}
+	}
+	_, err = Money(100).Split(0)
+	if !errors.Is(err, ErrBadAllocation) {
+		t.Errorf("Split(0): must return ErrBadAllocation, returned %v", err)
+	}
+}
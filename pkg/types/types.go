@@ -1,8 +1,56 @@
 package types
 
+import "time"
+
 // Money представляет собой денежную сумму в минимальных единицах (центы, копейки, дирамы и т.д.).
 type Money int64
 
+// Currency - трёхбуквенный код валюты по ISO 4217 (TJS, USD, ...).
+type Currency string
+
+// Валюты, с которыми работает кошелёк.
+const (
+	CurrencyTJS Currency = "TJS"
+	CurrencyUSD Currency = "USD"
+	CurrencyEUR Currency = "EUR"
+	CurrencyRUB Currency = "RUB"
+	CurrencyJPY Currency = "JPY"
+	CurrencyKWD Currency = "KWD"
+)
+
+// DefaultCurrency - валюта счетов, заведённых без явной валюты, и записей
+// старых выгрузок, в которых валюты ещё не было.
+const DefaultCurrency = CurrencyTJS
+
+// currencyMinorUnits - число знаков после запятой в минимальной единице валюты (ISO 4217).
+var currencyMinorUnits = map[Currency]int{
+	CurrencyTJS: 2,
+	CurrencyUSD: 2,
+	CurrencyEUR: 2,
+	CurrencyRUB: 2,
+	CurrencyJPY: 0,
+	CurrencyKWD: 3,
+}
+
+// MinorUnits возвращает число знаков после запятой для валюты; false - валюта неизвестна.
+func (c Currency) MinorUnits() (int, bool) {
+	units, ok := currencyMinorUnits[c]
+	return units, ok
+}
+
+// Known сообщает, знает ли кошелёк эту валюту.
+func (c Currency) Known() bool {
+	_, ok := currencyMinorUnits[c]
+	return ok
+}
+
+// Rate - курс обмена: сколько единиц одной валюты дают за единицу другой,
+// в миллионных долях (курс 10.92 хранится как 10_920_000).
+type Rate int64
+
+// RateScale - курс 1:1.
+const RateScale Rate = 1_000_000
+
 // PaymentCategory представляет собой категорию, в которой был совершён платёж (авто, аптеки, рестораны и т.д.).
 type PaymentCategory string
 
@@ -14,6 +62,14 @@ const (
 	PaymentStatusOk         PaymentStatus = "OK"
 	PaymentStatusFail       PaymentStatus = "FAIL"
 	PaymentStatusInProgress PaymentStatus = "INPROGRESS"
+	PaymentStatusCancelled  PaymentStatus = "CANCELLED"
+
+	// Статусы удержаний (Authorize): деньги заблокированы, но не списаны.
+	// Capture переводит удержание в OK, Void - в VOIDED, по истечении
+	// срока оно становится EXPIRED.
+	PaymentStatusAuthorized PaymentStatus = "AUTHORIZED"
+	PaymentStatusVoided     PaymentStatus = "VOIDED"
+	PaymentStatusExpired    PaymentStatus = "EXPIRED"
 )
 
 // Payment представляет информацию о платеже.
@@ -21,17 +77,107 @@ type Payment struct {
 	ID        string
 	AccountID int64
 	Amount    Money
+	Currency  Currency
 	Category  PaymentCategory
 	Status    PaymentStatus
+	CreatedAt time.Time
+	UpdatedAt time.Time // время последней смены статуса
+
+	// Если платёж был в другой валюте, Amount и Currency - списанное со счёта,
+	// OriginalAmount и OriginalCurrency - сумма платежа, Rate - применённый
+	// курс OriginalCurrency -> Currency с учётом спреда.
+	OriginalAmount   Money
+	OriginalCurrency Currency
+	Rate             Rate
+
+	// Комиссия списывается отдельной строкой с категорией PaymentCategoryFee:
+	// у платежа Fee - её сумма и FeeID - ID строки, у строки ParentID - ID платежа.
+	// Строки возврата (PaymentCategoryRefund) ссылаются на платёж так же.
+	Fee      Money
+	FeeID    string
+	ParentID string
+
+	// Refunded - сколько уже возвращено по платежу через Refund, не больше Amount.
+	Refunded Money
+
+	// Для удержаний: Authorized - заблокированная сумма, ExpiresAt - когда
+	// удержание истекает. После частичного Capture Amount - списанная сумма,
+	// Authorized остаётся прежней.
+	Authorized Money
+	ExpiresAt  time.Time
+}
+
+// PaymentCategoryTransfer - категория, под которой переводы между счетами попадают в историю.
+const PaymentCategoryTransfer PaymentCategory = "transfer"
+
+// PaymentCategoryFee - категория строк комиссии.
+const PaymentCategoryFee PaymentCategory = "fee"
+
+// PaymentCategoryRefund - категория строк возврата. Как и входящий перевод
+// в истории, возврат записывается с отрицательной суммой.
+const PaymentCategoryRefund PaymentCategory = "refund"
+
+// Transfer представляет информацию о переводе между двумя счетами.
+type Transfer struct {
+	ID            string
+	FromAccountID int64
+	ToAccountID   int64
+	Amount        Money
+	Status        PaymentStatus
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 type Phone string
 
 // Account представляет информацию о счёте пользователя.
 type Account struct {
-	ID      int64
-	Phone   Phone
-	Balance Money
+	ID        int64
+	Phone     Phone
+	Balance   Money // текущий баланс, включая заблокированные деньги
+	Held      Money // сумма действующих удержаний
+	Currency  Currency
+	Tier      KYCTier
+	CreatedAt time.Time
+	UpdatedAt time.Time // время последнего изменения баланса
+}
+
+// Available возвращает доступный баланс - текущий за вычетом удержаний.
+func (a *Account) Available() Money {
+	return a.Balance - a.Held
+}
+
+// KYCTier - уровень идентификации владельца счёта.
+type KYCTier string
+
+// Уровни идентификации по возрастанию. Новые счета - анонимные.
+const (
+	KYCAnonymous  KYCTier = "anonymous"
+	KYCSimplified KYCTier = "simplified"
+	KYCFull       KYCTier = "full"
+)
+
+// kycTierRanks - порядок уровней: повышение - переход к большему рангу.
+var kycTierRanks = map[KYCTier]int{
+	KYCAnonymous:  0,
+	KYCSimplified: 1,
+	KYCFull:       2,
+}
+
+// Rank возвращает место уровня в порядке повышения; false - уровень неизвестен.
+func (t KYCTier) Rank() (int, bool) {
+	rank, ok := kycTierRanks[t]
+	return rank, ok
+}
+
+// TierChange - запись аудита о смене уровня идентификации счёта.
+type TierChange struct {
+	ID        string
+	AccountID int64
+	From      KYCTier
+	To        KYCTier
+	Reason    string
+	CreatedAt time.Time
 }
 
 // Favorite представляет информацию об элементе "Избранное".
@@ -39,8 +185,23 @@ type Favorite struct {
 	ID        string
 	AccountID int64
 	Amount    Money
+	Currency  Currency
 	Name      string
 	Category  PaymentCategory
+	CreatedAt time.Time
+}
+
+// IdempotencyKey - ключ идемпотентности выполненного вызова и параметры,
+// с которыми его вызвали. Result - ID созданного платежа (у Deposit пусто).
+type IdempotencyKey struct {
+	Key       string
+	Op        string
+	AccountID int64
+	Ref       string // повторённый платёж для Repeat
+	Amount    Money
+	Category  PaymentCategory
+	Result    string
+	CreatedAt time.Time
 }
 
 //Progress
@@ -48,3 +209,20 @@ type Progress struct {
 	Part   int
 	Result Money
 }
+
+// Posting - одна проводка главной книги. Положительная сумма увеличивает
+// остаток счёта книги, отрицательная - уменьшает.
+type Posting struct {
+	Account string
+	Amount  Money
+}
+
+// LedgerEntry - сбалансированный набор проводок одного движения денег
+// (сумма всех Posting.Amount равна нулю).
+type LedgerEntry struct {
+	ID        string
+	Reference string // ID платежа, перевода и т.д., породившего запись
+	Kind      string
+	Postings  []Posting
+	CreatedAt time.Time
+}
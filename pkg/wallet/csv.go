@@ -0,0 +1,376 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gholib/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrUnknownCSVColumn = errors.New("unknown csv column")
+var ErrBadAmount = errors.New("bad amount")
+var ErrTransferRecord = errors.New("transfer is not a payment")
+
+// Колонки CSV-выгрузки истории.
+const (
+	CSVColumnID            = "id"
+	CSVColumnAccountID     = "account_id"
+	CSVColumnAmount        = "amount"         // сумма в минимальных единицах (дирамах)
+	CSVColumnAmountDecimal = "amount_decimal" // сумма в основных единицах валюты, "1000.50"
+	CSVColumnCurrency      = "currency"
+	CSVColumnCategory      = "category"
+	CSVColumnStatus        = "status"
+	CSVColumnCreatedAt     = "created_at"
+	CSVColumnUpdatedAt     = "updated_at"
+
+	// Пересчёт валюты - только для платежей в чужой валюте, в DefaultCSVColumns не входят.
+	CSVColumnOriginalAmount   = "original_amount"   // сумма платежа в основных единицах его валюты
+	CSVColumnOriginalCurrency = "original_currency" // валюта платежа
+	CSVColumnRate             = "rate"              // применённый курс, "10.920000"
+
+	// Комиссия и возвраты - тоже вне DefaultCSVColumns: их строки и так есть в истории.
+	CSVColumnFee      = "fee"       // комиссия за платёж в минимальных единицах
+	CSVColumnFeeID    = "fee_id"    // ID строки комиссии
+	CSVColumnParentID = "parent_id" // у строки комиссии или возврата - ID платежа
+	CSVColumnRefunded = "refunded"  // сколько возвращено по платежу, в минимальных единицах
+
+	// Удержания - тоже вне DefaultCSVColumns.
+	CSVColumnAuthorized = "authorized" // заблокированная сумма в минимальных единицах
+	CSVColumnExpiresAt  = "expires_at" // когда удержание истекает
+)
+
+// DefaultCSVColumns - колонки, которые пишутся, если CSVOptions.Columns не задан.
+var DefaultCSVColumns = []string{
+	CSVColumnID,
+	CSVColumnAccountID,
+	CSVColumnAmount,
+	CSVColumnAmountDecimal,
+	CSVColumnCurrency,
+	CSVColumnCategory,
+	CSVColumnStatus,
+	CSVColumnCreatedAt,
+	CSVColumnUpdatedAt,
+}
+
+// CSVOptions - настройки CSV. Нулевое значение - все колонки через запятую.
+type CSVOptions struct {
+	Columns   []string
+	Delimiter rune
+}
+
+func (o CSVOptions) columns() []string {
+	if len(o.Columns) == 0 {
+		return DefaultCSVColumns
+	}
+	return o.Columns
+}
+
+func (o CSVOptions) delimiter() rune {
+	if o.Delimiter == 0 {
+		return ','
+	}
+	return o.Delimiter
+}
+
+// currencyUnits - знаки после запятой для валюты платежа.
+func currencyUnits(currency types.Currency) int {
+	if currency == "" {
+		currency = types.DefaultCurrency
+	}
+	units, ok := currency.MinorUnits()
+	if !ok {
+		units, _ = types.DefaultCurrency.MinorUnits()
+	}
+	return units
+}
+
+func csvField(payment *types.Payment, column string) (string, error) {
+	switch column {
+	case CSVColumnID:
+		return payment.ID, nil
+	case CSVColumnAccountID:
+		return strconv.FormatInt(payment.AccountID, 10), nil
+	case CSVColumnAmount:
+		return payment.Amount.FormatMinor(), nil
+	case CSVColumnAmountDecimal:
+		return payment.Amount.FormatDecimal(currencyUnits(payment.Currency)), nil
+	case CSVColumnCurrency:
+		return string(payment.Currency), nil
+	case CSVColumnCategory:
+		return string(payment.Category), nil
+	case CSVColumnStatus:
+		return string(payment.Status), nil
+	case CSVColumnCreatedAt:
+		return formatTime(payment.CreatedAt), nil
+	case CSVColumnUpdatedAt:
+		return formatTime(payment.UpdatedAt), nil
+	case CSVColumnOriginalAmount:
+		if payment.OriginalCurrency == "" {
+			return "", nil
+		}
+		return payment.OriginalAmount.FormatDecimal(currencyUnits(payment.OriginalCurrency)), nil
+	case CSVColumnOriginalCurrency:
+		return string(payment.OriginalCurrency), nil
+	case CSVColumnRate:
+		if payment.Rate == 0 {
+			return "", nil
+		}
+		return types.Money(payment.Rate).FormatDecimal(rateDigits), nil
+	case CSVColumnFee:
+		return formatOptionalInt(int64(payment.Fee)), nil
+	case CSVColumnFeeID:
+		return payment.FeeID, nil
+	case CSVColumnParentID:
+		return payment.ParentID, nil
+	case CSVColumnRefunded:
+		return formatOptionalInt(int64(payment.Refunded)), nil
+	case CSVColumnAuthorized:
+		return formatOptionalInt(int64(payment.Authorized)), nil
+	case CSVColumnExpiresAt:
+		return formatTime(payment.ExpiresAt), nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownCSVColumn, column)
+}
+
+// WriteHistoryCSV пишет платежи в w в CSV по RFC 4180: строка заголовка
+// с именами колонок, строки через CRLF, поля с разделителем, кавычками
+// или переводом строки берутся в кавычки.
+func WriteHistoryCSV(w io.Writer, payments []types.Payment, options CSVOptions) error {
+	columns := options.columns()
+	writer := csv.NewWriter(w)
+	writer.Comma = options.delimiter()
+	writer.UseCRLF = true
+
+	err := writer.Write(columns)
+	if err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for _, payment := range payments {
+		for i, column := range columns {
+			record[i], err = csvField(&payment, column)
+			if err != nil {
+				return err
+			}
+		}
+		err = writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// ExportAccountHistoryCSV пишет историю счёта (как ExportAccountHistory) в w в CSV.
+func (s *Service) ExportAccountHistoryCSV(accountID int64, w io.Writer, options CSVOptions) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	payments, err := s.exportAccountHistory(accountID)
+	if err != nil {
+		return err
+	}
+	err = WriteHistoryCSV(w, payments, options)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+// ImportPaymentsCSV загружает платежи из CSV с заголовком (колонки - как
+// в WriteHistoryCSV, порядок любой, лишние и CSVOptions.Columns не учитываются).
+// Как и Import, обновляет платёж с тем же ID и добавляет новый, проверяя
+// счёт, валюту и переход статуса; балансы не меняются, а удержания
+// (AUTHORIZED) блокируют сумму на счёте. Без колонки id платежам выдаются новые ID; сумма берётся
+// из amount, а если её нет - из amount_decimal; пустой статус - INPROGRESS.
+// Строки переводов из истории (категория transfer, входящий перевод - с
+// отрицательной суммой) платежами не являются, на них - ErrTransferRecord.
+// Возвращает число загруженных платежей; при ошибке не загружается ничего.
+func (s *Service) ImportPaymentsCSV(r io.Reader, options CSVOptions) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	err := s.staged(func(staging *Service) error {
+		var err error
+		count, err = staging.importPaymentsCSV(r, options)
+		return err
+	})
+	if err != nil {
+		log.Print(err)
+		return 0, err
+	}
+	if s.wal != nil {
+		return count, s.checkpoint()
+	}
+	return count, nil
+}
+
+func (s *Service) importPaymentsCSV(r io.Reader, options CSVOptions) (int, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = options.delimiter()
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	index := map[string]int{}
+	for i, column := range header {
+		index[strings.TrimSpace(column)] = i
+	}
+	if _, ok := index[CSVColumnAccountID]; !ok {
+		return 0, fmt.Errorf("csv header: no %q column", CSVColumnAccountID)
+	}
+	_, hasAmount := index[CSVColumnAmount]
+	_, hasDecimal := index[CSVColumnAmountDecimal]
+	if !hasAmount && !hasDecimal {
+		return 0, fmt.Errorf("csv header: no %q or %q column", CSVColumnAmount, CSVColumnAmountDecimal)
+	}
+
+	plan := s.newImportPlan(ImportPreserveIDs, &ImportReport{}, true)
+	err = plan.load()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		payment, err := parseCSVPayment(record, index)
+		if err == nil {
+			err = plan.importPayment(payment)
+		}
+		if err != nil {
+			return count, fmt.Errorf("csv record %d: %w", row, err)
+		}
+		count++
+	}
+}
+
+func parseCSVPayment(record []string, index map[string]int) (*types.Payment, error) {
+	field := func(column string) string {
+		i, ok := index[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	accountID, err := strconv.ParseInt(field(CSVColumnAccountID), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	category := types.PaymentCategory(field(CSVColumnCategory))
+	if category == types.PaymentCategoryTransfer {
+		return nil, fmt.Errorf("%w: %s", ErrTransferRecord, field(CSVColumnID))
+	}
+
+	currency, err := parseCurrencyField([]string{field(CSVColumnCurrency)}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var amount types.Money
+	if _, ok := index[CSVColumnAmount]; ok {
+		amount, err = types.ParseMinor(field(CSVColumnAmount))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBadAmount, field(CSVColumnAmount))
+		}
+	} else {
+		amount, err = types.ParseDecimal(field(CSVColumnAmountDecimal), currencyUnits(currency))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBadAmount, field(CSVColumnAmountDecimal))
+		}
+	}
+
+	status := types.PaymentStatus(field(CSVColumnStatus))
+	if status == "" {
+		status = types.PaymentStatusInProgress
+	}
+	if !isKnownStatus(status) {
+		return nil, ErrUnknownPaymentStatus
+	}
+
+	createdAt, err := parseTimeValue(field(CSVColumnCreatedAt))
+	if err != nil {
+		return nil, err
+	}
+	updatedAt, err := parseTimeValue(field(CSVColumnUpdatedAt))
+	if err != nil {
+		return nil, err
+	}
+
+	id := field(CSVColumnID)
+	if id == "" {
+		id = uuid.New().String()
+	}
+
+	payment := &types.Payment{
+		ID:        id,
+		AccountID: accountID,
+		Amount:    amount,
+		Currency:  currency,
+		Category:  category,
+		Status:    status,
+		CreatedAt: createdAt,
+		UpdatedAt: updatedAt,
+	}
+	if field(CSVColumnOriginalCurrency) != "" {
+		payment.OriginalCurrency, err = parseCurrencyField([]string{field(CSVColumnOriginalCurrency)}, 0)
+		if err != nil {
+			return nil, err
+		}
+		payment.OriginalAmount, err = types.ParseDecimal(field(CSVColumnOriginalAmount), currencyUnits(payment.OriginalCurrency))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBadAmount, field(CSVColumnOriginalAmount))
+		}
+		payment.Rate, err = parseRate(field(CSVColumnRate))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if field(CSVColumnFee) != "" {
+		payment.Fee, err = types.ParseMinor(field(CSVColumnFee))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBadAmount, field(CSVColumnFee))
+		}
+	}
+	if field(CSVColumnRefunded) != "" {
+		payment.Refunded, err = types.ParseMinor(field(CSVColumnRefunded))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBadAmount, field(CSVColumnRefunded))
+		}
+	}
+	if field(CSVColumnAuthorized) != "" {
+		payment.Authorized, err = types.ParseMinor(field(CSVColumnAuthorized))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBadAmount, field(CSVColumnAuthorized))
+		}
+	}
+	payment.ExpiresAt, err = parseTimeValue(field(CSVColumnExpiresAt))
+	if err != nil {
+		return nil, err
+	}
+	payment.FeeID = field(CSVColumnFeeID)
+	payment.ParentID = field(CSVColumnParentID)
+	return payment, nil
+}
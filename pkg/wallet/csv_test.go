@@ -0,0 +1,268 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestService_ExportAccountHistoryCSV_success(t *testing.T) {
+	s := newTestService()
+	s.SetClock((&testClock{now: time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC)}).Now)
+	account, err := s.addAccountWithBalance("+992880806776", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 1000_50, `cafe "Rohat", Dushanbe`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	err = s.ExportAccountHistoryCSV(account.ID, buf, CSVOptions{
+		Columns:   []string{CSVColumnID, CSVColumnAmount, CSVColumnAmountDecimal, CSVColumnCategory},
+		Delimiter: ';',
+	})
+	if err != nil {
+		t.Errorf("ExportAccountHistoryCSV(): error = %v", err)
+		return
+	}
+
+	want := "id;amount;amount_decimal;category\r\n" +
+		payment.ID + `;100050;1000.50;"cafe ""Rohat"", Dushanbe"` + "\r\n"
+	if buf.String() != want {
+		t.Errorf("ExportAccountHistoryCSV() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestService_ExportAccountHistoryCSV_unknownColumn(t *testing.T) {
+	s := newTestService()
+	account, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.ExportAccountHistoryCSV(account.ID, &bytes.Buffer{}, CSVOptions{Columns: []string{"balance"}})
+	if !errors.Is(err, ErrUnknownCSVColumn) {
+		t.Errorf("ExportAccountHistoryCSV(): must return ErrUnknownCSVColumn, returned %v", err)
+	}
+}
+
+func TestService_ImportPaymentsCSV_roundTrip(t *testing.T) {
+	s := newTestService()
+	account, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want, err := s.ExportAccountHistory(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	err = WriteHistoryCSV(buf, want, CSVOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	_, err = imported.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	count, err := imported.ImportPaymentsCSV(buf, CSVOptions{})
+	if err != nil || count != len(want) {
+		t.Errorf("ImportPaymentsCSV() = %d, error = %v", count, err)
+		return
+	}
+	got, err := imported.FindPaymentByID(want[0].ID)
+	if err != nil || *got != want[0] {
+		t.Errorf("ImportPaymentsCSV(): payment = %v, want %v", got, want[0])
+	}
+}
+
+func TestService_ImportPaymentsCSV_transfer(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	to, err := s.RegisterAccount("+992880806777")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Transfer(from.ID, to.ID, 100_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Входящий перевод в истории - с минусом, и платежом он не загружается.
+	buf := &bytes.Buffer{}
+	err = s.ExportAccountHistoryCSV(to.ID, buf, CSVOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	count, err := s.ImportPaymentsCSV(buf, CSVOptions{})
+	if !errors.Is(err, ErrTransferRecord) || count != 0 {
+		t.Errorf("ImportPaymentsCSV(): count = %d, must return ErrTransferRecord, returned %v", count, err)
+		return
+	}
+	payments, err := s.repository().PaymentsByAccount(to.ID)
+	if err != nil || len(payments) != 0 {
+		t.Errorf("ImportPaymentsCSV(): transfer imported as payment, payments = %v, error = %v", payments, err)
+	}
+}
+
+func TestService_ImportPaymentsCSV_decimalWithoutID(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	input := "account_id,amount_decimal,category\n1,10.5,auto\n"
+
+	count, err := s.ImportPaymentsCSV(strings.NewReader(input), CSVOptions{})
+	if err != nil || count != 1 {
+		t.Errorf("ImportPaymentsCSV(): count = %d, error = %v", count, err)
+		return
+	}
+
+	payments, err := s.repository().Payments()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(payments) != 1 || payments[0].Amount != 10_50 || payments[0].ID == "" ||
+		payments[0].Status != types.PaymentStatusInProgress {
+		t.Errorf("ImportPaymentsCSV(): payments = %v", payments)
+	}
+}
+
+func TestService_ImportPaymentsCSV_badRecord(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	input := "account_id,amount_decimal,category\n1,10.5,auto\n1,abc,auto\n"
+
+	count, err := s.ImportPaymentsCSV(strings.NewReader(input), CSVOptions{})
+	if !errors.Is(err, ErrBadAmount) || !strings.Contains(err.Error(), "record 3") || count != 0 {
+		t.Errorf("ImportPaymentsCSV(): count = %d, error = %v", count, err)
+		return
+	}
+
+	payments, err := s.repository().Payments()
+	if err != nil || len(payments) != 0 {
+		t.Errorf("ImportPaymentsCSV(): failed import must load nothing, payments = %v", payments)
+	}
+}
+
+func TestService_ImportPaymentsCSV_unknownAccount(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	input := "id,account_id,amount,currency,category,status\np1,1,100,TJS,auto,OK\np2,999,100,TJS,auto,AUTHORIZED\n"
+
+	count, err := s.ImportPaymentsCSV(strings.NewReader(input), CSVOptions{})
+	if !errors.Is(err, ErrAccountNotFound) || !strings.Contains(err.Error(), "record 3") || count != 0 {
+		t.Errorf("ImportPaymentsCSV(): count = %d, must return ErrAccountNotFound for record 3, returned %v", count, err)
+		return
+	}
+	payments, err := s.repository().Payments()
+	if err != nil || len(payments) != 0 {
+		t.Errorf("ImportPaymentsCSV(): failed import must load nothing, payments = %v", payments)
+	}
+}
+
+func TestService_ImportPaymentsCSV_currencyMismatch(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccountWithCurrency("+992880806776", types.CurrencyUSD)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	input := "id,account_id,amount,currency,category,status\np1," + strconv.FormatInt(account.ID, 10) + ",100,TJS,auto,OK\n"
+
+	count, err := s.ImportPaymentsCSV(strings.NewReader(input), CSVOptions{})
+	if !errors.Is(err, ErrCurrencyMismatch) || count != 0 {
+		t.Errorf("ImportPaymentsCSV(): count = %d, must return ErrCurrencyMismatch, returned %v", count, err)
+		return
+	}
+	_, err = s.FindPaymentByID("p1")
+	if !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("ImportPaymentsCSV(): payment in wrong currency imported, error = %v", err)
+	}
+}
+
+func TestService_ImportPaymentsCSV_authorized(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	id := strconv.FormatInt(account.ID, 10)
+	input := "id,account_id,amount,category,status\np1," + id + ",10000,auto,AUTHORIZED\n"
+
+	_, err = s.ImportPaymentsCSV(strings.NewReader(input), CSVOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Held != 100_00 {
+		t.Errorf("ImportPaymentsCSV(): hold not blocked, account = %v, error = %v", got, err)
+		return
+	}
+
+	// Повторная строка того же удержания не блокирует сумму второй раз,
+	// а проведённое удержание блокировку снимает.
+	_, err = s.ImportPaymentsCSV(strings.NewReader(input), CSVOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.ImportPaymentsCSV(strings.NewReader("id,account_id,amount,category,status\np1,"+id+",10000,auto,OK\n"), CSVOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	got, err = s.FindAccountByID(account.ID)
+	if err != nil || got.Held != 0 {
+		t.Errorf("ImportPaymentsCSV(): hold not released, account = %v, error = %v", got, err)
+	}
+}
+
+func TestService_ImportPaymentsCSV_badDecimal(t *testing.T) {
+	for _, value := range []string{"1.005", "", ".5", "1.", "--5", "-+5", "+-5", "1.-5", "1.+5", "100000000000000000.00"} {
+		s := newTestService()
+		input := "account_id,amount_decimal,category\n1,\"" + value + "\",auto\n"
+
+		_, err := s.ImportPaymentsCSV(strings.NewReader(input), CSVOptions{})
+		if !errors.Is(err, ErrBadAmount) {
+			t.Errorf("ImportPaymentsCSV(%q): must return ErrBadAmount, returned %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gholib/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrUnknownCurrency = errors.New("unknown currency")
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// accountCurrency - валюта счёта. Счета, заведённые до появления валют, - в DefaultCurrency.
+func accountCurrency(account *types.Account) types.Currency {
+	if account.Currency == "" {
+		return types.DefaultCurrency
+	}
+	return account.Currency
+}
+
+// checkCurrency проверяет, что операцию в валюте currency можно провести
+// по счёту без пересчёта. Пустая валюта означает валюту счёта.
+func checkCurrency(account *types.Account, currency types.Currency) error {
+	if currency == "" || currency == accountCurrency(account) {
+		return nil
+	}
+	if !currency.Known() {
+		return fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+	return fmt.Errorf("%w: account %d is in %s, operation in %s", ErrCurrencyMismatch, account.ID, accountCurrency(account), currency)
+}
+
+// RegisterAccountWithCurrency заводит счёт в валюте currency.
+func (s *Service) RegisterAccountWithCurrency(phone types.Phone, currency types.Currency) (*types.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.begin(&walRecord{Op: opRegisterAccount, Phone: phone, Currency: currency})
+	defer s.end()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.registerAccount(phone, currency)
+}
+
+// DepositIn пополняет счёт суммой в валюте currency. Если валюта не совпадает
+// с валютой счёта, сумма пересчитывается по курсам SetRateProvider, а без
+// провайдера возвращается ErrCurrencyMismatch.
+func (s *Service) DepositIn(accountID int64, amount types.Money, currency types.Currency) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.exchange(accountID, amount, currency, exchangeBuy)
+	if err != nil {
+		return err
+	}
+	credit := amount
+	if conv != nil {
+		credit = conv.amount
+	}
+	err = s.checkTier(accountID, OperationDeposit, credit, true)
+	if err != nil {
+		return err
+	}
+	record := &walRecord{Op: opDeposit, AccountID: accountID, Amount: amount, Currency: currency}
+	if conv != nil {
+		record.Rate, record.Converted = conv.rate, conv.amount
+	}
+	err = s.begin(record)
+	defer s.end()
+	if err != nil {
+		return err
+	}
+
+	return s.deposit(accountID, amount, currency, conv)
+}
+
+// PayIn - Pay с явной валютой платежа. Если валюта не совпадает с валютой
+// счёта, сумма пересчитывается по курсам SetRateProvider, и в платеже
+// остаются обе суммы и курс. Без провайдера возвращается ErrCurrencyMismatch.
+func (s *Service) PayIn(accountID int64, amount types.Money, currency types.Currency, category types.PaymentCategory) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.exchange(accountID, amount, currency, exchangeSell)
+	if err != nil {
+		return nil, err
+	}
+	// Комиссия считается с суммы в валюте счёта.
+	debit := amount
+	if conv != nil {
+		debit = conv.amount
+	}
+	err = s.checkLimits(accountID, debit, category)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := s.feeFor(category, debit)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkPaymentTier(accountID, debit, fee)
+	if err != nil {
+		return nil, err
+	}
+	paymentID := uuid.New().String()
+	record := &walRecord{Op: opPay, ID: paymentID, AccountID: accountID, Amount: amount, Currency: currency, Category: category, Fee: fee}
+	if conv != nil {
+		record.Rate, record.Converted = conv.rate, conv.amount
+	}
+	err = s.begin(record)
+	defer s.end()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.pay(paymentID, accountID, amount, currency, conv, fee, category)
+}
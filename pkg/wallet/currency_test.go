@@ -0,0 +1,154 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestService_RegisterAccountWithCurrency_success(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccountWithCurrency("+992880806776", types.CurrencyUSD)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Currency != types.CurrencyUSD {
+		t.Errorf("RegisterAccountWithCurrency(): wrong currency, account = %v", account)
+		return
+	}
+
+	account, err = s.RegisterAccount("+992880806777")
+	if err != nil || account.Currency != types.DefaultCurrency {
+		t.Errorf("RegisterAccount(): account = %v, error = %v", account, err)
+		return
+	}
+
+	_, err = s.RegisterAccountWithCurrency("+992880806778", "XXX")
+	if !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("RegisterAccountWithCurrency(): must return ErrUnknownCurrency, returned %v", err)
+	}
+}
+
+func TestService_PayIn_currencyMismatch(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccountWithCurrency("+992880806776", types.CurrencyUSD)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.DepositIn(account.ID, 100_00, types.CurrencyTJS)
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("DepositIn(): must return ErrCurrencyMismatch, returned %v", err)
+		return
+	}
+	err = s.DepositIn(account.ID, 100_00, types.CurrencyUSD)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.PayIn(account.ID, 10_00, types.CurrencyTJS, "auto")
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("PayIn(): must return ErrCurrencyMismatch, returned %v", err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 10_00, "auto")
+	if err != nil || payment.Currency != types.CurrencyUSD {
+		t.Errorf("Pay(): payment = %v, error = %v", payment, err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payment.ID, "auto")
+	if err != nil || favorite.Currency != types.CurrencyUSD {
+		t.Errorf("FavoritePayment(): favorite = %v, error = %v", favorite, err)
+		return
+	}
+
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil || account.Balance != 90_00 {
+		t.Errorf("FindAccountByID(): refused operations changed balance, account = %v, error = %v", account, err)
+	}
+}
+
+func TestService_Transfer_currencyMismatch(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance("+992880806776", 100_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	to, err := s.RegisterAccountWithCurrency("+992880806777", types.CurrencyEUR)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.Transfer(from.ID, to.ID, 10_00)
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Transfer(): must return ErrCurrencyMismatch, returned %v", err)
+	}
+}
+
+func TestService_Export_currency(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccountWithCurrency("+992880806776", types.CurrencyUSD)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 100_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 10_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payment.ID, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, format := range []struct {
+		name   string
+		export func(dir string) error
+		load   func(s *testService, dir string) error
+	}{
+		{"dump", s.Export, func(s *testService, dir string) error { return s.Import(dir) }},
+		{"json", s.ExportJSON, func(s *testService, dir string) error { return s.ImportJSON(dir) }},
+	} {
+		dir := t.TempDir()
+		err = format.export(dir)
+		if err != nil {
+			t.Errorf("%s: export error = %v", format.name, err)
+			return
+		}
+		imported := newTestService()
+		err = format.load(imported, dir)
+		if err != nil {
+			t.Errorf("%s: import error = %v", format.name, err)
+			return
+		}
+
+		gotAccount, err := imported.FindAccountByID(account.ID)
+		if err != nil || gotAccount.Currency != types.CurrencyUSD {
+			t.Errorf("%s: account currency lost, account = %v, error = %v", format.name, gotAccount, err)
+			return
+		}
+		gotPayment, err := imported.FindPaymentByID(payment.ID)
+		if err != nil || gotPayment.Currency != types.CurrencyUSD {
+			t.Errorf("%s: payment currency lost, payment = %v, error = %v", format.name, gotPayment, err)
+			return
+		}
+		gotFavorite, err := imported.FindFavoriteByID(favorite.ID)
+		if err != nil || gotFavorite.Currency != types.CurrencyUSD {
+			t.Errorf("%s: favorite currency lost, favorite = %v, error = %v", format.name, gotFavorite, err)
+			return
+		}
+	}
+}
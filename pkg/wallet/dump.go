@@ -0,0 +1,807 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+var ErrBadRecord = errors.New("bad dump record")
+var ErrUnsupportedDumpVersion = errors.New("unsupported dump format version")
+
+// Формат dump-файлов.
+//
+// Версия 1 (старые файлы, без заголовка): поля через ";", в конце строки тоже ";".
+// Экранирования нет, поэтому ";" или перевод строки в имени избранного или
+// в категории ломают файл. Даты добавлены в конец строки, так что ещё более
+// старые файлы без дат читаются как есть.
+//
+// Версия 2: первая строка - заголовок
+//
+//	#wallet-dump;2;accounts;id;phone;balance;created_at;updated_at;
+//
+// с версией, видом записей и именами колонок. Поля записей экранируются:
+// "\" -> "\\", ";" -> "\;", перевод строки -> "\n", возврат каретки -> "\r".
+// Колонки читаются по именам из заголовка: отсутствующая колонка читается
+// как пустое поле, неизвестная пропускается - так в формат можно добавлять поля.
+//
+// Главная книга (ledger.dump) есть только в версии 2. Проводки записи - в
+// одном поле postings: "счёт=сумма" через запятую.
+
+const dumpHeaderPrefix = "#wallet-dump"
+
+// dumpSchema - вид записей dump-файла и его колонки в порядке версии 1.
+type dumpSchema struct {
+	kind    string
+	columns []string
+}
+
+var (
+	accountsSchema = dumpSchema{"accounts", []string{
+		"id", "phone", "balance", "created_at", "updated_at", "currency", "held", "tier",
+	}}
+	paymentsSchema = dumpSchema{"payments", []string{
+		"id", "account_id", "amount", "category", "status", "created_at", "updated_at", "currency",
+		"original_amount", "original_currency", "rate", "fee", "fee_id", "parent_id",
+		"refunded", "authorized", "expires_at",
+	}}
+	favoritesSchema = dumpSchema{"favorites", []string{
+		"id", "account_id", "name", "amount", "category", "created_at", "currency",
+	}}
+	transfersSchema = dumpSchema{"transfers", []string{
+		"id", "from_account_id", "to_account_id", "amount", "status", "created_at", "updated_at",
+	}}
+	keysSchema = dumpSchema{"idempotency", []string{
+		"key", "op", "account_id", "ref", "amount", "category", "result", "created_at",
+	}}
+	tierChangesSchema = dumpSchema{"tier-changes", []string{
+		"id", "account_id", "from", "to", "reason", "created_at",
+	}}
+	ledgerSchema = dumpSchema{"ledger", []string{
+		"id", "reference", "kind", "postings", "created_at",
+	}}
+)
+
+// header возвращает строку заголовка текущей версии формата.
+func (schema dumpSchema) header() string {
+	fields := append([]string{dumpHeaderPrefix, strconv.Itoa(dumpFormatVersion), schema.kind}, schema.columns...)
+	return joinDumpFields(fields...)
+}
+
+func isDumpHeader(line string) bool {
+	return strings.HasPrefix(line, dumpHeaderPrefix+";")
+}
+
+// dumpReader разбирает строки одного dump-файла. Пока не встретился
+// заголовок, строки читаются как версия 1.
+type dumpReader struct {
+	schema  dumpSchema
+	version int
+	// index[i] - позиция колонки schema.columns[i] в строке, -1 если её нет.
+	index []int
+}
+
+func newDumpReader(schema dumpSchema) *dumpReader {
+	return &dumpReader{schema: schema, version: 1}
+}
+
+// read возвращает поля записи в порядке колонок схемы.
+// Для строки заголовка возвращает nil без ошибки.
+func (r *dumpReader) read(line string) ([]string, error) {
+	if isDumpHeader(line) {
+		return nil, r.readHeader(line)
+	}
+	if r.version == 1 {
+		return strings.Split(line, ";"), nil
+	}
+
+	data := splitDumpFields(line)
+	fields := make([]string, len(r.index))
+	for i, position := range r.index {
+		if position >= 0 && position < len(data) {
+			fields[i] = data[position]
+		}
+	}
+	return fields, nil
+}
+
+func (r *dumpReader) readHeader(line string) error {
+	data := splitDumpFields(line)
+	if len(data) < 3 {
+		return ErrBadRecord
+	}
+	version, err := strconv.Atoi(data[1])
+	if err != nil {
+		return ErrBadRecord
+	}
+	if version > dumpFormatVersion || version < 2 {
+		return fmt.Errorf("%w: %d", ErrUnsupportedDumpVersion, version)
+	}
+	if data[2] != r.schema.kind {
+		return fmt.Errorf("%w: dump of %s, expected %s", ErrBadRecord, data[2], r.schema.kind)
+	}
+
+	positions := map[string]int{}
+	for i, name := range data[3:] {
+		if name != "" {
+			positions[name] = i
+		}
+	}
+	r.index = make([]int, len(r.schema.columns))
+	for i, name := range r.schema.columns {
+		position, ok := positions[name]
+		if !ok {
+			position = -1
+		}
+		r.index[i] = position
+	}
+	r.version = version
+	return nil
+}
+
+// joinDumpFields собирает строку версии 2: поля экранируются, каждое завершается ";".
+func joinDumpFields(fields ...string) string {
+	var builder strings.Builder
+	for _, field := range fields {
+		builder.WriteString(escapeDumpField(field))
+		builder.WriteByte(';')
+	}
+	builder.WriteByte('\n')
+	return builder.String()
+}
+
+var dumpEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, "\n", `\n`, "\r", `\r`)
+
+func escapeDumpField(field string) string {
+	return dumpEscaper.Replace(field)
+}
+
+// splitDumpFields делит экранированную строку на поля. Как и strings.Split,
+// после завершающего ";" возвращает пустое поле.
+func splitDumpFields(line string) []string {
+	fields := []string{}
+	var field strings.Builder
+	escaped := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if escaped {
+			switch c {
+			case 'n':
+				field.WriteByte('\n')
+			case 'r':
+				field.WriteByte('\r')
+			default:
+				field.WriteByte(c)
+			}
+			escaped = false
+			continue
+		}
+		switch c {
+		case '\\':
+			escaped = true
+		case ';':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(c)
+		}
+	}
+	return append(fields, field.String())
+}
+
+func formatAccountLine(account *types.Account) string {
+	return joinDumpFields(
+		strconv.Itoa(int(account.ID)),
+		string(account.Phone),
+		account.Balance.FormatMinor(),
+		formatTime(account.CreatedAt),
+		formatTime(account.UpdatedAt),
+		string(account.Currency),
+		formatOptionalInt(int64(account.Held)),
+		string(account.Tier),
+	)
+}
+
+func parseAccountFields(data []string) (*types.Account, error) {
+	if len(data) < 3 {
+		return nil, ErrBadRecord
+	}
+
+	id, err := strconv.Atoi(data[0])
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := types.ParseMinor(data[2])
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, err := parseTimeField(data, 3)
+	if err != nil {
+		return nil, err
+	}
+	updatedAt, err := parseTimeField(data, 4)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := parseCurrencyField(data, 5)
+	if err != nil {
+		return nil, err
+	}
+	held, err := parseOptionalInt(data, 6)
+	if err != nil {
+		return nil, err
+	}
+	tier, err := parseTierField(data, 7)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Account{
+		ID:        int64(id),
+		Phone:     types.Phone(data[1]),
+		Balance:   balance,
+		Held:      types.Money(held),
+		Currency:  currency,
+		Tier:      tier,
+		CreatedAt: createdAt,
+		UpdatedAt: updatedAt,
+	}, nil
+}
+
+func formatPaymentLine(payment *types.Payment) string {
+	return joinDumpFields(
+		payment.ID,
+		strconv.Itoa(int(payment.AccountID)),
+		payment.Amount.FormatMinor(),
+		string(payment.Category),
+		string(payment.Status),
+		formatTime(payment.CreatedAt),
+		formatTime(payment.UpdatedAt),
+		string(payment.Currency),
+		formatOptionalInt(int64(payment.OriginalAmount)),
+		string(payment.OriginalCurrency),
+		formatOptionalInt(int64(payment.Rate)),
+		formatOptionalInt(int64(payment.Fee)),
+		payment.FeeID,
+		payment.ParentID,
+		formatOptionalInt(int64(payment.Refunded)),
+		formatOptionalInt(int64(payment.Authorized)),
+		formatTime(payment.ExpiresAt),
+	)
+}
+
+func parsePaymentFields(data []string) (*types.Payment, error) {
+	if len(data) < 5 {
+		return nil, ErrBadRecord
+	}
+
+	accountID, err := strconv.Atoi(data[1])
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := types.ParseMinor(data[2])
+	if err != nil {
+		return nil, err
+	}
+
+	status := types.PaymentStatus(data[4])
+	if !isKnownStatus(status) {
+		return nil, ErrUnknownPaymentStatus
+	}
+
+	createdAt, err := parseTimeField(data, 5)
+	if err != nil {
+		return nil, err
+	}
+	updatedAt, err := parseTimeField(data, 6)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := parseCurrencyField(data, 7)
+	if err != nil {
+		return nil, err
+	}
+	originalAmount, err := parseOptionalInt(data, 8)
+	if err != nil {
+		return nil, err
+	}
+	originalCurrency, err := parseOptionalCurrency(data, 9)
+	if err != nil {
+		return nil, err
+	}
+	rate, err := parseOptionalInt(data, 10)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := parseOptionalInt(data, 11)
+	if err != nil {
+		return nil, err
+	}
+	refunded, err := parseOptionalInt(data, 14)
+	if err != nil {
+		return nil, err
+	}
+	authorized, err := parseOptionalInt(data, 15)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := parseTimeField(data, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Payment{
+		ID:               data[0],
+		AccountID:        int64(accountID),
+		Amount:           amount,
+		Currency:         currency,
+		Category:         types.PaymentCategory(data[3]),
+		Status:           status,
+		CreatedAt:        createdAt,
+		UpdatedAt:        updatedAt,
+		OriginalAmount:   types.Money(originalAmount),
+		OriginalCurrency: originalCurrency,
+		Rate:             types.Rate(rate),
+		Fee:              types.Money(fee),
+		FeeID:            optionalField(data, 12),
+		ParentID:         optionalField(data, 13),
+		Refunded:         types.Money(refunded),
+		Authorized:       types.Money(authorized),
+		ExpiresAt:        expiresAt,
+	}, nil
+}
+
+func formatFavoriteLine(favorite *types.Favorite) string {
+	return joinDumpFields(
+		favorite.ID,
+		strconv.Itoa(int(favorite.AccountID)),
+		favorite.Name,
+		favorite.Amount.FormatMinor(),
+		string(favorite.Category),
+		formatTime(favorite.CreatedAt),
+		string(favorite.Currency),
+	)
+}
+
+func parseFavoriteFields(data []string) (*types.Favorite, error) {
+	if len(data) < 5 {
+		return nil, ErrBadRecord
+	}
+
+	accountID, err := strconv.Atoi(data[1])
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := types.ParseMinor(data[3])
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, err := parseTimeField(data, 5)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := parseCurrencyField(data, 6)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Favorite{
+		ID:        data[0],
+		AccountID: int64(accountID),
+		Name:      data[2],
+		Amount:    amount,
+		Currency:  currency,
+		Category:  types.PaymentCategory(data[4]),
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func formatTransferLine(transfer *types.Transfer) string {
+	return joinDumpFields(
+		transfer.ID,
+		strconv.Itoa(int(transfer.FromAccountID)),
+		strconv.Itoa(int(transfer.ToAccountID)),
+		transfer.Amount.FormatMinor(),
+		string(transfer.Status),
+		formatTime(transfer.CreatedAt),
+		formatTime(transfer.UpdatedAt),
+	)
+}
+
+func parseTransferFields(data []string) (*types.Transfer, error) {
+	if len(data) < 5 {
+		return nil, ErrBadRecord
+	}
+
+	fromID, err := strconv.Atoi(data[1])
+	if err != nil {
+		return nil, err
+	}
+
+	toID, err := strconv.Atoi(data[2])
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := types.ParseMinor(data[3])
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, err := parseTimeField(data, 5)
+	if err != nil {
+		return nil, err
+	}
+	updatedAt, err := parseTimeField(data, 6)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Transfer{
+		ID:            data[0],
+		FromAccountID: int64(fromID),
+		ToAccountID:   int64(toID),
+		Amount:        amount,
+		Status:        types.PaymentStatus(data[4]),
+		CreatedAt:     createdAt,
+		UpdatedAt:     updatedAt,
+	}, nil
+}
+
+func formatKeyLine(key *types.IdempotencyKey) string {
+	return joinDumpFields(
+		key.Key,
+		key.Op,
+		formatOptionalInt(key.AccountID),
+		key.Ref,
+		formatOptionalInt(int64(key.Amount)),
+		string(key.Category),
+		key.Result,
+		formatTime(key.CreatedAt),
+	)
+}
+
+func parseKeyFields(data []string) (*types.IdempotencyKey, error) {
+	if len(data) < 2 || data[0] == "" {
+		return nil, ErrBadRecord
+	}
+
+	accountID, err := parseOptionalInt(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := parseOptionalInt(data, 4)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := parseTimeField(data, 7)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.IdempotencyKey{
+		Key:       data[0],
+		Op:        data[1],
+		AccountID: accountID,
+		Ref:       optionalField(data, 3),
+		Amount:    types.Money(amount),
+		Category:  types.PaymentCategory(optionalField(data, 5)),
+		Result:    optionalField(data, 6),
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func formatTierChangeLine(change *types.TierChange) string {
+	return joinDumpFields(
+		change.ID,
+		strconv.Itoa(int(change.AccountID)),
+		string(change.From),
+		string(change.To),
+		change.Reason,
+		formatTime(change.CreatedAt),
+	)
+}
+
+func parseTierChangeFields(data []string) (*types.TierChange, error) {
+	if len(data) < 4 {
+		return nil, ErrBadRecord
+	}
+
+	accountID, err := strconv.Atoi(data[1])
+	if err != nil {
+		return nil, err
+	}
+	from, err := parseTierField(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTierField(data, 3)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := parseTimeField(data, 5)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.TierChange{
+		ID:        data[0],
+		AccountID: int64(accountID),
+		From:      from,
+		To:        to,
+		Reason:    optionalField(data, 4),
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func formatLedgerLine(entry *types.LedgerEntry) string {
+	postings := make([]string, 0, len(entry.Postings))
+	for _, posting := range entry.Postings {
+		postings = append(postings, posting.Account+"="+posting.Amount.FormatMinor())
+	}
+	return joinDumpFields(
+		entry.ID,
+		entry.Reference,
+		entry.Kind,
+		strings.Join(postings, ","),
+		formatTime(entry.CreatedAt),
+	)
+}
+
+func parseLedgerFields(data []string) (*types.LedgerEntry, error) {
+	if len(data) < 4 || data[0] == "" || data[3] == "" {
+		return nil, ErrBadRecord
+	}
+
+	postings := []types.Posting{}
+	for _, field := range strings.Split(data[3], ",") {
+		i := strings.LastIndex(field, "=")
+		if i <= 0 {
+			return nil, ErrBadRecord
+		}
+		amount, err := types.ParseMinor(field[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		postings = append(postings, types.Posting{Account: field[:i], Amount: amount})
+	}
+	createdAt, err := parseTimeField(data, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.LedgerEntry{
+		ID:        data[0],
+		Reference: data[1],
+		Kind:      data[2],
+		Postings:  postings,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+// formatTime и parseTimeField - даты в dump-файлах. Старые файлы дат не содержат,
+// поэтому отсутствующее или пустое поле читается как нулевое время.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTimeField(data []string, index int) (time.Time, error) {
+	if index >= len(data) || data[index] == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, data[index])
+}
+
+// parseCurrencyField - валюта записи. В выгрузках до появления валют
+// её нет, такие записи считаются в DefaultCurrency.
+func parseCurrencyField(data []string, index int) (types.Currency, error) {
+	if index >= len(data) || data[index] == "" {
+		return types.DefaultCurrency, nil
+	}
+	currency := types.Currency(data[index])
+	if !currency.Known() {
+		return "", fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+	return currency, nil
+}
+
+// formatOptionalInt и parseOptionalInt - необязательные числа: ноль пишется
+// пустым полем, пустое или отсутствующее поле читается как ноль.
+func formatOptionalInt(value int64) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+func parseOptionalInt(data []string, index int) (int64, error) {
+	if index >= len(data) || data[index] == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(data[index], 10, 64)
+}
+
+// optionalField - поле, которого может не быть в старых выгрузках.
+func optionalField(data []string, index int) string {
+	if index >= len(data) {
+		return ""
+	}
+	return data[index]
+}
+
+// parseTierField - уровень идентификации. В выгрузках до появления уровней
+// его нет, такие счета считаются анонимными.
+func parseTierField(data []string, index int) (types.KYCTier, error) {
+	if index >= len(data) || data[index] == "" {
+		return types.KYCAnonymous, nil
+	}
+	tier := types.KYCTier(data[index])
+	if _, ok := tier.Rank(); !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownTier, tier)
+	}
+	return tier, nil
+}
+
+// parseOptionalCurrency - валюта, которой может и не быть (исходная валюта платежа).
+func parseOptionalCurrency(data []string, index int) (types.Currency, error) {
+	if index >= len(data) || data[index] == "" {
+		return "", nil
+	}
+	return parseCurrencyField(data, index)
+}
+
+// schemaForDump определяет вид записей по имени dump-файла. Файлы
+// HistoryToFiles (payments1.dump, payments2.dump, ...) - это платежи.
+func schemaForDump(name string) (dumpSchema, bool) {
+	switch {
+	case name == accountsFile:
+		return accountsSchema, true
+	case name == favoritesFile:
+		return favoritesSchema, true
+	case name == transfersFile:
+		return transfersSchema, true
+	case name == keysFile:
+		return keysSchema, true
+	case name == tierChangesFile:
+		return tierChangesSchema, true
+	case name == ledgerFile:
+		return ledgerSchema, true
+	case strings.HasPrefix(name, "payments") && strings.HasSuffix(name, ".dump"):
+		return paymentsSchema, true
+	}
+	return dumpSchema{}, false
+}
+
+// dumpFiles готовит к записи dump-файлы со всеми сущностями repo. keepKey
+// отбирает ключи идемпотентности; nil - пишутся все.
+func dumpFiles(repo Repository, keepKey func(key *types.IdempotencyKey) bool) ([]dumpFile, error) {
+	accounts, err := repo.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	payments, err := repo.Payments()
+	if err != nil {
+		return nil, err
+	}
+	favorites, err := repo.Favorites()
+	if err != nil {
+		return nil, err
+	}
+	transfers, err := repo.Transfers()
+	if err != nil {
+		return nil, err
+	}
+	allKeys, err := repo.IdempotencyKeys()
+	if err != nil {
+		return nil, err
+	}
+	keys := allKeys[:0]
+	for i := range allKeys {
+		if keepKey == nil || keepKey(&allKeys[i]) {
+			keys = append(keys, allKeys[i])
+		}
+	}
+	changes, err := repo.TierChanges()
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := repo.LedgerEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	return []dumpFile{
+		{accountsFile, accountsSchema, len(accounts), func(i int) string { return formatAccountLine(&accounts[i]) }},
+		{paymentsFile, paymentsSchema, len(payments), func(i int) string { return formatPaymentLine(&payments[i]) }},
+		{favoritesFile, favoritesSchema, len(favorites), func(i int) string { return formatFavoriteLine(&favorites[i]) }},
+		{transfersFile, transfersSchema, len(transfers), func(i int) string { return formatTransferLine(&transfers[i]) }},
+		{keysFile, keysSchema, len(keys), func(i int) string { return formatKeyLine(&keys[i]) }},
+		{tierChangesFile, tierChangesSchema, len(changes), func(i int) string { return formatTierChangeLine(&changes[i]) }},
+		{ledgerFile, ledgerSchema, len(ledger), func(i int) string { return formatLedgerLine(&ledger[i]) }},
+	}, nil
+}
+
+// MigrateDumps переписывает на месте dump-файлы старого формата в каталоге dir
+// в текущий формат и возвращает имена переписанных файлов. Файлы текущего
+// формата не трогаются. Если в каталоге есть манифест, он обновляется.
+func MigrateDumps(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.dump"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	migrated := []string{}
+	for _, path := range paths {
+		name := filepath.Base(path)
+		schema, ok := schemaForDump(name)
+		if !ok {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return migrated, err
+		}
+		upgraded, ok, err := migrateDump(schema, string(data))
+		if err != nil {
+			return migrated, fmt.Errorf("%s: %w", name, err)
+		}
+		if !ok {
+			continue
+		}
+
+		err = writeFileAtomic(path, []byte(upgraded))
+		if err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, name)
+	}
+
+	if len(migrated) != 0 {
+		err = updateManifest(dir, migrated)
+		if err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// migrateDump переводит содержимое файла версии 1 в текущую версию.
+// Второе значение false - файл уже в текущем формате.
+func migrateDump(schema dumpSchema, data string) (string, bool, error) {
+	reader := newDumpReader(schema)
+	result := schema.header()
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+		fields, err := reader.read(line)
+		if err != nil {
+			return "", false, err
+		}
+		if fields == nil {
+			return "", false, nil
+		}
+
+		record := make([]string, len(schema.columns))
+		copy(record, fields)
+		result += joinDumpFields(record...)
+	}
+	return result, true, nil
+}
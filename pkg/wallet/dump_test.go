@@ -0,0 +1,214 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestSplitDumpFields(t *testing.T) {
+	fields := []string{`a;b`, "line\nbreak\r", `back\slash`, ""}
+	line := strings.TrimSuffix(joinDumpFields(fields...), "\n")
+	if strings.Contains(line, "\n") {
+		t.Errorf("joinDumpFields(): newline not escaped, line = %q", line)
+		return
+	}
+
+	got := splitDumpFields(line)
+	want := append(fields, "")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitDumpFields() = %q, want %q", got, want)
+	}
+}
+
+func TestService_Export_escapedFields(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 1000_00, "food;\ncafe\\bar")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payment.ID, "mom's;\n\"cafe\"")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+	gotPayment, err := imported.FindPaymentByID(payment.ID)
+	if err != nil || gotPayment.Category != payment.Category {
+		t.Errorf("Import(): category not preserved, payment = %v, error = %v", gotPayment, err)
+		return
+	}
+	gotFavorite, err := imported.FindFavoriteByID(favorite.ID)
+	if err != nil || gotFavorite.Name != favorite.Name || gotFavorite.Category != favorite.Category {
+		t.Errorf("Import(): favorite not preserved, favorite = %v, error = %v", gotFavorite, err)
+	}
+}
+
+func TestDumpReader_columnsByName(t *testing.T) {
+	reader := newDumpReader(accountsSchema)
+	_, err := reader.read("#wallet-dump;2;accounts;phone;future;id;balance;")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fields, err := reader.read("+992880806776;x;7;100;")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	account, err := parseAccountFields(fields)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := &types.Account{ID: 7, Phone: "+992880806776", Balance: 100, Currency: types.DefaultCurrency, Tier: types.KYCAnonymous}
+	if !reflect.DeepEqual(account, want) {
+		t.Errorf("parseAccountFields() = %v, want %v", account, want)
+	}
+}
+
+func TestDumpReader_unsupportedVersion(t *testing.T) {
+	reader := newDumpReader(accountsSchema)
+	_, err := reader.read("#wallet-dump;99;accounts;id;phone;balance;")
+	if !errors.Is(err, ErrUnsupportedDumpVersion) {
+		t.Errorf("read(): must return ErrUnsupportedDumpVersion, returned %v", err)
+	}
+}
+
+func TestMigrateDumps_success(t *testing.T) {
+	dir := t.TempDir()
+	legacy := map[string]string{
+		accountsFile:     "1;+992880806776;900000;\n2;+992935444994;800000;\n",
+		favoritesFile:    "934a1b43-52b0-4023-919e-d4e9d7a31b3b;2;ogastus;100000;auto;\n",
+		"payments1.dump": "fc10959f-5f28-40e2-81bc-a70348e0549a;2;100000;auto;INPROGRESS;\n",
+	}
+	for name, data := range legacy {
+		err := WriteToFile(filepath.Join(dir, name), data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	clock := &testClock{now: time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC)}
+	before := newTestService()
+	before.SetClock(clock.Now)
+	err := before.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): legacy dump error = %v", err)
+		return
+	}
+
+	migrated, err := MigrateDumps(dir)
+	if err != nil {
+		t.Errorf("MigrateDumps(): error = %v", err)
+		return
+	}
+	want := []string{accountsFile, favoritesFile, "payments1.dump"}
+	if !reflect.DeepEqual(migrated, want) {
+		t.Errorf("MigrateDumps() = %v, want %v", migrated, want)
+		return
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, accountsFile))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.HasPrefix(string(data), accountsSchema.header()) {
+		t.Errorf("MigrateDumps(): no header, data = %q", data)
+		return
+	}
+
+	migrated, err = MigrateDumps(dir)
+	if err != nil || len(migrated) != 0 {
+		t.Errorf("MigrateDumps(): second run must do nothing, migrated = %v, error = %v", migrated, err)
+		return
+	}
+
+	after := newTestService()
+	after.SetClock(clock.Now)
+	err = after.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): migrated dump error = %v", err)
+		return
+	}
+	beforeAccounts, _ := before.repository().Accounts()
+	afterAccounts, _ := after.repository().Accounts()
+	beforeFavorites, _ := before.repository().Favorites()
+	afterFavorites, _ := after.repository().Favorites()
+	if !reflect.DeepEqual(beforeAccounts, afterAccounts) || !reflect.DeepEqual(beforeFavorites, afterFavorites) {
+		t.Errorf("Import(): migrated dump differs, accounts = %v / %v", beforeAccounts, afterAccounts)
+	}
+}
+
+func TestOpenFileRepository_migratesLegacy(t *testing.T) {
+	dir := t.TempDir()
+	err := WriteToFile(filepath.Join(dir, accountsFile), "1;+992880806776;900000;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	repo, err := OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = repo.SaveAccount(&types.Account{ID: 2, Phone: "+992880806777;x"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = repo.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	repo, err = OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer repo.Close()
+
+	account, err := repo.AccountByID(2)
+	if err != nil || account.Phone != "+992880806777;x" {
+		t.Errorf("AccountByID(): account = %v, error = %v", account, err)
+		return
+	}
+	account, err = repo.AccountByID(1)
+	if err != nil || account.Balance != 900000 {
+		t.Errorf("AccountByID(): legacy account = %v, error = %v", account, err)
+	}
+}
@@ -0,0 +1,112 @@
+package wallet
+
+import (
+	"errors"
+
+	"github.com/gholib/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrFeeLine = errors.New("operation is not allowed on a fee line")
+
+// FeeRule - комиссия с платежа: BasisPoints сотых долей процента от суммы
+// (100 - это 1%) плюс Fixed, но не меньше Min и, если Max задан, не больше Max.
+type FeeRule struct {
+	BasisPoints int64
+	Fixed       types.Money
+	Min         types.Money
+	Max         types.Money
+}
+
+// FeeSchedule - комиссии по категориям платежей. Категории без своего
+// правила платят по Default; нулевое значение - платежи без комиссии.
+type FeeSchedule struct {
+	Categories map[types.PaymentCategory]FeeRule
+	Default    FeeRule
+}
+
+// Fee считает комиссию с платежа amount в категории category.
+func (schedule FeeSchedule) Fee(category types.PaymentCategory, amount types.Money) (types.Money, error) {
+	rule, ok := schedule.Categories[category]
+	if !ok {
+		rule = schedule.Default
+	}
+
+	fee, err := amount.Percent(rule.BasisPoints)
+	if err != nil {
+		return 0, err
+	}
+	fee, err = fee.Add(rule.Fixed)
+	if err != nil {
+		return 0, err
+	}
+	if fee < rule.Min {
+		fee = rule.Min
+	}
+	if rule.Max > 0 && fee > rule.Max {
+		fee = rule.Max
+	}
+	return fee, nil
+}
+
+// SetFeeSchedule задаёт комиссии, которые Pay, PayIn, PayFromFavorite и Repeat
+// списывают сверх суммы платежа.
+func (s *Service) SetFeeSchedule(schedule FeeSchedule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fees = schedule
+}
+
+// feeFor считает комиссию до записи операции в журнал: при повторе журнала
+// берётся записанная сумма, а не текущее расписание.
+func (s *Service) feeFor(category types.PaymentCategory, amount types.Money) (types.Money, error) {
+	if amount <= 0 {
+		return 0, nil
+	}
+	return s.fees.Fee(category, amount)
+}
+
+// feeNamespace - пространство имён ID строк комиссии. ID выводится из ID
+// платежа, поэтому после повтора журнала у строки тот же ID.
+var feeNamespace = uuid.MustParse("6f1c8c2e-6b8a-4d55-9a53-3f3c2f4f7e10")
+
+func feeLineID(paymentID string) string {
+	return uuid.NewSHA1(feeNamespace, []byte(paymentID)).String()
+}
+
+// chargeFee списывает комиссию за payment отдельной строкой.
+func (s *Service) chargeFee(payment *types.Payment, fee types.Money) (*types.Payment, error) {
+	line := &types.Payment{
+		ID:        feeLineID(payment.ID),
+		AccountID: payment.AccountID,
+		Amount:    fee,
+		Currency:  payment.Currency,
+		Category:  types.PaymentCategoryFee,
+		Status:    types.PaymentStatusInProgress,
+		ParentID:  payment.ID,
+	}
+	entry, err := s.post(EntryFee, line.ID,
+		types.Posting{Account: customerLedgerAccount(payment.AccountID), Amount: -fee},
+		types.Posting{Account: LedgerFees, Amount: fee},
+	)
+	if err != nil {
+		return nil, err
+	}
+	line.CreatedAt = entry.CreatedAt
+	line.UpdatedAt = entry.CreatedAt
+
+	err = s.repository().SavePayment(line)
+	if err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+// feeLine возвращает строку комиссии платежа или nil, если комиссии не было.
+func (s *Service) feeLine(payment *types.Payment) (*types.Payment, error) {
+	if payment.FeeID == "" {
+		return nil, nil
+	}
+	return s.findPaymentByID(payment.FeeID)
+}
@@ -0,0 +1,217 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+var testFees = FeeSchedule{
+	Categories: map[types.PaymentCategory]FeeRule{
+		"auto":                        {BasisPoints: 100, Max: 5_00},
+		types.PaymentCategoryTransfer: {Fixed: 3_00},
+		"pharmacy":                    {BasisPoints: 50, Min: 1_00},
+	},
+}
+
+func TestFeeSchedule_Fee(t *testing.T) {
+	tests := []struct {
+		category types.PaymentCategory
+		amount   types.Money
+		want     types.Money
+	}{
+		{"auto", 100_00, 1_00},
+		{"auto", 1000_00, 5_00},
+		{types.PaymentCategoryTransfer, 1000_00, 3_00},
+		{"pharmacy", 10_00, 1_00},
+		{"pharmacy", 1000_00, 5_00},
+		{"restaurant", 1000_00, 0},
+	}
+	for _, tt := range tests {
+		got, err := testFees.Fee(tt.category, tt.amount)
+		if err != nil || got != tt.want {
+			t.Errorf("Fee(%s, %d) = %d, %v, want %d", tt.category, tt.amount, got, err, tt.want)
+		}
+	}
+}
+
+func TestService_Pay_fee(t *testing.T) {
+	s := newTestService()
+	s.SetFeeSchedule(testFees)
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment, err := s.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	if payment.Amount != 100_00 || payment.Fee != 1_00 || payment.FeeID == "" {
+		t.Errorf("Pay(): wrong payment, payment = %v", payment)
+		return
+	}
+	fee, err := s.FindPaymentByID(payment.FeeID)
+	if err != nil || fee.Amount != 1_00 || fee.ParentID != payment.ID || fee.Category != types.PaymentCategoryFee {
+		t.Errorf("Pay(): wrong fee line, fee = %v, error = %v", fee, err)
+		return
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 899_00 {
+		t.Errorf("Pay(): wrong balance, account = %v, error = %v", got, err)
+		return
+	}
+
+	err = s.Reject(fee.ID)
+	if !errors.Is(err, ErrFeeLine) {
+		t.Errorf("Reject(): fee line must follow its payment, returned %v", err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	got, err = s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 1000_00 {
+		t.Errorf("Reject(): fee not refunded, account = %v, error = %v", got, err)
+		return
+	}
+	fee, err = s.FindPaymentByID(payment.FeeID)
+	if err != nil || fee.Status != types.PaymentStatusFail {
+		t.Errorf("Reject(): fee line not rejected, fee = %v, error = %v", fee, err)
+		return
+	}
+	if s.LedgerBalance(LedgerFees) != 0 {
+		t.Errorf("Reject(): fees ledger = %d, want 0", s.LedgerBalance(LedgerFees))
+		return
+	}
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_Pay_feeNotEnoughBalance(t *testing.T) {
+	s := newTestService()
+	s.SetFeeSchedule(testFees)
+	account, err := s.addAccountWithBalance("+992880806776", 100_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.Pay(account.ID, 100_00, "auto")
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Pay(): balance check must include fee, returned %v", err)
+		return
+	}
+	_, err = s.Pay(account.ID, 99_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+	}
+}
+
+func TestService_Repeat_fee(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payment.ID, "car")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s.SetFeeSchedule(testFees)
+	repeated, err := s.Repeat(payment.ID)
+	if err != nil || repeated.Fee != 1_00 {
+		t.Errorf("Repeat(): payment = %v, error = %v", repeated, err)
+		return
+	}
+	fromFavorite, err := s.PayFromFavorite(favorite.ID)
+	if err != nil || fromFavorite.Fee != 1_00 {
+		t.Errorf("PayFromFavorite(): payment = %v, error = %v", fromFavorite, err)
+		return
+	}
+	_, err = s.Repeat(repeated.FeeID)
+	if !errors.Is(err, ErrFeeLine) {
+		t.Errorf("Repeat(): must return ErrFeeLine for fee line, returned %v", err)
+		return
+	}
+
+	err = s.Confirm(repeated.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fee, err := s.FindPaymentByID(repeated.FeeID)
+	if err != nil || fee.Status != types.PaymentStatusOk {
+		t.Errorf("Confirm(): fee line not confirmed, fee = %v, error = %v", fee, err)
+		return
+	}
+
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 1000_00-3*100_00-2*1_00 {
+		t.Errorf("wrong balance, account = %v, error = %v", got, err)
+	}
+}
+
+func TestOpen_replayFee(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetFeeSchedule(testFees)
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Расписания при восстановлении нет - комиссия берётся из журнала.
+	s, err = Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	got, err := s.FindPaymentByID(payment.ID)
+	if err != nil || got.Fee != 1_00 || got.FeeID != payment.FeeID {
+		t.Errorf("payment after replay = %v, error = %v", got, err)
+		return
+	}
+	_, err = s.FindPaymentByID(payment.FeeID)
+	if err != nil {
+		t.Errorf("fee line not restored: %v", err)
+	}
+}
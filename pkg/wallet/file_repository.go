@@ -0,0 +1,357 @@
+package wallet
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+// Имена файлов FileRepository совпадают с файлами Export, поэтому каталог
+// экспорта можно открыть как хранилище и наоборот.
+const (
+	accountsFile    = "accounts.dump"
+	paymentsFile    = "payments.dump"
+	favoritesFile   = "favorites.dump"
+	transfersFile   = "transfers.dump"
+	keysFile        = "idempotency.dump"
+	tierChangesFile = "tiers.dump"
+	ledgerFile      = "ledger.dump"
+)
+
+// FileRepository - хранилище на диске поверх MemoryRepository. Каждое сохранение
+// дописывается строкой в конец dump-файла и сбрасывается на диск (fsync).
+// При открытии файлы перечитываются, и более поздняя строка с тем же ID
+// заменяет более раннюю - так же, как это делает Import. Compact переписывает
+// файлы без повторов.
+type FileRepository struct {
+	*MemoryRepository
+	mu    sync.Mutex
+	dir   string
+	files map[string]*os.File
+}
+
+// OpenFileRepository открывает (или создаёт) хранилище в каталоге dir.
+func OpenFileRepository(dir string) (*FileRepository, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &FileRepository{
+		MemoryRepository: NewMemoryRepository(),
+		dir:              dir,
+		files:            map[string]*os.File{},
+	}
+
+	loaders := []struct {
+		name   string
+		schema dumpSchema
+		load   func(fields []string) error
+	}{
+		{accountsFile, accountsSchema, func(fields []string) error {
+			account, err := parseAccountFields(fields)
+			if err != nil {
+				return err
+			}
+			return r.MemoryRepository.SaveAccount(account)
+		}},
+		{paymentsFile, paymentsSchema, func(fields []string) error {
+			payment, err := parsePaymentFields(fields)
+			if err != nil {
+				return err
+			}
+			return r.MemoryRepository.SavePayment(payment)
+		}},
+		{favoritesFile, favoritesSchema, func(fields []string) error {
+			favorite, err := parseFavoriteFields(fields)
+			if err != nil {
+				return err
+			}
+			return r.MemoryRepository.SaveFavorite(favorite)
+		}},
+		{transfersFile, transfersSchema, func(fields []string) error {
+			transfer, err := parseTransferFields(fields)
+			if err != nil {
+				return err
+			}
+			return r.MemoryRepository.SaveTransfer(transfer)
+		}},
+		{keysFile, keysSchema, func(fields []string) error {
+			key, err := parseKeyFields(fields)
+			if err != nil {
+				return err
+			}
+			return r.MemoryRepository.SaveIdempotencyKey(key)
+		}},
+		{tierChangesFile, tierChangesSchema, func(fields []string) error {
+			change, err := parseTierChangeFields(fields)
+			if err != nil {
+				return err
+			}
+			return r.MemoryRepository.SaveTierChange(change)
+		}},
+		{ledgerFile, ledgerSchema, func(fields []string) error {
+			entry, err := parseLedgerFields(fields)
+			if err != nil {
+				return err
+			}
+			return r.MemoryRepository.SaveLedgerEntry(entry)
+		}},
+	}
+
+	legacy := false
+	for _, loader := range loaders {
+		version, err := r.open(loader.name, loader.schema, loader.load)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		if version < dumpFormatVersion {
+			legacy = true
+		}
+	}
+
+	// Файлы старого формата переписываются в текущий сразу при открытии:
+	// дописывать экранированные строки в файл без заголовка нельзя.
+	if legacy {
+		err = r.Compact()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// open читает файл построчно и оставляет его открытым для дозаписи.
+// Незаконченная последняя строка (процесс упал посреди записи) отрезается.
+// Возвращает версию формата файла; в пустой файл сразу пишется заголовок.
+func (r *FileRepository) open(name string, schema dumpSchema, load func(fields []string) error) (int, error) {
+	file, err := os.OpenFile(filepath.Join(r.dir, name), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	r.files[name] = file
+
+	var good int64
+	reader := newDumpReader(schema)
+	dump := bufio.NewReader(file)
+	for {
+		line, err := dump.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		good += int64(len(line))
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			continue
+		}
+		fields, err := reader.read(line)
+		if err != nil {
+			return 0, err
+		}
+		if fields == nil {
+			continue
+		}
+		err = load(fields)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = file.Truncate(good)
+	if err != nil {
+		return 0, err
+	}
+	_, err = file.Seek(good, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	if good == 0 {
+		_, err = file.WriteString(schema.header())
+		if err != nil {
+			return 0, err
+		}
+		return dumpFormatVersion, file.Sync()
+	}
+	return reader.version, nil
+}
+
+// append дописывает line в файл name и вызывает save, который кладёт
+// сущность в память. Оба шага идут под r.mu: иначе Compact между ними снял
+// бы память без новой сущности и заменил файл вместе с дописанной строкой.
+func (r *FileRepository) append(name string, line string, save func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	file := r.files[name]
+	_, err := file.WriteString(line)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	return save()
+}
+
+func (r *FileRepository) SaveAccount(account *types.Account) error {
+	return r.append(accountsFile, formatAccountLine(account), func() error {
+		return r.MemoryRepository.SaveAccount(account)
+	})
+}
+
+func (r *FileRepository) SavePayment(payment *types.Payment) error {
+	return r.append(paymentsFile, formatPaymentLine(payment), func() error {
+		return r.MemoryRepository.SavePayment(payment)
+	})
+}
+
+func (r *FileRepository) SaveFavorite(favorite *types.Favorite) error {
+	return r.append(favoritesFile, formatFavoriteLine(favorite), func() error {
+		return r.MemoryRepository.SaveFavorite(favorite)
+	})
+}
+
+func (r *FileRepository) SaveTransfer(transfer *types.Transfer) error {
+	return r.append(transfersFile, formatTransferLine(transfer), func() error {
+		return r.MemoryRepository.SaveTransfer(transfer)
+	})
+}
+
+func (r *FileRepository) SaveIdempotencyKey(key *types.IdempotencyKey) error {
+	return r.append(keysFile, formatKeyLine(key), func() error {
+		return r.MemoryRepository.SaveIdempotencyKey(key)
+	})
+}
+
+// remove вызывает delete, который убирает сущность из памяти, и переписывает
+// файлы, как Compact: в файлах только дописываются строки, и иначе удалённая
+// запись загрузилась бы снова после перезапуска. Удаляет сервис редко - при
+// откате неудавшегося импорта, - так что переписывать всё не дорого.
+func (r *FileRepository) remove(delete func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := delete()
+	if err != nil {
+		return err
+	}
+	return r.compact()
+}
+
+func (r *FileRepository) DeleteAccount(accountID int64) error {
+	return r.remove(func() error { return r.MemoryRepository.DeleteAccount(accountID) })
+}
+
+func (r *FileRepository) DeletePayment(paymentID string) error {
+	return r.remove(func() error { return r.MemoryRepository.DeletePayment(paymentID) })
+}
+
+func (r *FileRepository) DeleteFavorite(favoriteID string) error {
+	return r.remove(func() error { return r.MemoryRepository.DeleteFavorite(favoriteID) })
+}
+
+func (r *FileRepository) DeleteTransfer(transferID string) error {
+	return r.remove(func() error { return r.MemoryRepository.DeleteTransfer(transferID) })
+}
+
+func (r *FileRepository) DeleteIdempotencyKey(key string) error {
+	return r.remove(func() error { return r.MemoryRepository.DeleteIdempotencyKey(key) })
+}
+
+func (r *FileRepository) DeleteTierChange(changeID string) error {
+	return r.remove(func() error { return r.MemoryRepository.DeleteTierChange(changeID) })
+}
+
+// PruneIdempotencyKeys удаляет ключи только из памяти: строки остаются в
+// файле до Compact, а после перезапуска загружаются уже просроченными.
+func (r *FileRepository) PruneIdempotencyKeys(cutoff time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.MemoryRepository.PruneIdempotencyKeys(cutoff)
+}
+
+func (r *FileRepository) SaveTierChange(change *types.TierChange) error {
+	return r.append(tierChangesFile, formatTierChangeLine(change), func() error {
+		return r.MemoryRepository.SaveTierChange(change)
+	})
+}
+
+func (r *FileRepository) SaveLedgerEntry(entry *types.LedgerEntry) error {
+	return r.append(ledgerFile, formatLedgerLine(entry), func() error {
+		return r.MemoryRepository.SaveLedgerEntry(entry)
+	})
+}
+
+func (r *FileRepository) DeleteLedgerEntry(entryID string) error {
+	return r.remove(func() error { return r.MemoryRepository.DeleteLedgerEntry(entryID) })
+}
+
+// Compact переписывает файлы хранилища, оставляя по одной строке на сущность.
+// Каждый файл пишется во временный и переименовывается поверх старого, так
+// что при падении посреди Compact на диске остаётся старая или новая версия
+// файла, но не обрезанная.
+func (r *FileRepository) Compact() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.compact()
+}
+
+// compact - Compact под уже взятым r.mu.
+func (r *FileRepository) compact() error {
+	files, err := dumpFiles(r.MemoryRepository, nil)
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		file := &files[i]
+		path := filepath.Join(r.dir, file.name)
+		err = writeFileAtomicFunc(path, file.writeTo)
+		if err != nil {
+			return err
+		}
+
+		// Открытый дескриптор смотрит на старый, уже заменённый файл.
+		reopened, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		old := r.files[file.name]
+		r.files[file.name] = reopened
+		if old != nil {
+			old.Close()
+		}
+	}
+	return nil
+}
+
+// Close закрывает файлы хранилища.
+func (r *FileRepository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result error
+	for name, file := range r.files {
+		err := file.Close()
+		if err != nil && result == nil {
+			result = err
+		}
+		delete(r.files, name)
+	}
+	return result
+}
@@ -0,0 +1,288 @@
+package wallet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestFileRepository_restart(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := NewService(repo)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 1000_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 2000_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = repo.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	repo, err = OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer repo.Close()
+	restarted, err := NewService(repo)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := restarted.FindAccountByID(account.ID)
+	if err != nil {
+		t.Errorf("FindAccountByID(): error after restart = %v", err)
+		return
+	}
+	if got.Balance != 8_000_00 {
+		t.Errorf("FindAccountByID(): wrong balance after restart, account = %v", got)
+	}
+
+	rejected, err := restarted.FindPaymentByID(payment.ID)
+	if err != nil || rejected.Status != types.PaymentStatusFail {
+		t.Errorf("FindPaymentByID(): payment = %v, error = %v", rejected, err)
+	}
+
+	err = restarted.VerifyLedger()
+	if err != nil {
+		t.Errorf("VerifyLedger(): error after restart = %v", err)
+	}
+
+	next, err := restarted.RegisterAccount("+992935444994")
+	if err != nil || next.ID != account.ID+1 {
+		t.Errorf("RegisterAccount(): must continue numbering, account = %v, error = %v", next, err)
+	}
+}
+
+func TestFileRepository_Compact(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer repo.Close()
+	s, err := NewService(repo)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 3; i++ {
+		err = s.Deposit(account.ID, 100_00)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Compact сохраняет права заменяемого файла.
+	err = os.Chmod(filepath.Join(dir, accountsFile), 0640)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = repo.Compact()
+	if err != nil {
+		t.Errorf("Compact(): error = %v", err)
+		return
+	}
+	info, err := os.Stat(filepath.Join(dir, accountsFile))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Compact(): file mode = %v, want 0640", info.Mode())
+		return
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, accountsFile))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 || lines[0] != strings.TrimSuffix(accountsSchema.header(), "\n") {
+		t.Errorf("Compact(): must leave header and one line per account, got %q", data)
+		return
+	}
+	reader := newDumpReader(accountsSchema)
+	_, err = reader.read(lines[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fields, err := reader.read(lines[1])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	compacted, err := parseAccountFields(fields)
+	if err != nil || compacted.Balance != 300_00 {
+		t.Errorf("Compact(): wrong account = %v, error = %v", compacted, err)
+		return
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 7 {
+		t.Errorf("Compact(): temp files left behind, entries = %d, error = %v", len(entries), err)
+		return
+	}
+
+	// Дозапись после Compact попадает в новый файл.
+	err = s.Deposit(account.ID, 100_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reopened, err := OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reopened.Close()
+	got, err := reopened.AccountByID(account.ID)
+	if err != nil || got.Balance != 400_00 {
+		t.Errorf("OpenFileRepository(): account after Compact = %v, error = %v", got, err)
+	}
+}
+
+func TestFileRepository_Delete(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = repo.SaveAccount(&types.Account{ID: 1, Phone: "+992880806776"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = repo.SaveAccount(&types.Account{ID: 2, Phone: "+992935444994"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = repo.SavePayment(&types.Payment{ID: "p1", AccountID: 2, Amount: 100, Category: "auto", Status: types.PaymentStatusInProgress})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = repo.DeletePayment("p1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = repo.DeleteAccount(2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = repo.DeleteAccount(2)
+	if err != ErrAccountNotFound {
+		t.Errorf("DeleteAccount(): must return ErrAccountNotFound for deleted account, returned %v", err)
+		return
+	}
+	err = repo.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	repo, err = OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer repo.Close()
+
+	accounts, err := repo.Accounts()
+	if err != nil || len(accounts) != 1 || accounts[0].ID != 1 {
+		t.Errorf("Accounts(): deleted account loaded after restart, accounts = %v, error = %v", accounts, err)
+		return
+	}
+	_, err = repo.AccountByPhone("+992935444994")
+	if err != ErrAccountNotFound {
+		t.Errorf("AccountByPhone(): deleted account found, error = %v", err)
+		return
+	}
+	payments, err := repo.PaymentsByAccount(2)
+	if err != nil || len(payments) != 0 {
+		t.Errorf("PaymentsByAccount(): deleted payment loaded after restart, payments = %v, error = %v", payments, err)
+		return
+	}
+	// Хранилище пишет и после удаления: дескрипторы переоткрыты.
+	err = repo.SaveAccount(&types.Account{ID: 3, Phone: "+992935444994"})
+	if err != nil {
+		t.Errorf("SaveAccount(): error after delete = %v", err)
+	}
+}
+
+func TestFileRepository_tornLine(t *testing.T) {
+	dir := t.TempDir()
+
+	err := WriteToFile(filepath.Join(dir, accountsFile), "1;+992880806776;900000;\n2;+9929354")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	repo, err := OpenFileRepository(dir)
+	if err != nil {
+		t.Errorf("OpenFileRepository(): error = %v", err)
+		return
+	}
+	defer repo.Close()
+
+	accounts, err := repo.Accounts()
+	if err != nil || len(accounts) != 1 {
+		t.Errorf("Accounts(): torn line must be dropped, accounts = %v, error = %v", accounts, err)
+	}
+}
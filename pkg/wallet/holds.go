@@ -0,0 +1,310 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrHoldExpired = errors.New("hold expired")
+var ErrCaptureExceedsHold = errors.New("capture exceeds hold")
+
+// DefaultHoldTTL - срок удержания, если SetHoldTTL не вызывали.
+const DefaultHoldTTL = 7 * 24 * time.Hour
+
+// SetHoldTTL задаёт срок, через который истекают удержания, созданные
+// Authorize. Уже созданных удержаний новый срок не касается.
+func (s *Service) SetHoldTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.holdTTL = ttl
+}
+
+// Authorize блокирует amount на счёте без списания: доступный баланс
+// уменьшается, текущий остаётся прежним. Удержание - платёж в статусе
+// AUTHORIZED; деньги списывает Capture, освобождает Void или истечение срока.
+// Комиссия с удержаний не берётся, а лимиты платежей проверяются уже здесь:
+// Capture списывает не больше удержанного.
+func (s *Service) Authorize(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkLimits(accountID, amount, category)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkTier(accountID, OperationPay, amount, false)
+	if err != nil {
+		return nil, err
+	}
+	ttl := s.holdTTL
+	if ttl <= 0 {
+		ttl = DefaultHoldTTL
+	}
+	paymentID := uuid.New().String()
+	err = s.begin(&walRecord{Op: opAuthorize, ID: paymentID, AccountID: accountID, Amount: amount, Category: category, TTL: ttl})
+	defer s.end()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.authorize(paymentID, accountID, amount, category, ttl)
+}
+
+func (s *Service) authorize(paymentID string, accountID int64, amount types.Money, category types.PaymentCategory, ttl time.Duration) (*types.Payment, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	_, err := s.expireHolds(accountID)
+	if err != nil {
+		return nil, err
+	}
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.Available() < amount {
+		return nil, ErrNotEnoughBalance
+	}
+	held, err := account.Held.Add(amount)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	account.Held = held
+	account.UpdatedAt = now
+	err = s.repository().SaveAccount(account)
+	if err != nil {
+		return nil, err
+	}
+
+	hold := &types.Payment{
+		ID:         paymentID,
+		AccountID:  accountID,
+		Amount:     amount,
+		Currency:   accountCurrency(account),
+		Category:   category,
+		Status:     types.PaymentStatusAuthorized,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Authorized: amount,
+		ExpiresAt:  now.Add(ttl),
+	}
+	err = s.repository().SavePayment(hold)
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// Capture списывает по удержанию amount - всю заблокированную сумму или её
+// часть; остаток удержания освобождается. Проведённое удержание - обычный
+// платёж в статусе OK, его можно вернуть через Refund.
+func (s *Service) Capture(paymentID string, amount types.Money) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.begin(&walRecord{Op: opCapture, Ref: paymentID, Amount: amount})
+	defer s.end()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.capture(paymentID, amount)
+}
+
+func (s *Service) capture(paymentID string, amount types.Money) (*types.Payment, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	hold, err := s.activeHold(paymentID, types.PaymentStatusOk)
+	if err != nil {
+		return nil, err
+	}
+	if amount > hold.Amount {
+		return nil, fmt.Errorf("%w: payment %s, held %d, requested %d",
+			ErrCaptureExceedsHold, hold.ID, hold.Amount, amount)
+	}
+	account, err := s.findAccountByID(hold.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.Balance < amount {
+		return nil, ErrNotEnoughBalance
+	}
+
+	err = s.release(hold)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := s.post(EntryPayment, hold.ID,
+		types.Posting{Account: customerLedgerAccount(hold.AccountID), Amount: -amount},
+		types.Posting{Account: LedgerMerchantClearing, Amount: amount},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	hold.Amount = amount
+	hold.Status = types.PaymentStatusOk
+	hold.UpdatedAt = entry.CreatedAt
+	err = s.repository().SavePayment(hold)
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// Void снимает удержание целиком: AUTHORIZED -> VOIDED, ничего не списывается.
+func (s *Service) Void(paymentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.begin(&walRecord{Op: opVoid, Ref: paymentID})
+	defer s.end()
+	if err != nil {
+		return err
+	}
+
+	return s.void(paymentID)
+}
+
+func (s *Service) void(paymentID string) error {
+	hold, err := s.activeHold(paymentID, types.PaymentStatusVoided)
+	if err != nil {
+		return err
+	}
+	err = s.release(hold)
+	if err != nil {
+		return err
+	}
+
+	hold.Status = types.PaymentStatusVoided
+	hold.UpdatedAt = s.now()
+	return s.repository().SavePayment(hold)
+}
+
+// ExpireHolds переводит в EXPIRED все истёкшие удержания и возвращает, сколько
+// их было. Операции со счётом и так освобождают его истёкшие удержания;
+// ExpireHolds нужен, чтобы доступный баланс обновился и у счетов без операций.
+func (s *Service) ExpireHolds() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.begin(&walRecord{Op: opExpireHolds})
+	defer s.end()
+	if err != nil {
+		return 0, err
+	}
+
+	return s.expireAllHolds()
+}
+
+func (s *Service) expireAllHolds() (int, error) {
+	accounts, err := s.repository().Accounts()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, account := range accounts {
+		expired, err := s.expireHolds(account.ID)
+		total += expired
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// expireHolds освобождает истёкшие удержания счёта. Срок сверяется с временем
+// операции, поэтому при повторе журнала удержания истекают там же, где и
+// при исходных вызовах.
+func (s *Service) expireHolds(accountID int64) (int, error) {
+	payments, err := s.repository().PaymentsByAccount(accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	now := s.now()
+	expired := 0
+	for i := range payments {
+		hold := &payments[i]
+		if hold.Status != types.PaymentStatusAuthorized || now.Before(hold.ExpiresAt) {
+			continue
+		}
+		err = s.release(hold)
+		if err != nil {
+			return expired, err
+		}
+		hold.Status = types.PaymentStatusExpired
+		hold.UpdatedAt = now
+		err = s.repository().SavePayment(hold)
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// activeHold возвращает действующее удержание, предварительно освободив
+// истёкшие удержания его счёта. to - статус, в который удержание переводят.
+func (s *Service) activeHold(paymentID string, to types.PaymentStatus) (*types.Payment, error) {
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPrimary(payment)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.expireHolds(payment.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	payment, err = s.findPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch payment.Status {
+	case types.PaymentStatusAuthorized:
+		return payment, nil
+	case types.PaymentStatusExpired:
+		return nil, fmt.Errorf("%w: payment %s, expired at %s",
+			ErrHoldExpired, payment.ID, payment.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil, &TransitionError{PaymentID: payment.ID, From: payment.Status, To: to}
+}
+
+// release снимает блокировку суммы удержания со счёта.
+func (s *Service) release(hold *types.Payment) error {
+	account, err := s.findAccountByID(hold.AccountID)
+	if err != nil {
+		return err
+	}
+	account.Held -= hold.Amount
+	account.UpdatedAt = s.now()
+	return s.repository().SaveAccount(account)
+}
+
+// block блокирует на счёте сумму удержания hold.
+func (s *Service) block(hold *types.Payment) error {
+	account, err := s.findAccountByID(hold.AccountID)
+	if err != nil {
+		return err
+	}
+	held, err := account.Held.Add(hold.Amount)
+	if err != nil {
+		return err
+	}
+	account.Held = held
+	account.UpdatedAt = s.now()
+	return s.repository().SaveAccount(account)
+}
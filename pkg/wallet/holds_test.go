@@ -0,0 +1,266 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestService_Authorize_capturePartial(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	hold, err := s.Authorize(account.ID, 300_00, "auto")
+	if err != nil {
+		t.Errorf("Authorize(): error = %v", err)
+		return
+	}
+	if hold.Status != types.PaymentStatusAuthorized || hold.Authorized != 300_00 || hold.ExpiresAt.IsZero() {
+		t.Errorf("Authorize(): wrong hold, hold = %v", hold)
+		return
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 1000_00 || got.Held != 300_00 || got.Available() != 700_00 {
+		t.Errorf("Authorize(): wrong balances, account = %v, error = %v", got, err)
+		return
+	}
+
+	_, err = s.Pay(account.ID, 800_00, "auto")
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Pay(): held money must not be spent, returned %v", err)
+		return
+	}
+	err = s.Confirm(hold.ID)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Confirm(): hold must be captured, not confirmed, returned %v", err)
+		return
+	}
+	_, err = s.Capture(hold.ID, 300_01)
+	if !errors.Is(err, ErrCaptureExceedsHold) {
+		t.Errorf("Capture(): must return ErrCaptureExceedsHold, returned %v", err)
+		return
+	}
+
+	captured, err := s.Capture(hold.ID, 120_00)
+	if err != nil {
+		t.Errorf("Capture(): error = %v", err)
+		return
+	}
+	if captured.Status != types.PaymentStatusOk || captured.Amount != 120_00 || captured.Authorized != 300_00 {
+		t.Errorf("Capture(): wrong payment, payment = %v", captured)
+		return
+	}
+	got, err = s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 880_00 || got.Held != 0 {
+		t.Errorf("Capture(): wrong balances, account = %v, error = %v", got, err)
+		return
+	}
+	_, err = s.Capture(hold.ID, 1)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Capture(): hold can be captured only once, returned %v", err)
+		return
+	}
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_Void_success(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	hold, err := s.Authorize(account.ID, 1000_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Void(hold.ID)
+	if err != nil {
+		t.Errorf("Void(): error = %v", err)
+		return
+	}
+	got, err := s.FindPaymentByID(hold.ID)
+	if err != nil || got.Status != types.PaymentStatusVoided {
+		t.Errorf("Void(): wrong hold, hold = %v, error = %v", got, err)
+		return
+	}
+	balance, err := s.FindAccountByID(account.ID)
+	if err != nil || balance.Balance != 1000_00 || balance.Available() != 1000_00 {
+		t.Errorf("Void(): hold not released, account = %v, error = %v", balance, err)
+		return
+	}
+	err = s.Void(hold.ID)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Void(): must return ErrIllegalTransition, returned %v", err)
+	}
+}
+
+func TestService_Authorize_expired(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC)}
+	s.SetClock(clock.Now)
+	s.SetHoldTTL(time.Hour)
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	first, err := s.Authorize(account.ID, 600_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.Authorize(account.ID, 400_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	clock.add(time.Hour)
+	_, err = s.Capture(first.ID, 600_00)
+	if !errors.Is(err, ErrHoldExpired) {
+		t.Errorf("Capture(): must return ErrHoldExpired, returned %v", err)
+		return
+	}
+	got, err := s.FindPaymentByID(second.ID)
+	if err != nil || got.Status != types.PaymentStatusExpired {
+		t.Errorf("Capture(): expired holds of the account not released, hold = %v, error = %v", got, err)
+		return
+	}
+	balance, err := s.FindAccountByID(account.ID)
+	if err != nil || balance.Held != 0 || balance.Balance != 1000_00 {
+		t.Errorf("wrong balances after expiry, account = %v, error = %v", balance, err)
+		return
+	}
+
+	_, err = s.Authorize(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	clock.add(2 * time.Hour)
+	expired, err := s.ExpireHolds()
+	if err != nil || expired != 1 {
+		t.Errorf("ExpireHolds() = %d, error = %v, want 1", expired, err)
+	}
+}
+
+func TestService_Export_holds(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	hold, err := s.Authorize(account.ID, 250_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+
+	got, err := imported.FindPaymentByID(hold.ID)
+	if err != nil || *got != *hold {
+		t.Errorf("Import(): hold = %v, error = %v, want %v", got, err, hold)
+		return
+	}
+	balance, err := imported.FindAccountByID(account.ID)
+	if err != nil || balance.Held != 250_00 {
+		t.Errorf("Import(): held amount lost, account = %v, error = %v", balance, err)
+		return
+	}
+	_, err = imported.Capture(hold.ID, 250_00)
+	if err != nil {
+		t.Errorf("Capture(): error = %v", err)
+	}
+}
+
+func TestOpen_replayHolds(t *testing.T) {
+	dir := t.TempDir()
+	clock := &testClock{now: time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC)}
+
+	s, err := Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetClock(clock.Now)
+	s.SetHoldTTL(time.Hour)
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	captured, err := s.Authorize(account.ID, 300_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Capture(captured.ID, 200_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expired, err := s.Authorize(account.ID, 500_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	clock.add(2 * time.Hour)
+	_, err = s.Pay(account.ID, 700_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): expired hold must be released, error = %v", err)
+		return
+	}
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Срок удержания берётся из журнала, а не из SetHoldTTL.
+	s, err = Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	got, err := s.FindPaymentByID(expired.ID)
+	if err != nil || got.Status != types.PaymentStatusExpired {
+		t.Errorf("hold after replay = %v, error = %v", got, err)
+		return
+	}
+	balance, err := s.FindAccountByID(account.ID)
+	if err != nil || balance.Balance != 100_00 || balance.Held != 0 {
+		t.Errorf("balance after replay = %v, error = %v", balance, err)
+	}
+}
@@ -0,0 +1,215 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+var ErrIdempotencyConflict = errors.New("idempotency key reused with different parameters")
+
+// DefaultIdempotencyRetention - сколько хранится ключ идемпотентности, если
+// SetIdempotencyRetention не вызывали.
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+// SetIdempotencyRetention задаёт, сколько после вызова его ключ защищает от
+// повтора. По истечении срока ключ можно использовать заново, а Export его
+// больше не выгружает.
+func (s *Service) SetIdempotencyRetention(retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keyRetention = retention
+}
+
+// PayWithKey - Pay с ключом идемпотентности key. Повтор с тем же ключом и
+// теми же параметрами ничего не списывает и возвращает платёж первого вызова,
+// с другими параметрами - ErrIdempotencyConflict. Ключ запоминается только
+// при успешном вызове; пустой ключ - обычный Pay.
+func (s *Service) PayWithKey(key string, accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := &walRecord{Op: opPay, Key: key, AccountID: accountID, Amount: amount, Category: category}
+	saved, err := s.idempotencyKey(record)
+	if err != nil {
+		return nil, err
+	}
+	if saved != nil {
+		return s.findPaymentByID(saved.Result)
+	}
+
+	err = s.checkLimits(accountID, amount, category)
+	if err != nil {
+		return nil, err
+	}
+	record.Fee, err = s.feeFor(category, amount)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkPaymentTier(accountID, amount, record.Fee)
+	if err != nil {
+		return nil, err
+	}
+	record.ID = uuid.New().String()
+	err = s.begin(record)
+	defer s.end()
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := s.pay(record.ID, accountID, amount, "", nil, record.Fee, category)
+	if err != nil {
+		return nil, err
+	}
+	return payment, s.saveKey(record)
+}
+
+// DepositWithKey - Deposit с ключом идемпотентности: повтор с тем же ключом
+// и суммой ничего не зачисляет.
+func (s *Service) DepositWithKey(key string, accountID int64, amount types.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := &walRecord{Op: opDeposit, Key: key, AccountID: accountID, Amount: amount}
+	saved, err := s.idempotencyKey(record)
+	if err != nil || saved != nil {
+		return err
+	}
+	err = s.checkTier(accountID, OperationDeposit, amount, true)
+	if err != nil {
+		return err
+	}
+
+	err = s.begin(record)
+	defer s.end()
+	if err != nil {
+		return err
+	}
+
+	err = s.deposit(accountID, amount, "", nil)
+	if err != nil {
+		return err
+	}
+	return s.saveKey(record)
+}
+
+// RepeatWithKey - Repeat с ключом идемпотентности: повтор с тем же ключом
+// возвращает платёж, созданный первым вызовом.
+func (s *Service) RepeatWithKey(key string, paymentID string) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := &walRecord{Op: opRepeat, Key: key, Ref: paymentID}
+	saved, err := s.idempotencyKey(record)
+	if err != nil {
+		return nil, err
+	}
+	if saved != nil {
+		return s.findPaymentByID(saved.Result)
+	}
+
+	pay, err := s.findPaymentByID(paymentID)
+	if err == nil && checkPrimary(pay) == nil {
+		err = s.checkLimits(pay.AccountID, pay.Amount, pay.Category)
+		if err != nil {
+			return nil, err
+		}
+		record.Fee, err = s.feeFor(pay.Category, pay.Amount)
+		if err != nil {
+			return nil, err
+		}
+		err = s.checkPaymentTier(pay.AccountID, pay.Amount, record.Fee)
+		if err != nil {
+			return nil, err
+		}
+	}
+	record.ID = uuid.New().String()
+	err = s.begin(record)
+	defer s.end()
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := s.repeat(record.ID, paymentID, record.Fee)
+	if err != nil {
+		return nil, err
+	}
+	return payment, s.saveKey(record)
+}
+
+// idempotencyKey ищет действующий ключ вызова record. nil без ошибки -
+// ключа нет (или его срок вышел), и вызов надо выполнить.
+func (s *Service) idempotencyKey(record *walRecord) (*types.IdempotencyKey, error) {
+	if record.Key == "" {
+		return nil, nil
+	}
+	saved, err := s.repository().IdempotencyKey(record.Key)
+	if errors.Is(err, ErrIdempotencyKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.keyExpired(saved) {
+		return nil, nil
+	}
+
+	request := keyFromRecord(record)
+	if saved.Op != request.Op || saved.AccountID != request.AccountID || saved.Ref != request.Ref ||
+		saved.Amount != request.Amount || saved.Category != request.Category {
+		return nil, fmt.Errorf("%w: %q", ErrIdempotencyConflict, record.Key)
+	}
+	return saved, nil
+}
+
+// saveKey запоминает ключ выполненного вызова. При повторе журнала ключи
+// восстанавливаются из записей так же.
+func (s *Service) saveKey(record *walRecord) error {
+	if record.Key == "" {
+		return nil
+	}
+	if !s.now().Before(s.keysPrunedAt.Add(s.retention())) {
+		err := s.pruneKeys()
+		if err != nil {
+			return err
+		}
+	}
+	return s.repository().SaveIdempotencyKey(keyFromRecord(record))
+}
+
+func (s *Service) keyExpired(key *types.IdempotencyKey) bool {
+	return !s.now().Before(key.CreatedAt.Add(s.retention()))
+}
+
+func (s *Service) retention() time.Duration {
+	if s.keyRetention <= 0 {
+		return DefaultIdempotencyRetention
+	}
+	return s.keyRetention
+}
+
+// pruneKeys удаляет из хранилища просроченные ключи. Вызывается при
+// checkpoint и из saveKey, но не чаще раза в срок хранения ключа.
+func (s *Service) pruneKeys() error {
+	now := s.now()
+	s.keysPrunedAt = now
+	return s.repository().PruneIdempotencyKeys(now.Add(-s.retention()))
+}
+
+func keyFromRecord(record *walRecord) *types.IdempotencyKey {
+	return &types.IdempotencyKey{
+		Key:       record.Key,
+		Op:        record.Op,
+		AccountID: record.AccountID,
+		Ref:       record.Ref,
+		Amount:    record.Amount,
+		Category:  record.Category,
+		Result:    record.ID,
+		CreatedAt: record.Time,
+	}
+}
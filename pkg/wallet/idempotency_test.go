@@ -0,0 +1,282 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestService_PayWithKey_retry(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment, err := s.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Errorf("PayWithKey(): error = %v", err)
+		return
+	}
+	retried, err := s.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil || retried.ID != payment.ID {
+		t.Errorf("PayWithKey(): retry = %v, error = %v, want payment %s", retried, err, payment.ID)
+		return
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 900_00 {
+		t.Errorf("PayWithKey(): retry debited again, account = %v, error = %v", got, err)
+		return
+	}
+
+	_, err = s.PayWithKey("order-1", account.ID, 200_00, "auto")
+	if !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("PayWithKey(): must return ErrIdempotencyConflict, returned %v", err)
+		return
+	}
+	err = s.DepositWithKey("order-1", account.ID, 100_00)
+	if !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("DepositWithKey(): key of another call must conflict, returned %v", err)
+		return
+	}
+	other, err := s.PayWithKey("order-2", account.ID, 100_00, "auto")
+	if err != nil || other.ID == payment.ID {
+		t.Errorf("PayWithKey(): new key = %v, error = %v", other, err)
+	}
+}
+
+func TestService_PayWithKey_failedCallNotRemembered(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 50_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != ErrNotEnoughBalance {
+		t.Errorf("PayWithKey(): returned %v, want ErrNotEnoughBalance", err)
+		return
+	}
+	err = s.DepositWithKey("topup-1", account.ID, 50_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Errorf("PayWithKey(): failed call must not hold the key, error = %v", err)
+	}
+}
+
+func TestService_DepositWithKey_retry(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		err = s.DepositWithKey("topup-1", account.ID, 100_00)
+		if err != nil {
+			t.Errorf("DepositWithKey(): error = %v", err)
+			return
+		}
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 100_00 {
+		t.Errorf("DepositWithKey(): account = %v, error = %v", got, err)
+	}
+}
+
+func TestService_RepeatWithKey_retry(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	repeated, err := s.RepeatWithKey("repeat-1", payment.ID)
+	if err != nil {
+		t.Errorf("RepeatWithKey(): error = %v", err)
+		return
+	}
+	retried, err := s.RepeatWithKey("repeat-1", payment.ID)
+	if err != nil || retried.ID != repeated.ID {
+		t.Errorf("RepeatWithKey(): retry = %v, error = %v, want payment %s", retried, err, repeated.ID)
+		return
+	}
+	_, err = s.RepeatWithKey("repeat-1", repeated.ID)
+	if !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("RepeatWithKey(): must return ErrIdempotencyConflict, returned %v", err)
+		return
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 800_00 {
+		t.Errorf("RepeatWithKey(): account = %v, error = %v", got, err)
+	}
+}
+
+func TestService_PayWithKey_retention(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC)}
+	s.SetClock(clock.Now)
+	s.SetIdempotencyRetention(time.Hour)
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment, err := s.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	clock.add(time.Hour)
+	again, err := s.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil || again.ID == payment.ID {
+		t.Errorf("PayWithKey(): expired key must not be used, payment = %v, error = %v", again, err)
+		return
+	}
+
+	// Export не выгружает ключи с вышедшим сроком.
+	clock.add(time.Hour)
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	report, err := imported.ImportWithOptions(dir, ImportOptions{})
+	if err != nil || report.Keys.Created != 0 {
+		t.Errorf("Import(): report = %v, error = %v", report, err)
+	}
+}
+
+func TestService_PayWithKey_pruneExpired(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC)}
+	s.SetClock(clock.Now)
+	s.SetIdempotencyRetention(time.Hour)
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	clock.add(2 * time.Hour)
+	_, err = s.PayWithKey("order-2", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	keys, err := s.repository().IdempotencyKeys()
+	if err != nil || len(keys) != 1 || keys[0].Key != "order-2" {
+		t.Errorf("PayWithKey(): expired keys must be pruned, keys = %v, error = %v", keys, err)
+	}
+}
+
+func TestService_Export_idempotencyKeys(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	report, err := imported.ImportWithOptions(dir, ImportOptions{})
+	if err != nil || report.Keys.Created != 1 {
+		t.Errorf("Import(): report = %v, error = %v", report, err)
+		return
+	}
+
+	retried, err := imported.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil || retried.ID != payment.ID {
+		t.Errorf("PayWithKey() after import = %v, error = %v, want payment %s", retried, err, payment.ID)
+		return
+	}
+	_, err = imported.PayWithKey("order-1", account.ID, 1, "auto")
+	if !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("PayWithKey(): must return ErrIdempotencyConflict after import, returned %v", err)
+	}
+}
+
+func TestOpen_replayIdempotencyKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.DepositWithKey("topup-1", account.ID, 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s, err = Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	err = s.DepositWithKey("topup-1", account.ID, 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	retried, err := s.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil || retried.ID != payment.ID {
+		t.Errorf("PayWithKey() after replay = %v, error = %v, want payment %s", retried, err, payment.ID)
+		return
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 900_00 {
+		t.Errorf("balance after replay = %v, error = %v", got, err)
+	}
+}
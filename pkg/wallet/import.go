@@ -0,0 +1,770 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+var ErrDuplicatePhone = errors.New("phone belongs to another account")
+var ErrNegativeBalance = errors.New("negative balance")
+var ErrImportConflict = errors.New("import conflict")
+
+// ImportMode - что делать с записью, которую не удалось разобрать или применить.
+type ImportMode int
+
+const (
+	// ImportFailFast - остановиться на первой плохой записи (так работает Import).
+	ImportFailFast ImportMode = iota
+	// ImportCollectErrors - пропустить плохую запись, продолжить и вернуть
+	// все ошибки в ImportReport.
+	ImportCollectErrors
+)
+
+// ImportStrategy - как импорт поступает с записью, ID которой в сервисе уже есть.
+type ImportStrategy int
+
+const (
+	// ImportPreserveIDs - записи сохраняют ID из выгрузки, существующие
+	// обновляются, статус платежа меняется только по таблице переходов.
+	// Так работает Import.
+	ImportPreserveIDs ImportStrategy = iota
+	// ImportSkipExisting - существующие записи остаются как есть, добавляются только новые.
+	ImportSkipExisting
+	// ImportOverwrite - выгрузка главнее: существующие записи заменяются
+	// целиком, в том числе статусы платежей без проверки переходов.
+	ImportOverwrite
+	// ImportFailOnConflict - любая запись с уже занятым ID - ошибка ErrImportConflict.
+	ImportFailOnConflict
+	// ImportMergeByPhone - счета сопоставляются по телефону, а не по ID:
+	// счёт с тем же телефоном обновляется, остальные заводятся с новыми ID.
+	// AccountID платежей, избранного и переводов переводятся на эти счета.
+	ImportMergeByPhone
+)
+
+// ImportOptions - настройки ImportWithOptions.
+type ImportOptions struct {
+	Mode     ImportMode
+	Strategy ImportStrategy
+	// DryRun - только разобрать выгрузку и составить отчёт, ничего не меняя.
+	DryRun bool
+}
+
+// maxDumpLine - самая длинная строка dump-файла, которую читает импорт.
+// Файл читается построчно, так что памяти нужно не больше одной строки.
+const maxDumpLine = 1 << 20
+
+// LineError - ошибка в конкретной строке файла выгрузки.
+type LineError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// ImportReport - итог ImportWithOptions: сколько записей загружено и какие
+// строки пропущены. В режиме ImportCollectErrors отчёт с ошибками
+// возвращается и как error.
+//
+// При DryRun Records - число записей без замечаний, по видам они
+// разложены в Accounts, Payments, Favorites, Transfers, Keys, TierChanges и Ledger, а в Errors
+// собраны все замечания, в том числе ссылки на неизвестные счета,
+// повторные телефоны и отрицательные балансы.
+type ImportReport struct {
+	Records     int
+	Errors      []*LineError
+	DryRun      bool
+	Accounts    ImportCounts
+	Payments    ImportCounts
+	Favorites   ImportCounts
+	Transfers   ImportCounts
+	Keys        ImportCounts // ключи идемпотентности
+	TierChanges ImportCounts // аудит смены уровня идентификации
+	Ledger      ImportCounts // записи главной книги
+}
+
+// ImportCounts - сколько записей одного вида импорт создал, обновил
+// и пропустил (ImportSkipExisting).
+type ImportCounts struct {
+	Created int
+	Updated int
+	Skipped int
+}
+
+func (r *ImportReport) Error() string {
+	if len(r.Errors) == 1 {
+		return r.Errors[0].Error()
+	}
+	lines := make([]string, 0, len(r.Errors))
+	for _, err := range r.Errors {
+		lines = append(lines, err.Error())
+	}
+	return fmt.Sprintf("%d bad records: %s", len(r.Errors), strings.Join(lines, "; "))
+}
+
+// ImportWithOptions - Import с выбором реакции на плохие записи.
+// С DryRun сервис не меняется, а ошибка возвращается, только если
+// выгрузку не удалось прочитать: замечания к записям - в отчёте.
+func (s *Service) ImportWithOptions(dir string, options ImportOptions) (*ImportReport, error) {
+	return s.importWithOptions(func(p *importPlan, options ImportOptions) error {
+		return p.run(dir, options)
+	}, options)
+}
+
+// importSource читает выгрузку одного формата и передаёт её записи плану.
+type importSource func(p *importPlan, options ImportOptions) error
+
+// importWithOptions - ImportWithOptions для выгрузки любого формата.
+func (s *Service) importWithOptions(source importSource, options ImportOptions) (*ImportReport, error) {
+	if options.DryRun {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		return s.planImport(source, options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report, err := s.importFrom(source, options)
+	if err != nil {
+		return report, err
+	}
+	if s.wal != nil {
+		err = s.checkpoint()
+	}
+	return report, err
+}
+
+func (s *Service) importDir(dir string) error {
+	_, err := s.importFrom(func(p *importPlan, options ImportOptions) error {
+		return p.run(dir, options)
+	}, ImportOptions{})
+	return err
+}
+
+// importFrom грузит выгрузку в копию состояния сервиса и переносит
+// результат в сервис, только если загрузка прошла. В режиме ImportFailFast
+// импорт получается всё-или-ничего: при ошибке сервис остаётся прежним,
+// а ошибка (*LineError) называет файл и строку.
+func (s *Service) importFrom(source importSource, options ImportOptions) (*ImportReport, error) {
+	report := &ImportReport{}
+	err := s.staged(func(staging *Service) error {
+		var err error
+		report, err = staging.importStaged(source, options)
+		if err == report {
+			// Плохие строки пропущены по ImportCollectErrors - остальное применяем.
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return report, err
+	}
+	if len(report.Errors) != 0 {
+		return report, report
+	}
+	return report, nil
+}
+
+// staged выполняет apply над сервисом-черновиком: его хранилище пишет
+// изменения, в том числе записи книги, в память поверх хранилища сервиса.
+// Если apply вернул ошибку, черновик выбрасывается. Иначе изменения
+// переносятся в хранилище сервиса, а nextAccountID берётся из черновика.
+func (s *Service) staged(apply func(staging *Service) error) error {
+	repo := newStagingRepository(s.repository())
+	staging := &Service{
+		repo:          repo,
+		nextAccountID: s.nextAccountID,
+		clock:         s.clock,
+		opTime:        s.opTime,
+	}
+	err := apply(staging)
+	if err != nil {
+		return err
+	}
+
+	err = repo.commit()
+	if err != nil {
+		return err
+	}
+	s.nextAccountID = staging.nextAccountID
+	return nil
+}
+
+func (s *Service) importStaged(source importSource, options ImportOptions) (*ImportReport, error) {
+	report := &ImportReport{}
+	err := source(s.newImportPlan(options.Strategy, report, true), options)
+	if err != nil {
+		return report, err
+	}
+	if len(report.Errors) != 0 {
+		return report, report
+	}
+	return report, nil
+}
+
+// resolveConflict решает, что делать с записью, ID которой, возможно,
+// уже занят. Возвращает false, если запись надо пропустить.
+func resolveConflict(strategy ImportStrategy, counts *ImportCounts, kind string, id string, exists bool) (bool, error) {
+	if !exists {
+		return true, nil
+	}
+	switch strategy {
+	case ImportSkipExisting:
+		counts.Skipped++
+		return false, nil
+	case ImportFailOnConflict:
+		return false, fmt.Errorf("%w: %s %s already exists", ErrImportConflict, kind, id)
+	}
+	return true, nil
+}
+
+// count учитывает применённую запись в отчёте.
+func (counts *ImportCounts) count(exists bool) {
+	if exists {
+		counts.Updated++
+	} else {
+		counts.Created++
+	}
+}
+
+// importDumpFile читает dump-файл построчно и передаёт поля каждой записи
+// в action. Пустые строки пропускаются. Ошибки разбора и применения
+// возвращаются как *LineError; в режиме ImportCollectErrors они
+// складываются в report, и чтение продолжается.
+func importDumpFile(path string, schema dumpSchema, action func(fields []string) error, options ImportOptions, report *ImportReport) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		log.Println(ErrFileNotFound.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	name := filepath.Base(path)
+	reader := newDumpReader(schema)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxDumpLine)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+
+		fields, err := reader.read(text)
+		if err == nil && fields == nil {
+			continue
+		}
+		if err == nil {
+			err = action(fields)
+		}
+		if err != nil {
+			lineErr := &LineError{File: name, Line: line, Err: err}
+			// Без понятного заголовка остальные строки файла не разобрать.
+			if options.Mode == ImportFailFast || isDumpHeader(text) {
+				return lineErr
+			}
+			report.Errors = append(report.Errors, lineErr)
+			continue
+		}
+		report.Records++
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return &LineError{File: name, Line: line + 1, Err: err}
+	}
+	return nil
+}
+
+// importPlan - состояние, которое сервис имел бы по ходу импорта. Каждая
+// запись выгрузки сначала проверяется по нему, а с apply ещё и применяется
+// к сервису; без apply (DryRun) сервис не меняется. Так DryRun и настоящий
+// импорт отвергают одни и те же записи.
+// accountIDs - куда попал счёт выгрузки: ID в выгрузке -> ID в сервисе,
+// 0 - счёт пропущен и записи по нему загружать некуда. loaded - счета,
+// загруженные этим импортом: их Held взят из выгрузки.
+type importPlan struct {
+	s          *Service
+	strategy   ImportStrategy
+	report     *ImportReport
+	apply      bool
+	accounts   map[int64]types.Phone
+	currencies map[int64]types.Currency
+	loaded     map[int64]bool
+	phones     map[types.Phone]int64
+	accountIDs map[int64]int64
+	nextID     int64
+	payments   map[string]*types.Payment
+	favorites  map[string]bool
+	transfers  map[string]bool
+	keys       map[string]bool
+	tiers      map[string]bool
+	entries    map[string]bool
+}
+
+func (s *Service) newImportPlan(strategy ImportStrategy, report *ImportReport, apply bool) *importPlan {
+	return &importPlan{
+		s:          s,
+		strategy:   strategy,
+		report:     report,
+		apply:      apply,
+		accounts:   map[int64]types.Phone{},
+		currencies: map[int64]types.Currency{},
+		loaded:     map[int64]bool{},
+		phones:     map[types.Phone]int64{},
+		accountIDs: map[int64]int64{},
+		nextID:     s.nextAccountID,
+		payments:   map[string]*types.Payment{},
+		favorites:  map[string]bool{},
+		transfers:  map[string]bool{},
+		keys:       map[string]bool{},
+		tiers:      map[string]bool{},
+		entries:    map[string]bool{},
+	}
+}
+
+func (s *Service) planImport(source importSource, options ImportOptions) (*ImportReport, error) {
+	report := &ImportReport{DryRun: true}
+	err := source(s.newImportPlan(options.Strategy, report, false), ImportOptions{Mode: ImportCollectErrors})
+	return report, err
+}
+
+// run проверяет, а с apply и загружает dump-файлы выгрузки из dir.
+func (p *importPlan) run(dir string, options ImportOptions) error {
+	listed, err := verifyManifest(dir)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+
+	err = p.load()
+	if err != nil {
+		return err
+	}
+
+	actions := []struct {
+		name   string
+		schema dumpSchema
+		action func(fields []string) error
+	}{
+		{accountsFile, accountsSchema, p.account},
+		{paymentsFile, paymentsSchema, p.payment},
+		{favoritesFile, favoritesSchema, p.favorite},
+		{transfersFile, transfersSchema, p.transfer},
+		{keysFile, keysSchema, p.key},
+		{tierChangesFile, tierChangesSchema, p.tierChange},
+		{ledgerFile, ledgerSchema, p.ledgerEntry},
+	}
+	for _, item := range actions {
+		// Файлы, которых нет в манифесте, остались от другой выгрузки.
+		if listed != nil && !listed[item.name] {
+			continue
+		}
+		err = importDumpFile(filepath.Join(dir, item.name), item.schema, item.action, options, p.report)
+		if err != nil {
+			log.Printf("err from import %s", item.name)
+			return err
+		}
+	}
+	return p.finish()
+}
+
+// finish сверяет с книгой балансы загруженных счетов: записи книги из
+// выгрузки к этому моменту уже загружены.
+func (p *importPlan) finish() error {
+	if !p.apply {
+		return nil
+	}
+	ids := make([]int64, 0, len(p.loaded))
+	for id := range p.loaded {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return p.s.reconcileLedger(ids, EntryImport)
+}
+
+// load запоминает счета, которые уже есть в сервисе.
+func (p *importPlan) load() error {
+	accounts, err := p.s.repository().Accounts()
+	if err != nil {
+		return err
+	}
+	for i := range accounts {
+		account := &accounts[i]
+		p.accounts[account.ID] = account.Phone
+		p.currencies[account.ID] = accountCurrency(account)
+		p.phones[account.Phone] = account.ID
+	}
+	return nil
+}
+
+// knownAccount переводит AccountID из выгрузки в ID счёта, который был бы
+// в сервисе, и проверяет, что такой счёт есть.
+func (p *importPlan) knownAccount(id int64) (int64, error) {
+	target, ok := p.accountIDs[id]
+	if !ok {
+		target = id
+	}
+	if _, ok := p.accounts[target]; !ok {
+		return 0, fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+	}
+	return target, nil
+}
+
+func (p *importPlan) account(fields []string) error {
+	record, err := parseAccountFields(fields)
+	if err != nil {
+		return err
+	}
+	return p.importAccount(record)
+}
+
+// importAccount и другие import* проверяют и загружают уже разобранную
+// запись - из dump-файла, JSON или CSV.
+func (p *importPlan) importAccount(record *types.Account) error {
+	if record.Balance < 0 || record.Held < 0 {
+		return fmt.Errorf("%w: account %d", ErrNegativeBalance, record.ID)
+	}
+	source := record.ID
+
+	owner, phoneTaken := p.phones[record.Phone]
+	if p.strategy == ImportMergeByPhone {
+		if phoneTaken {
+			record.ID = owner
+		} else {
+			record.ID = p.nextID + 1
+		}
+	} else if phoneTaken && owner != record.ID {
+		return fmt.Errorf("%w: %s, account %d", ErrDuplicatePhone, record.Phone, owner)
+	}
+	phone, exists := p.accounts[record.ID]
+
+	ok, err := resolveConflict(p.strategy, &p.report.Accounts, "account", strconv.FormatInt(source, 10), exists)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Пропущенный счёт с тем же телефоном - тот же клиент, его записи
+		// ложатся на имеющийся счёт. Счёт с чужим телефоном - другой
+		// клиент, и записи выгрузки по нему к этому счёту не привязываются.
+		if phone == record.Phone {
+			p.accountIDs[source] = record.ID
+		} else {
+			p.accountIDs[source] = 0
+		}
+		return nil
+	}
+	if p.apply {
+		err = p.s.importAccount(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.accountIDs[source] = record.ID
+	if exists {
+		delete(p.phones, phone)
+	}
+	if record.ID > p.nextID {
+		p.nextID = record.ID
+	}
+	p.accounts[record.ID] = record.Phone
+	p.currencies[record.ID] = accountCurrency(record)
+	p.loaded[record.ID] = true
+	p.phones[record.Phone] = record.ID
+	p.report.Accounts.count(exists)
+	return nil
+}
+
+func (p *importPlan) payment(fields []string) error {
+	record, err := parsePaymentFields(fields)
+	if err != nil {
+		return err
+	}
+	return p.importPayment(record)
+}
+
+// importPayment: счёт платежа должен быть известен, а валюта - совпадать
+// с валютой счёта.
+func (p *importPlan) importPayment(record *types.Payment) error {
+	var err error
+	record.AccountID, err = p.knownAccount(record.AccountID)
+	if err != nil {
+		return err
+	}
+	currency := record.Currency
+	if currency == "" {
+		currency = types.DefaultCurrency
+	}
+	if currency != p.currencies[record.AccountID] {
+		return fmt.Errorf("%w: account %d is in %s, payment %s in %s",
+			ErrCurrencyMismatch, record.AccountID, p.currencies[record.AccountID], record.ID, currency)
+	}
+
+	existing, exists := p.payments[record.ID]
+	if !exists {
+		existing, err = p.s.repository().PaymentByID(record.ID)
+		exists = err == nil
+	}
+	ok, err := resolveConflict(p.strategy, &p.report.Payments, "payment", record.ID, exists)
+	if !ok || err != nil {
+		return err
+	}
+	if exists && p.strategy != ImportOverwrite {
+		err = checkTransition(existing, record.Status)
+		if err != nil {
+			return err
+		}
+	}
+	if p.apply {
+		if p.strategy == ImportOverwrite {
+			err = p.s.repository().SavePayment(record)
+		} else {
+			err = p.s.importPayment(record)
+		}
+		if err != nil {
+			return err
+		}
+		if !exists {
+			existing = nil
+		}
+		err = p.hold(existing, record)
+		if err != nil {
+			return err
+		}
+	}
+	p.payments[record.ID] = record
+	p.report.Payments.count(exists)
+	return nil
+}
+
+// hold переносит на счета блокировки удержаний. У счёта из выгрузки Held
+// уже учтён, у остальных блокировку меняет сам платёж: снимается блокировка
+// прежней версии и ставится блокировка новой.
+func (p *importPlan) hold(previous, payment *types.Payment) error {
+	if previous != nil && previous.Status == types.PaymentStatusAuthorized && !p.loaded[previous.AccountID] {
+		err := p.s.release(previous)
+		if err != nil {
+			return err
+		}
+	}
+	if payment.Status == types.PaymentStatusAuthorized && !p.loaded[payment.AccountID] {
+		return p.s.block(payment)
+	}
+	return nil
+}
+
+func (p *importPlan) favorite(fields []string) error {
+	record, err := parseFavoriteFields(fields)
+	if err != nil {
+		return err
+	}
+	return p.importFavorite(record)
+}
+
+func (p *importPlan) importFavorite(record *types.Favorite) error {
+	var err error
+	record.AccountID, err = p.knownAccount(record.AccountID)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.s.repository().FavoriteByID(record.ID)
+	exists := p.favorites[record.ID] || err == nil
+	ok, err := resolveConflict(p.strategy, &p.report.Favorites, "favorite", record.ID, exists)
+	if !ok || err != nil {
+		return err
+	}
+	if p.apply {
+		err = p.s.importFavorite(record)
+		if err != nil {
+			return err
+		}
+	}
+	p.favorites[record.ID] = true
+	p.report.Favorites.count(exists)
+	return nil
+}
+
+func (p *importPlan) transfer(fields []string) error {
+	record, err := parseTransferFields(fields)
+	if err != nil {
+		return err
+	}
+	return p.importTransfer(record)
+}
+
+func (p *importPlan) importTransfer(record *types.Transfer) error {
+	var err error
+	record.FromAccountID, err = p.knownAccount(record.FromAccountID)
+	if err != nil {
+		return err
+	}
+	record.ToAccountID, err = p.knownAccount(record.ToAccountID)
+	if err != nil {
+		return err
+	}
+	if !isKnownStatus(record.Status) {
+		return fmt.Errorf("%w: %q", ErrUnknownPaymentStatus, record.Status)
+	}
+
+	_, err = p.s.repository().TransferByID(record.ID)
+	exists := p.transfers[record.ID] || err == nil
+	ok, err := resolveConflict(p.strategy, &p.report.Transfers, "transfer", record.ID, exists)
+	if !ok || err != nil {
+		return err
+	}
+	if p.apply {
+		err = p.s.importTransfer(record)
+		if err != nil {
+			return err
+		}
+	}
+	p.transfers[record.ID] = true
+	p.report.Transfers.count(exists)
+	return nil
+}
+
+func (p *importPlan) key(fields []string) error {
+	record, err := parseKeyFields(fields)
+	if err != nil {
+		return err
+	}
+	return p.importKey(record)
+}
+
+func (p *importPlan) importKey(record *types.IdempotencyKey) error {
+	var err error
+	if record.AccountID != 0 {
+		record.AccountID, err = p.knownAccount(record.AccountID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = p.s.repository().IdempotencyKey(record.Key)
+	exists := p.keys[record.Key] || err == nil
+	ok, err := resolveConflict(p.strategy, &p.report.Keys, "idempotency key", record.Key, exists)
+	if !ok || err != nil {
+		return err
+	}
+	if p.apply {
+		err = p.s.repository().SaveIdempotencyKey(record)
+		if err != nil {
+			return err
+		}
+	}
+	p.keys[record.Key] = true
+	p.report.Keys.count(exists)
+	return nil
+}
+
+func (p *importPlan) tierChange(fields []string) error {
+	record, err := parseTierChangeFields(fields)
+	if err != nil {
+		return err
+	}
+	return p.importTierChange(record)
+}
+
+func (p *importPlan) importTierChange(record *types.TierChange) error {
+	var err error
+	record.AccountID, err = p.knownAccount(record.AccountID)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.s.repository().TierChangeByID(record.ID)
+	exists := p.tiers[record.ID] || err == nil
+	ok, err := resolveConflict(p.strategy, &p.report.TierChanges, "tier change", record.ID, exists)
+	if !ok || err != nil {
+		return err
+	}
+	if p.apply {
+		err = p.s.repository().SaveTierChange(record)
+		if err != nil {
+			return err
+		}
+	}
+	p.tiers[record.ID] = true
+	p.report.TierChanges.count(exists)
+	return nil
+}
+
+func (p *importPlan) ledgerEntry(fields []string) error {
+	record, err := parseLedgerFields(fields)
+	if err != nil {
+		return err
+	}
+	return p.importLedgerEntry(record)
+}
+
+// importLedgerEntry: проводки по клиентским счетам переводятся на счета
+// сервиса, запись должна быть сбалансирована. Проведённая запись не
+// меняется, поэтому уже загруженная пропускается при любой стратегии,
+// кроме ImportFailOnConflict.
+func (p *importPlan) importLedgerEntry(record *types.LedgerEntry) error {
+	var sum types.Money
+	for i := range record.Postings {
+		posting := &record.Postings[i]
+		var err error
+		sum, err = sum.Add(posting.Amount)
+		if err != nil {
+			return err
+		}
+		id, ok := parseCustomerLedgerAccount(posting.Account)
+		if !ok {
+			continue
+		}
+		id, err = p.knownAccount(id)
+		if err != nil {
+			return err
+		}
+		posting.Account = customerLedgerAccount(id)
+	}
+	if sum != 0 {
+		return fmt.Errorf("%w: %s", ErrUnbalancedEntry, record.ID)
+	}
+
+	_, err := p.s.repository().LedgerEntryByID(record.ID)
+	exists := p.entries[record.ID] || err == nil
+	ok, err := resolveConflict(p.strategy, &p.report.Ledger, "ledger entry", record.ID, exists)
+	if !ok || err != nil {
+		return err
+	}
+	if exists {
+		p.report.Ledger.Skipped++
+		return nil
+	}
+	if p.apply {
+		err = p.s.repository().SaveLedgerEntry(record)
+		if err != nil {
+			return err
+		}
+	}
+	p.entries[record.ID] = true
+	p.report.Ledger.count(exists)
+	return nil
+}
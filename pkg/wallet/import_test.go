@@ -0,0 +1,657 @@
+package wallet
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestService_Import_emptyLine(t *testing.T) {
+	dir := t.TempDir()
+	err := WriteToFile(filepath.Join(dir, accountsFile), "1;+992880806776;900000;\n\n2;+992935444994;800000;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s := newTestService()
+	err = s.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+	account, err := s.FindAccountByID(2)
+	if err != nil || account.Balance != 800000 {
+		t.Errorf("Import(): record after empty line dropped, account = %v, error = %v", account, err)
+	}
+}
+
+func TestService_Import_lineNumber(t *testing.T) {
+	dir := t.TempDir()
+	err := WriteToFile(filepath.Join(dir, accountsFile), "1;+992880806776;900000;\n2;+992935444994;lots;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = newTestService().Import(dir)
+	var lineErr *LineError
+	if !errors.As(err, &lineErr) || lineErr.File != accountsFile || lineErr.Line != 2 {
+		t.Errorf("Import(): must return LineError for line 2, returned %v", err)
+		return
+	}
+	var numErr *strconv.NumError
+	if !errors.As(err, &numErr) {
+		t.Errorf("Import(): cause lost, returned %v", err)
+	}
+}
+
+func TestService_ImportWithOptions_collectErrors(t *testing.T) {
+	dir := t.TempDir()
+	err := WriteToFile(filepath.Join(dir, accountsFile), "1;+992880806776;900000;\nbad\n2;+992935444994;800000;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, paymentsFile),
+		"fc10959f-5f28-40e2-81bc-a70348e0549a;1;100000;auto;INPROGRESS;\n"+
+			"5a96dc44-f8fa-4605-ba33-b6cd20f1b196;2;102000;auto;LOST;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s := newTestService()
+	report, err := s.ImportWithOptions(dir, ImportOptions{Mode: ImportCollectErrors})
+	var reportErr *ImportReport
+	if !errors.As(err, &reportErr) || reportErr != report {
+		t.Errorf("ImportWithOptions(): must return report as error, returned %v", err)
+		return
+	}
+	if report.Records != 3 || len(report.Errors) != 2 {
+		t.Errorf("ImportWithOptions(): records = %d, errors = %v", report.Records, report.Errors)
+		return
+	}
+	if report.Errors[0].File != accountsFile || report.Errors[0].Line != 2 ||
+		!errors.Is(report.Errors[0].Err, ErrBadRecord) {
+		t.Errorf("ImportWithOptions(): wrong first error = %v", report.Errors[0])
+		return
+	}
+	if report.Errors[1].File != paymentsFile || report.Errors[1].Line != 2 ||
+		!errors.Is(report.Errors[1].Err, ErrUnknownPaymentStatus) {
+		t.Errorf("ImportWithOptions(): wrong second error = %v", report.Errors[1])
+		return
+	}
+
+	_, err = s.FindAccountByID(2)
+	if err != nil {
+		t.Errorf("ImportWithOptions(): good rows must be imported, error = %v", err)
+	}
+}
+
+func TestService_ImportWithOptions_dryRun(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = WriteToFile(filepath.Join(dir, accountsFile),
+		"1;+992880806776;500000;\n"+
+			"2;+992935444994;-100;\n"+
+			"3;+992880806776;100;\n"+
+			"4;+992935444995;100;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, paymentsFile),
+		payments[0].ID+";1;100000;auto;INPROGRESS;\n"+
+			"fc10959f-5f28-40e2-81bc-a70348e0549a;4;100000;auto;OK;\n"+
+			"5a96dc44-f8fa-4605-ba33-b6cd20f1b196;9;100000;auto;OK;\n"+
+			"1bb34ccd-145b-4466-b4dc-5e300f64b332;4;100000;auto;LOST;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, favoritesFile), "934a1b43-52b0-4023-919e-d4e9d7a31b3b;7;ogastus;100000;auto;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	before, err := s.FindAccountByID(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	report, err := s.ImportWithOptions(dir, ImportOptions{DryRun: true})
+	if err != nil {
+		t.Errorf("ImportWithOptions(): error = %v", err)
+		return
+	}
+	if report.Accounts != (ImportCounts{Created: 1, Updated: 1}) ||
+		report.Payments != (ImportCounts{Created: 1}) ||
+		report.Favorites != (ImportCounts{}) {
+		t.Errorf("ImportWithOptions(): wrong counts, report = %+v", report)
+		return
+	}
+
+	want := []struct {
+		file string
+		line int
+		err  error
+	}{
+		{accountsFile, 2, ErrNegativeBalance},
+		{accountsFile, 3, ErrDuplicatePhone},
+		{paymentsFile, 1, ErrIllegalTransition},
+		{paymentsFile, 3, ErrAccountNotFound},
+		{paymentsFile, 4, ErrUnknownPaymentStatus},
+		{favoritesFile, 1, ErrAccountNotFound},
+	}
+	if len(report.Errors) != len(want) {
+		t.Errorf("ImportWithOptions(): errors = %v", report.Errors)
+		return
+	}
+	for i, w := range want {
+		got := report.Errors[i]
+		if got.File != w.file || got.Line != w.line || !errors.Is(got, w.err) {
+			t.Errorf("ImportWithOptions(): error %d = %v, want %s:%d %v", i, got, w.file, w.line, w.err)
+		}
+	}
+
+	after, err := s.FindAccountByID(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if *after != *before {
+		t.Errorf("ImportWithOptions(): dry run changed account, %v -> %v", before, after)
+		return
+	}
+	_, err = s.FindAccountByID(4)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("ImportWithOptions(): dry run created account, error = %v", err)
+	}
+}
+
+func TestService_Import_preservesIDs(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = WriteToFile(filepath.Join(dir, accountsFile), "5;+992935444994;100000;\n7;+992935444995;200000;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, paymentsFile), "fc10959f-5f28-40e2-81bc-a70348e0549a;7;100000;auto;INPROGRESS;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+	payments, err := s.FilterPayments(7, 1)
+	if err != nil || len(payments) != 1 {
+		t.Errorf("FilterPayments(): payment must stay on account 7, payments = %v, error = %v", payments, err)
+		return
+	}
+	account, err := s.RegisterAccount("+992935444996")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.ID != 8 {
+		t.Errorf("RegisterAccount(): ID must be above imported IDs, got %d", account.ID)
+		return
+	}
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// newConflictImport готовит сервис со счётом 1 и отклонённым платежом
+// и выгрузку, в которой тот же счёт, новый счёт 2 и тот же платёж в INPROGRESS.
+func newConflictImport(t *testing.T) (*testService, string, string) {
+	s := newTestService()
+	_, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = WriteToFile(filepath.Join(dir, accountsFile), "1;+992880806776;500000;\n2;+992935444994;100000;\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteToFile(filepath.Join(dir, paymentsFile), payments[0].ID+";1;100000;auto;INPROGRESS;\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dir, payments[0].ID
+}
+
+func TestService_ImportWithOptions_skipExisting(t *testing.T) {
+	s, dir, paymentID := newConflictImport(t)
+
+	report, err := s.ImportWithOptions(dir, ImportOptions{Strategy: ImportSkipExisting})
+	if err != nil {
+		t.Errorf("ImportWithOptions(): error = %v", err)
+		return
+	}
+	if report.Accounts != (ImportCounts{Created: 1, Skipped: 1}) || report.Payments != (ImportCounts{Skipped: 1}) {
+		t.Errorf("ImportWithOptions(): wrong counts, report = %+v", report)
+		return
+	}
+	account, err := s.FindAccountByID(1)
+	if err != nil || account.Balance != 10_000_00 {
+		t.Errorf("ImportWithOptions(): existing account changed, account = %v, error = %v", account, err)
+		return
+	}
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil || payment.Status != types.PaymentStatusFail {
+		t.Errorf("ImportWithOptions(): existing payment changed, payment = %v, error = %v", payment, err)
+	}
+}
+
+func TestService_ImportWithOptions_skipOtherOwner(t *testing.T) {
+	s := newTestService()
+	_, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Счёт 1 в выгрузке - другой клиент: его платёж к счёту 1 сервиса не относится.
+	dir := t.TempDir()
+	err = WriteToFile(filepath.Join(dir, accountsFile), "1;+992935444994;500000;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, paymentsFile), "p1;1;100000;auto;INPROGRESS;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.ImportWithOptions(dir, ImportOptions{Strategy: ImportSkipExisting})
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("ImportWithOptions(): must return ErrAccountNotFound, returned %v", err)
+		return
+	}
+	_, err = s.FindPaymentByID("p1")
+	if err != ErrPaymentNotFound {
+		t.Errorf("ImportWithOptions(): payment of other owner imported, error = %v", err)
+	}
+}
+
+func TestService_Import_validatesLikeDryRun(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		lines string
+		want  error
+	}{
+		{"unknown account", paymentsFile, "p1;7;100000;auto;INPROGRESS;\n", ErrAccountNotFound},
+		{"unknown favorite account", favoritesFile, "f1;7;home;100000;auto;\n", ErrAccountNotFound},
+		{"negative balance", accountsFile, "2;+992935444994;-100;\n", ErrNegativeBalance},
+		{"unknown transfer status", transfersFile, "t1;1;1;100;DONE;\n", ErrUnknownPaymentStatus},
+	}
+	for _, tt := range tests {
+		s := newTestService()
+		_, err := s.addAccountWithBalance("+992880806776", 1000_00)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		dir := t.TempDir()
+		err = WriteToFile(filepath.Join(dir, tt.file), tt.lines)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		report, err := s.ImportWithOptions(dir, ImportOptions{DryRun: true})
+		if err != nil || len(report.Errors) != 1 || !errors.Is(report.Errors[0], tt.want) {
+			t.Errorf("%s: DryRun report = %+v, error = %v, want %v", tt.name, report, err, tt.want)
+			continue
+		}
+		err = s.Import(dir)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: Import() must return %v, returned %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestService_ImportWithOptions_overwrite(t *testing.T) {
+	s, dir, paymentID := newConflictImport(t)
+
+	_, err := s.ImportWithOptions(dir, ImportOptions{Strategy: ImportOverwrite})
+	if err != nil {
+		t.Errorf("ImportWithOptions(): error = %v", err)
+		return
+	}
+	account, err := s.FindAccountByID(1)
+	if err != nil || account.Balance != 500000 {
+		t.Errorf("ImportWithOptions(): account not overwritten, account = %v, error = %v", account, err)
+		return
+	}
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil || payment.Status != types.PaymentStatusInProgress {
+		t.Errorf("ImportWithOptions(): payment not overwritten, payment = %v, error = %v", payment, err)
+	}
+}
+
+func TestService_ImportWithOptions_failOnConflict(t *testing.T) {
+	s, dir, _ := newConflictImport(t)
+
+	_, err := s.ImportWithOptions(dir, ImportOptions{Strategy: ImportFailOnConflict})
+	var lineErr *LineError
+	if !errors.Is(err, ErrImportConflict) || !errors.As(err, &lineErr) || lineErr.Line != 1 {
+		t.Errorf("ImportWithOptions(): must return ErrImportConflict for line 1, returned %v", err)
+	}
+}
+
+func TestService_ImportWithOptions_mergeByPhone(t *testing.T) {
+	s := newTestService()
+	existing, err := s.addAccountWithBalance("+992880806776", 100_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = WriteToFile(filepath.Join(dir, accountsFile), "3;+992880806776;500000;\n1;+992935444994;100000;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, paymentsFile),
+		"fc10959f-5f28-40e2-81bc-a70348e0549a;3;100000;auto;INPROGRESS;\n"+
+			"5a96dc44-f8fa-4605-ba33-b6cd20f1b196;1;100000;auto;INPROGRESS;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, favoritesFile), "934a1b43-52b0-4023-919e-d4e9d7a31b3b;1;ogastus;100000;auto;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	plan, err := s.ImportWithOptions(dir, ImportOptions{Strategy: ImportMergeByPhone, DryRun: true})
+	if err != nil || len(plan.Errors) != 0 || plan.Accounts != (ImportCounts{Created: 1, Updated: 1}) {
+		t.Errorf("ImportWithOptions(): dry run report = %+v, error = %v", plan, err)
+		return
+	}
+
+	report, err := s.ImportWithOptions(dir, ImportOptions{Strategy: ImportMergeByPhone})
+	if err != nil {
+		t.Errorf("ImportWithOptions(): error = %v", err)
+		return
+	}
+	if report.Accounts != (ImportCounts{Created: 1, Updated: 1}) {
+		t.Errorf("ImportWithOptions(): wrong counts, report = %+v", report)
+		return
+	}
+
+	merged, err := s.FindAccountByID(existing.ID)
+	if err != nil || merged.Balance != 500000 {
+		t.Errorf("ImportWithOptions(): account not merged by phone, account = %v, error = %v", merged, err)
+		return
+	}
+	payment, err := s.FindPaymentByID("fc10959f-5f28-40e2-81bc-a70348e0549a")
+	if err != nil || payment.AccountID != existing.ID {
+		t.Errorf("ImportWithOptions(): payment not remapped, payment = %v, error = %v", payment, err)
+		return
+	}
+	created, err := s.FindAccountByID(2)
+	if err != nil || created.Phone != "+992935444994" {
+		t.Errorf("ImportWithOptions(): new account must get next ID, account = %v, error = %v", created, err)
+		return
+	}
+	payment, err = s.FindPaymentByID("5a96dc44-f8fa-4605-ba33-b6cd20f1b196")
+	if err != nil || payment.AccountID != created.ID {
+		t.Errorf("ImportWithOptions(): payment not remapped, payment = %v, error = %v", payment, err)
+		return
+	}
+	favorite, err := s.FindFavoriteByID("934a1b43-52b0-4023-919e-d4e9d7a31b3b")
+	if err != nil || favorite.AccountID != created.ID {
+		t.Errorf("ImportWithOptions(): favorite not remapped, favorite = %v, error = %v", favorite, err)
+	}
+}
+
+// failingRepository отказывает в сохранении платежей, когда fail выставлен.
+type failingRepository struct {
+	*MemoryRepository
+	fail bool
+}
+
+var errSaveFailed = errors.New("save failed")
+
+func (r *failingRepository) SavePayment(payment *types.Payment) error {
+	if r.fail {
+		return errSaveFailed
+	}
+	return r.MemoryRepository.SavePayment(payment)
+}
+
+func TestService_Import_rollbackCommit(t *testing.T) {
+	repo := &failingRepository{MemoryRepository: NewMemoryRepository()}
+	s, err := NewService(repo)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	before, err := s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = WriteToFile(filepath.Join(dir, accountsFile), "1;+992880806776;500000;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, paymentsFile), "p1;1;100000;auto;INPROGRESS;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Счёт переносится в хранилище раньше платежа, а платёж сохранить не удаётся.
+	repo.fail = true
+	err = s.Import(dir)
+	if !errors.Is(err, errSaveFailed) {
+		t.Errorf("Import(): must return save error, returned %v", err)
+		return
+	}
+	after, err := s.FindAccountByID(account.ID)
+	if err != nil || *after != *before {
+		t.Errorf("Import(): account not restored, account = %v, error = %v, want %v", after, err, before)
+	}
+}
+
+func TestService_Import_rollbackCommitCreated(t *testing.T) {
+	repo := &failingRepository{MemoryRepository: NewMemoryRepository()}
+	s, err := NewService(repo)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = WriteToFile(filepath.Join(dir, accountsFile), "2;+992935444994;500000;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, paymentsFile), "p1;2;100000;auto;INPROGRESS;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Новый счёт уже в хранилище, когда сохранение платежа отказывает.
+	repo.fail = true
+	err = s.Import(dir)
+	if !errors.Is(err, errSaveFailed) {
+		t.Errorf("Import(): must return save error, returned %v", err)
+		return
+	}
+	repo.fail = false
+
+	_, err = s.FindAccountByID(2)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Import(): created account not removed, error = %v", err)
+		return
+	}
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Errorf("VerifyLedger(): error after rollback = %v", err)
+		return
+	}
+	account, err := s.RegisterAccount("+992935444994")
+	if err != nil || account.ID != 2 {
+		t.Errorf("RegisterAccount(): phone still taken, account = %v, error = %v", account, err)
+	}
+}
+
+func TestService_Import_rollback(t *testing.T) {
+	s := newTestService()
+	account, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	before, err := s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	entries := len(s.LedgerEntries())
+
+	dir := t.TempDir()
+	err = WriteToFile(filepath.Join(dir, accountsFile), "1;+992880806776;1;\n2;+992935444994;800000;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, paymentsFile),
+		"fc10959f-5f28-40e2-81bc-a70348e0549a;2;100000;auto;INPROGRESS;\n"+
+			"5a96dc44-f8fa-4605-ba33-b6cd20f1b196;2;100000;auto;LOST;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Import(dir)
+	var lineErr *LineError
+	if !errors.As(err, &lineErr) || lineErr.File != paymentsFile || lineErr.Line != 2 {
+		t.Errorf("Import(): must return LineError for payments.dump:2, returned %v", err)
+		return
+	}
+
+	after, err := s.FindAccountByID(account.ID)
+	if err != nil || *after != *before {
+		t.Errorf("Import(): failed import changed account, account = %v, error = %v", after, err)
+		return
+	}
+	_, err = s.FindAccountByID(2)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Import(): failed import created account, error = %v", err)
+		return
+	}
+	_, err = s.FindPaymentByID("fc10959f-5f28-40e2-81bc-a70348e0549a")
+	if !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("Import(): failed import created payment, error = %v", err)
+		return
+	}
+	if len(s.LedgerEntries()) != entries {
+		t.Errorf("Import(): failed import posted ledger entries")
+		return
+	}
+
+	account, err = s.RegisterAccount("+992935444994")
+	if err != nil || account.ID != 2 {
+		t.Errorf("RegisterAccount(): account = %v, error = %v", account, err)
+	}
+}
+
+func TestService_Import_badLedger(t *testing.T) {
+	tests := []struct {
+		line string
+		want error
+	}{
+		{"e1;1;deposit;customer:1=100,system:deposits=-99;;\n", ErrUnbalancedEntry},
+		{"e1;1;deposit;customer:2=100,system:deposits=-100;;\n", ErrAccountNotFound},
+	}
+	for _, tt := range tests {
+		s := newTestService()
+		_, err := s.addAccountWithBalance("+992880806776", 100)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		dir := t.TempDir()
+		err = WriteToFile(filepath.Join(dir, ledgerFile), ledgerSchema.header()+tt.line)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = s.Import(dir)
+		var lineErr *LineError
+		if !errors.Is(err, tt.want) || !errors.As(err, &lineErr) || lineErr.File != ledgerFile || lineErr.Line != 2 {
+			t.Errorf("Import(%q): must return %v on line 2, returned %v", tt.line, tt.want, err)
+			continue
+		}
+		_, err = s.repository().LedgerEntryByID("e1")
+		if !errors.Is(err, ErrLedgerEntryNotFound) {
+			t.Errorf("Import(%q): bad ledger entry stored, error = %v", tt.line, err)
+		}
+	}
+}
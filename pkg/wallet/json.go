@@ -0,0 +1,752 @@
+package wallet
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+// Файлы JSON-выгрузки. Счета и избранное - JSON-документы с версией формата,
+// платежи, переводы, ключи идемпотентности, аудит уровней и книга - JSON Lines
+// (по объекту на строку), чтобы большие выгрузки читались и писались потоком.
+const (
+	accountsJSONFile    = "accounts.json"
+	favoritesJSONFile   = "favorites.json"
+	paymentsJSONFile    = "payments.jsonl"
+	transfersJSONFile   = "transfers.jsonl"
+	keysJSONFile        = "idempotency.jsonl"
+	tierChangesJSONFile = "tiers.jsonl"
+	ledgerJSONFile      = "ledger.jsonl"
+
+	jsonFormatVersion = 1
+)
+
+// Даты в JSON - строки RFC 3339, нулевое время не пишется.
+
+type jsonAccount struct {
+	ID        int64          `json:"id"`
+	Phone     types.Phone    `json:"phone"`
+	Balance   types.Money    `json:"balance"`
+	Held      types.Money    `json:"held,omitempty"`
+	Currency  types.Currency `json:"currency,omitempty"`
+	Tier      types.KYCTier  `json:"tier,omitempty"`
+	CreatedAt string         `json:"created_at,omitempty"`
+	UpdatedAt string         `json:"updated_at,omitempty"`
+}
+
+type jsonPayment struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"account_id"`
+	Amount    types.Money           `json:"amount"`
+	Currency  types.Currency        `json:"currency,omitempty"`
+	Category  types.PaymentCategory `json:"category"`
+	Status    types.PaymentStatus   `json:"status"`
+	CreatedAt string                `json:"created_at,omitempty"`
+	UpdatedAt string                `json:"updated_at,omitempty"`
+
+	OriginalAmount   types.Money    `json:"original_amount,omitempty"`
+	OriginalCurrency types.Currency `json:"original_currency,omitempty"`
+	Rate             types.Rate     `json:"rate,omitempty"`
+	Fee              types.Money    `json:"fee,omitempty"`
+	FeeID            string         `json:"fee_id,omitempty"`
+	ParentID         string         `json:"parent_id,omitempty"`
+	Refunded         types.Money    `json:"refunded,omitempty"`
+	Authorized       types.Money    `json:"authorized,omitempty"`
+	ExpiresAt        string         `json:"expires_at,omitempty"`
+}
+
+type jsonFavorite struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"account_id"`
+	Name      string                `json:"name"`
+	Amount    types.Money           `json:"amount"`
+	Currency  types.Currency        `json:"currency,omitempty"`
+	Category  types.PaymentCategory `json:"category"`
+	CreatedAt string                `json:"created_at,omitempty"`
+}
+
+type jsonTransfer struct {
+	ID            string              `json:"id"`
+	FromAccountID int64               `json:"from_account_id"`
+	ToAccountID   int64               `json:"to_account_id"`
+	Amount        types.Money         `json:"amount"`
+	Status        types.PaymentStatus `json:"status"`
+	CreatedAt     string              `json:"created_at,omitempty"`
+	UpdatedAt     string              `json:"updated_at,omitempty"`
+}
+
+type jsonKey struct {
+	Key       string                `json:"key"`
+	Op        string                `json:"op"`
+	AccountID int64                 `json:"account_id,omitempty"`
+	Ref       string                `json:"ref,omitempty"`
+	Amount    types.Money           `json:"amount,omitempty"`
+	Category  types.PaymentCategory `json:"category,omitempty"`
+	Result    string                `json:"result,omitempty"`
+	CreatedAt string                `json:"created_at,omitempty"`
+}
+
+type jsonTierChange struct {
+	ID        string        `json:"id"`
+	AccountID int64         `json:"account_id"`
+	From      types.KYCTier `json:"from"`
+	To        types.KYCTier `json:"to"`
+	Reason    string        `json:"reason,omitempty"`
+	CreatedAt string        `json:"created_at,omitempty"`
+}
+
+type jsonPosting struct {
+	Account string      `json:"account"`
+	Amount  types.Money `json:"amount"`
+}
+
+type jsonLedgerEntry struct {
+	ID        string        `json:"id"`
+	Reference string        `json:"reference,omitempty"`
+	Kind      string        `json:"kind"`
+	Postings  []jsonPosting `json:"postings"`
+	CreatedAt string        `json:"created_at,omitempty"`
+}
+
+type jsonAccounts struct {
+	Version  int           `json:"version"`
+	Accounts []jsonAccount `json:"accounts"`
+}
+
+type jsonFavorites struct {
+	Version   int            `json:"version"`
+	Favorites []jsonFavorite `json:"favorites"`
+}
+
+func parseTimeValue(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func (a jsonAccount) account() (*types.Account, error) {
+	currency, err := parseCurrencyField([]string{string(a.Currency)}, 0)
+	if err != nil {
+		return nil, err
+	}
+	tier, err := parseTierField([]string{string(a.Tier)}, 0)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := parseTimeValue(a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	updatedAt, err := parseTimeValue(a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Account{
+		ID:        a.ID,
+		Phone:     a.Phone,
+		Balance:   a.Balance,
+		Held:      a.Held,
+		Currency:  currency,
+		Tier:      tier,
+		CreatedAt: createdAt,
+		UpdatedAt: updatedAt,
+	}, nil
+}
+
+func (p jsonPayment) payment() (*types.Payment, error) {
+	if !isKnownStatus(p.Status) {
+		return nil, ErrUnknownPaymentStatus
+	}
+	currency, err := parseCurrencyField([]string{string(p.Currency)}, 0)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := parseTimeValue(p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	updatedAt, err := parseTimeValue(p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	originalCurrency, err := parseOptionalCurrency([]string{string(p.OriginalCurrency)}, 0)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := parseTimeValue(p.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Payment{
+		ID:               p.ID,
+		AccountID:        p.AccountID,
+		Amount:           p.Amount,
+		Currency:         currency,
+		Category:         p.Category,
+		Status:           p.Status,
+		CreatedAt:        createdAt,
+		UpdatedAt:        updatedAt,
+		OriginalAmount:   p.OriginalAmount,
+		OriginalCurrency: originalCurrency,
+		Rate:             p.Rate,
+		Fee:              p.Fee,
+		FeeID:            p.FeeID,
+		ParentID:         p.ParentID,
+		Refunded:         p.Refunded,
+		Authorized:       p.Authorized,
+		ExpiresAt:        expiresAt,
+	}, nil
+}
+
+func (f jsonFavorite) favorite() (*types.Favorite, error) {
+	currency, err := parseCurrencyField([]string{string(f.Currency)}, 0)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := parseTimeValue(f.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Favorite{
+		ID:        f.ID,
+		AccountID: f.AccountID,
+		Name:      f.Name,
+		Amount:    f.Amount,
+		Currency:  currency,
+		Category:  f.Category,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func (t jsonTransfer) transfer() (*types.Transfer, error) {
+	createdAt, err := parseTimeValue(t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	updatedAt, err := parseTimeValue(t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Transfer{
+		ID:            t.ID,
+		FromAccountID: t.FromAccountID,
+		ToAccountID:   t.ToAccountID,
+		Amount:        t.Amount,
+		Status:        t.Status,
+		CreatedAt:     createdAt,
+		UpdatedAt:     updatedAt,
+	}, nil
+}
+
+func (k jsonKey) key() (*types.IdempotencyKey, error) {
+	if k.Key == "" {
+		return nil, ErrBadRecord
+	}
+	createdAt, err := parseTimeValue(k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &types.IdempotencyKey{
+		Key:       k.Key,
+		Op:        k.Op,
+		AccountID: k.AccountID,
+		Ref:       k.Ref,
+		Amount:    k.Amount,
+		Category:  k.Category,
+		Result:    k.Result,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func (c jsonTierChange) change() (*types.TierChange, error) {
+	from, err := parseTierField([]string{string(c.From)}, 0)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTierField([]string{string(c.To)}, 0)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := parseTimeValue(c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &types.TierChange{
+		ID:        c.ID,
+		AccountID: c.AccountID,
+		From:      from,
+		To:        to,
+		Reason:    c.Reason,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func (e jsonLedgerEntry) entry() (*types.LedgerEntry, error) {
+	if e.ID == "" || len(e.Postings) == 0 {
+		return nil, ErrBadRecord
+	}
+	createdAt, err := parseTimeValue(e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	postings := make([]types.Posting, 0, len(e.Postings))
+	for _, posting := range e.Postings {
+		postings = append(postings, types.Posting{Account: posting.Account, Amount: posting.Amount})
+	}
+	return &types.LedgerEntry{
+		ID:        e.ID,
+		Reference: e.Reference,
+		Kind:      e.Kind,
+		Postings:  postings,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func toJSONPayment(payment *types.Payment) jsonPayment {
+	return jsonPayment{
+		ID:               payment.ID,
+		AccountID:        payment.AccountID,
+		Amount:           payment.Amount,
+		Currency:         payment.Currency,
+		Category:         payment.Category,
+		Status:           payment.Status,
+		CreatedAt:        formatTime(payment.CreatedAt),
+		UpdatedAt:        formatTime(payment.UpdatedAt),
+		OriginalAmount:   payment.OriginalAmount,
+		OriginalCurrency: payment.OriginalCurrency,
+		Rate:             payment.Rate,
+		Fee:              payment.Fee,
+		FeeID:            payment.FeeID,
+		ParentID:         payment.ParentID,
+		Refunded:         payment.Refunded,
+		Authorized:       payment.Authorized,
+		ExpiresAt:        formatTime(payment.ExpiresAt),
+	}
+}
+
+// writePaymentsJSONLines пишет платежи в w по одному JSON-объекту на строку.
+func writePaymentsJSONLines(w io.Writer, payments []types.Payment) error {
+	encoder := json.NewEncoder(w)
+	for _, payment := range payments {
+		err := encoder.Encode(toJSONPayment(&payment))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportJSON выгружает данные в dir в JSON: accounts.json, favorites.json,
+// payments.jsonl, transfers.jsonl, idempotency.jsonl, tiers.jsonl и
+// ledger.jsonl - то же, что Export. Файлы пишутся атомарно, как в Export.
+func (s *Service) ExportJSON(dir string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts, err := s.repository().Accounts()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	accountsDoc := jsonAccounts{Version: jsonFormatVersion, Accounts: []jsonAccount{}}
+	for _, account := range accounts {
+		accountsDoc.Accounts = append(accountsDoc.Accounts, jsonAccount{
+			ID:        account.ID,
+			Phone:     account.Phone,
+			Balance:   account.Balance,
+			Held:      account.Held,
+			Currency:  account.Currency,
+			Tier:      account.Tier,
+			CreatedAt: formatTime(account.CreatedAt),
+			UpdatedAt: formatTime(account.UpdatedAt),
+		})
+	}
+
+	favorites, err := s.repository().Favorites()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	favoritesDoc := jsonFavorites{Version: jsonFormatVersion, Favorites: []jsonFavorite{}}
+	for _, favorite := range favorites {
+		favoritesDoc.Favorites = append(favoritesDoc.Favorites, jsonFavorite{
+			ID:        favorite.ID,
+			AccountID: favorite.AccountID,
+			Name:      favorite.Name,
+			Amount:    favorite.Amount,
+			Currency:  favorite.Currency,
+			Category:  favorite.Category,
+			CreatedAt: formatTime(favorite.CreatedAt),
+		})
+	}
+
+	payments, err := s.repository().Payments()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	transfers, err := s.repository().Transfers()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	keys, err := s.repository().IdempotencyKeys()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	changes, err := s.repository().TierChanges()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	entries, err := s.repository().LedgerEntries()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+
+	err = writeJSONDocument(filepath.Join(dir, accountsJSONFile), accountsDoc)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	err = writeJSONDocument(filepath.Join(dir, favoritesJSONFile), favoritesDoc)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	err = writeFileAtomicFunc(filepath.Join(dir, paymentsJSONFile), func(w io.Writer) error {
+		return writePaymentsJSONLines(w, payments)
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	err = writeFileAtomicFunc(filepath.Join(dir, transfersJSONFile), func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		for _, transfer := range transfers {
+			err := encoder.Encode(jsonTransfer{
+				ID:            transfer.ID,
+				FromAccountID: transfer.FromAccountID,
+				ToAccountID:   transfer.ToAccountID,
+				Amount:        transfer.Amount,
+				Status:        transfer.Status,
+				CreatedAt:     formatTime(transfer.CreatedAt),
+				UpdatedAt:     formatTime(transfer.UpdatedAt),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	err = writeFileAtomicFunc(filepath.Join(dir, keysJSONFile), func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		for _, key := range keys {
+			err := encoder.Encode(jsonKey{
+				Key:       key.Key,
+				Op:        key.Op,
+				AccountID: key.AccountID,
+				Ref:       key.Ref,
+				Amount:    key.Amount,
+				Category:  key.Category,
+				Result:    key.Result,
+				CreatedAt: formatTime(key.CreatedAt),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	err = writeFileAtomicFunc(filepath.Join(dir, tierChangesJSONFile), func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		for _, change := range changes {
+			err := encoder.Encode(jsonTierChange{
+				ID:        change.ID,
+				AccountID: change.AccountID,
+				From:      change.From,
+				To:        change.To,
+				Reason:    change.Reason,
+				CreatedAt: formatTime(change.CreatedAt),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	err = writeFileAtomicFunc(filepath.Join(dir, ledgerJSONFile), func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		for _, entry := range entries {
+			postings := make([]jsonPosting, 0, len(entry.Postings))
+			for _, posting := range entry.Postings {
+				postings = append(postings, jsonPosting{Account: posting.Account, Amount: posting.Amount})
+			}
+			err := encoder.Encode(jsonLedgerEntry{
+				ID:        entry.ID,
+				Reference: entry.Reference,
+				Kind:      entry.Kind,
+				Postings:  postings,
+				CreatedAt: formatTime(entry.CreatedAt),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+func writeJSONDocument(path string, document interface{}) error {
+	return writeFileAtomicFunc(path, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(document)
+	})
+}
+
+// HistoryToJSONLines пишет историю платежей в один файл JSON Lines.
+func (s *Service) HistoryToJSONLines(payments []types.Payment, path string) error {
+	err := writeFileAtomicFunc(path, func(w io.Writer) error {
+		return writePaymentsJSONLines(w, payments)
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+// ImportJSON загружает выгрузку ExportJSON из dir так же, как Import -
+// выгрузку Export: записи проверяются по тем же правилам, а при ошибке
+// не меняется ничего. Отсутствующий файл пропускается.
+func (s *Service) ImportJSON(dir string) error {
+	_, err := s.ImportJSONWithOptions(dir, ImportOptions{})
+	return err
+}
+
+// ImportJSONWithOptions - ImportWithOptions для выгрузки ExportJSON.
+// Line в *LineError - номер записи в файле.
+func (s *Service) ImportJSONWithOptions(dir string, options ImportOptions) (*ImportReport, error) {
+	return s.importWithOptions(func(p *importPlan, options ImportOptions) error {
+		return p.runJSON(dir, options)
+	}, options)
+}
+
+// jsonRecord - запись JSON-выгрузки, которую разбирает и загружает план импорта.
+type jsonRecord interface {
+	importTo(p *importPlan) error
+}
+
+func (a *jsonAccount) importTo(p *importPlan) error {
+	record, err := a.account()
+	if err != nil {
+		return err
+	}
+	return p.importAccount(record)
+}
+
+func (item *jsonPayment) importTo(p *importPlan) error {
+	record, err := item.payment()
+	if err != nil {
+		return err
+	}
+	return p.importPayment(record)
+}
+
+func (f *jsonFavorite) importTo(p *importPlan) error {
+	record, err := f.favorite()
+	if err != nil {
+		return err
+	}
+	return p.importFavorite(record)
+}
+
+func (t *jsonTransfer) importTo(p *importPlan) error {
+	record, err := t.transfer()
+	if err != nil {
+		return err
+	}
+	return p.importTransfer(record)
+}
+
+func (k *jsonKey) importTo(p *importPlan) error {
+	record, err := k.key()
+	if err != nil {
+		return err
+	}
+	return p.importKey(record)
+}
+
+func (c *jsonTierChange) importTo(p *importPlan) error {
+	record, err := c.change()
+	if err != nil {
+		return err
+	}
+	return p.importTierChange(record)
+}
+
+func (e *jsonLedgerEntry) importTo(p *importPlan) error {
+	record, err := e.entry()
+	if err != nil {
+		return err
+	}
+	return p.importLedgerEntry(record)
+}
+
+// runJSON - run для JSON-выгрузки: файлы читаются в том же порядке, записи
+// проверяются и загружаются теми же методами плана.
+func (p *importPlan) runJSON(dir string, options ImportOptions) error {
+	err := p.load()
+	if err != nil {
+		return err
+	}
+
+	accountsDoc := jsonAccounts{}
+	ok, err := readJSONDocument(filepath.Join(dir, accountsJSONFile), &accountsDoc, &accountsDoc.Version)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	if ok {
+		for i := range accountsDoc.Accounts {
+			err = p.importJSONRecord(accountsJSONFile, i+1, &accountsDoc.Accounts[i], options)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = p.readJSONLines(filepath.Join(dir, paymentsJSONFile), func() jsonRecord { return &jsonPayment{} }, options)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+
+	favoritesDoc := jsonFavorites{}
+	ok, err = readJSONDocument(filepath.Join(dir, favoritesJSONFile), &favoritesDoc, &favoritesDoc.Version)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	if ok {
+		for i := range favoritesDoc.Favorites {
+			err = p.importJSONRecord(favoritesJSONFile, i+1, &favoritesDoc.Favorites[i], options)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, item := range []struct {
+		name      string
+		newRecord func() jsonRecord
+	}{
+		{transfersJSONFile, func() jsonRecord { return &jsonTransfer{} }},
+		{keysJSONFile, func() jsonRecord { return &jsonKey{} }},
+		{tierChangesJSONFile, func() jsonRecord { return &jsonTierChange{} }},
+		{ledgerJSONFile, func() jsonRecord { return &jsonLedgerEntry{} }},
+	} {
+		err = p.readJSONLines(filepath.Join(dir, item.name), item.newRecord, options)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+	return p.finish()
+}
+
+// importJSONRecord загружает запись number файла name и учитывает её
+// в отчёте так же, как importDumpFile - строку dump-файла.
+func (p *importPlan) importJSONRecord(name string, number int, record jsonRecord, options ImportOptions) error {
+	err := record.importTo(p)
+	if err != nil {
+		lineErr := &LineError{File: name, Line: number, Err: err}
+		if options.Mode == ImportFailFast {
+			return lineErr
+		}
+		p.report.Errors = append(p.report.Errors, lineErr)
+		return nil
+	}
+	p.report.Records++
+	return nil
+}
+
+// readJSONDocument читает JSON-документ в document и проверяет версию формата.
+// Возвращает false, если файла нет.
+func readJSONDocument(path string, document interface{}, version *int) (bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		log.Println(ErrFileNotFound.Error())
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	err = json.NewDecoder(file).Decode(document)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if *version > jsonFormatVersion {
+		return false, fmt.Errorf("%s: %w: %d", filepath.Base(path), ErrUnsupportedDumpVersion, *version)
+	}
+	return true, nil
+}
+
+// readJSONLines читает файл JSON Lines потоком и загружает каждую запись
+// в record из newRecord. Отсутствующий файл пропускается. Запись, которая не
+// разбирается как JSON, - ошибка в любом режиме: дальше поток не прочитать.
+func (p *importPlan) readJSONLines(path string, newRecord func() jsonRecord, options ImportOptions) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		log.Println(ErrFileNotFound.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	name := filepath.Base(path)
+	decoder := json.NewDecoder(file)
+	for number := 1; decoder.More(); number++ {
+		record := newRecord()
+		err = decoder.Decode(record)
+		if err != nil {
+			return &LineError{File: name, Line: number, Err: err}
+		}
+		err = p.importJSONRecord(name, number, record, options)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestService_ExportJSON_roundTrip(t *testing.T) {
+	clock := &testClock{now: time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC)}
+	s := newTestService()
+	s.SetClock(clock.Now)
+	from, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	to, err := s.RegisterAccount("+992880806777")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.FavoritePayment(payments[0].ID, "mom's \"cafe\";\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Transfer(from.ID, to.ID, 100_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.PromoteAccount(from.ID, types.KYCFull, "passport")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	paid, err := s.PayWithKey("k1", from.ID, 10_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payments = append(payments, paid)
+
+	dir := t.TempDir()
+	err = s.ExportJSON(dir)
+	if err != nil {
+		t.Errorf("ExportJSON(): error = %v", err)
+		return
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, paymentsJSONFile))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if strings.Count(string(data), "\n") != len(payments) {
+		t.Errorf("ExportJSON(): payments must be one object per line, got %q", data)
+		return
+	}
+
+	imported := newTestService()
+	imported.SetClock(clock.Now)
+	err = imported.ImportJSON(dir)
+	if err != nil {
+		t.Errorf("ImportJSON(): error = %v", err)
+		return
+	}
+
+	wantAccounts, _ := s.repository().Accounts()
+	gotAccounts, _ := imported.repository().Accounts()
+	if !reflect.DeepEqual(gotAccounts, wantAccounts) {
+		t.Errorf("ImportJSON(): accounts = %v, want %v", gotAccounts, wantAccounts)
+		return
+	}
+	wantPayments, _ := s.repository().Payments()
+	gotPayments, _ := imported.repository().Payments()
+	if !reflect.DeepEqual(gotPayments, wantPayments) {
+		t.Errorf("ImportJSON(): payments = %v, want %v", gotPayments, wantPayments)
+		return
+	}
+	wantFavorites, _ := s.repository().Favorites()
+	gotFavorites, _ := imported.repository().Favorites()
+	if !reflect.DeepEqual(gotFavorites, wantFavorites) {
+		t.Errorf("ImportJSON(): favorites = %v, want %v", gotFavorites, wantFavorites)
+		return
+	}
+	wantTransfers, _ := s.repository().Transfers()
+	gotTransfers, _ := imported.repository().Transfers()
+	if !reflect.DeepEqual(gotTransfers, wantTransfers) {
+		t.Errorf("ImportJSON(): transfers = %v, want %v", gotTransfers, wantTransfers)
+		return
+	}
+	wantKeys, _ := s.repository().IdempotencyKeys()
+	gotKeys, _ := imported.repository().IdempotencyKeys()
+	if len(wantKeys) != 1 || !reflect.DeepEqual(gotKeys, wantKeys) {
+		t.Errorf("ImportJSON(): idempotency keys = %v, want %v", gotKeys, wantKeys)
+		return
+	}
+	wantChanges, _ := s.repository().TierChanges()
+	gotChanges, _ := imported.repository().TierChanges()
+	if len(wantChanges) != 1 || !reflect.DeepEqual(gotChanges, wantChanges) {
+		t.Errorf("ImportJSON(): tier changes = %v, want %v", gotChanges, wantChanges)
+		return
+	}
+	if got, want := imported.LedgerEntries(), s.LedgerEntries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ImportJSON(): ledger = %v, want %v", got, want)
+		return
+	}
+	err = imported.VerifyLedger()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_ImportJSON_upsert(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.ExportJSON(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = WriteToFile(filepath.Join(dir, accountsJSONFile),
+		`{"version":1,"accounts":[{"id":1,"phone":"+992880806776","balance":123}]}`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ImportJSON(dir)
+	if err != nil {
+		t.Errorf("ImportJSON(): error = %v", err)
+		return
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 123 {
+		t.Errorf("ImportJSON(): account not updated, account = %v, error = %v", got, err)
+		return
+	}
+
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	before, err := s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, accountsJSONFile),
+		`{"version":1,"accounts":[{"id":1,"phone":"+992880806776","balance":456}]}`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Платёж уже отклонён, в выгрузке он INPROGRESS - как и Import, ImportJSON
+	// не возвращает платёж из конечного статуса и откатывает весь импорт.
+	err = s.ImportJSON(dir)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("ImportJSON(): must return ErrIllegalTransition, returned %v", err)
+		return
+	}
+	got, err = s.FindAccountByID(account.ID)
+	if err != nil || *got != *before {
+		t.Errorf("ImportJSON(): failed import changed account, account = %v, error = %v", got, err)
+	}
+}
+
+func TestService_ImportJSON_badRecord(t *testing.T) {
+	dir := t.TempDir()
+	err := WriteToFile(filepath.Join(dir, accountsJSONFile),
+		`{"version":1,"accounts":[{"id":1,"phone":"+992880806776","balance":1000}]}`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(filepath.Join(dir, paymentsJSONFile),
+		`{"id":"a","account_id":1,"amount":100,"category":"auto","status":"INPROGRESS"}`+"\n"+
+			`{"id":"b","account_id":1,"amount":100,"category":"auto","status":"LOST"}`+"\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = newTestService().ImportJSON(dir)
+	var lineErr *LineError
+	if !errors.Is(err, ErrUnknownPaymentStatus) || !errors.As(err, &lineErr) ||
+		lineErr.File != paymentsJSONFile || lineErr.Line != 2 {
+		t.Errorf("ImportJSON(): must report record 2 with ErrUnknownPaymentStatus, returned %v", err)
+	}
+}
+
+func TestService_ImportJSON_validates(t *testing.T) {
+	tests := []struct {
+		name, file, data string
+		want             error
+	}{
+		{"unknown account", paymentsJSONFile,
+			`{"id":"p1","account_id":999,"amount":100,"category":"auto","status":"INPROGRESS"}` + "\n", ErrAccountNotFound},
+		{"unknown transfer accounts", transfersJSONFile,
+			`{"id":"t1","from_account_id":5,"to_account_id":6,"amount":100,"status":"OK"}` + "\n", ErrAccountNotFound},
+		{"unknown transfer status", transfersJSONFile,
+			`{"id":"t1","from_account_id":1,"to_account_id":1,"amount":100,"status":"WHATEVER"}` + "\n", ErrUnknownPaymentStatus},
+		{"negative balance", accountsJSONFile,
+			`{"version":1,"accounts":[{"id":2,"phone":"+992880806777","balance":-500}]}`, ErrNegativeBalance},
+	}
+	for _, tt := range tests {
+		s := newTestService()
+		account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		dir := t.TempDir()
+		err = WriteToFile(filepath.Join(dir, tt.file), tt.data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		report, err := s.ImportJSONWithOptions(dir, ImportOptions{DryRun: true})
+		if err != nil || len(report.Errors) != 1 || !errors.Is(report.Errors[0], tt.want) {
+			t.Errorf("%s: ImportJSONWithOptions(DryRun): report = %v, error = %v, want %v", tt.name, report, err, tt.want)
+			continue
+		}
+		err = s.ImportJSON(dir)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: ImportJSON(): must return %v, returned %v", tt.name, tt.want, err)
+			continue
+		}
+		accounts, _ := s.repository().Accounts()
+		payments, _ := s.repository().Payments()
+		transfers, _ := s.repository().Transfers()
+		if len(accounts) != 1 || accounts[0].ID != account.ID || len(payments) != 0 || len(transfers) != 0 {
+			t.Errorf("%s: ImportJSON(): failed import changed service, accounts = %v, payments = %v, transfers = %v",
+				tt.name, accounts, payments, transfers)
+		}
+	}
+}
+
+func TestService_ImportJSONWithOptions_collectErrors(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dir := t.TempDir()
+	err = WriteToFile(filepath.Join(dir, paymentsJSONFile),
+		`{"id":"p1","account_id":999,"amount":100,"category":"auto","status":"INPROGRESS"}`+"\n"+
+			`{"id":"p2","account_id":1,"amount":100,"category":"auto","status":"INPROGRESS"}`+"\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	report, err := s.ImportJSONWithOptions(dir, ImportOptions{Mode: ImportCollectErrors})
+	if err != report || report.Records != 1 || len(report.Errors) != 1 || report.Errors[0].Line != 1 {
+		t.Errorf("ImportJSONWithOptions(): report = %v, error = %v", report, err)
+		return
+	}
+	_, err = s.FindPaymentByID("p2")
+	if err != nil {
+		t.Errorf("ImportJSONWithOptions(): good record not imported, error = %v", err)
+	}
+}
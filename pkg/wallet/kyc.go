@@ -0,0 +1,256 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrUnknownTier = errors.New("unknown KYC tier")
+var ErrBadTierChange = errors.New("bad KYC tier change")
+var ErrTierRestricted = errors.New("operation restricted by KYC tier")
+var ErrTierChangeNotFound = errors.New("tier change not found")
+
+// Operation - операция со счётом, которую может запрещать уровень идентификации.
+type Operation string
+
+const (
+	OperationDeposit  Operation = "deposit"  // Deposit, DepositIn
+	OperationPay      Operation = "pay"      // Pay, PayIn, PayFromFavorite, Repeat, Authorize
+	OperationTransfer Operation = "transfer" // Transfer; получателю перевод не запрещается
+)
+
+// TierRule - ограничения уровня идентификации в валюте счёта. MaxBalance -
+// потолок баланса после зачисления, MaxMonthlyTurnover - потолок оборота
+// (платежей и переводов в обе стороны) за календарный месяц (UTC);
+// пополнения ограничивает только MaxBalance. Нулевая сумма - без
+// ограничения. Operations - разрешённые операции; nil - разрешены все.
+type TierRule struct {
+	MaxBalance         types.Money
+	MaxMonthlyTurnover types.Money
+	Operations         []Operation
+}
+
+func (rule TierRule) allows(operation Operation) bool {
+	if rule.Operations == nil {
+		return true
+	}
+	for _, allowed := range rule.Operations {
+		if allowed == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// TierRules - ограничения по уровням. Уровень без правила ничем не ограничен.
+type TierRules map[types.KYCTier]TierRule
+
+// TierViolation - какое из ограничений TierRule не пропустило операцию.
+type TierViolation string
+
+const (
+	TierOperation       TierViolation = "operation"
+	TierMaxBalance      TierViolation = "max-balance"
+	TierMonthlyTurnover TierViolation = "monthly-turnover"
+)
+
+// TierError возвращается, когда уровень идентификации счёта не допускает операцию.
+type TierError struct {
+	AccountID int64
+	Tier      types.KYCTier
+	Operation Operation
+	Violation TierViolation
+}
+
+func (e *TierError) Error() string {
+	return fmt.Sprintf("account %d (%s): %s not allowed: %s", e.AccountID, e.Tier, e.Operation, e.Violation)
+}
+
+func (e *TierError) Unwrap() error {
+	return ErrTierRestricted
+}
+
+// SetTierRules задаёт ограничения уровней идентификации. Уже проведённые
+// операции новые правила не трогают.
+func (s *Service) SetTierRules(rules TierRules) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tierRules = rules
+}
+
+// PromoteAccount повышает уровень идентификации счёта до tier и пишет запись
+// аудита с причиной reason.
+func (s *Service) PromoteAccount(accountID int64, tier types.KYCTier, reason string) (*types.TierChange, error) {
+	return s.changeTierLogged(accountID, tier, reason, true)
+}
+
+// DemoteAccount понижает уровень идентификации счёта до tier. Деньги сверх
+// потолка нового уровня на счёте остаются, но пополнить его уже нельзя.
+func (s *Service) DemoteAccount(accountID int64, tier types.KYCTier, reason string) (*types.TierChange, error) {
+	return s.changeTierLogged(accountID, tier, reason, false)
+}
+
+// changeTierLogged - общая часть PromoteAccount (promote) и DemoteAccount.
+func (s *Service) changeTierLogged(accountID int64, tier types.KYCTier, reason string, promote bool) (*types.TierChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op := opDemoteAccount
+	if promote {
+		op = opPromoteAccount
+	}
+	changeID := uuid.New().String()
+	err := s.begin(&walRecord{Op: op, ID: changeID, AccountID: accountID, Tier: tier, Name: reason})
+	defer s.end()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.changeTier(changeID, accountID, tier, reason, promote)
+}
+
+func (s *Service) changeTier(changeID string, accountID int64, tier types.KYCTier, reason string, promote bool) (*types.TierChange, error) {
+	to, ok := tier.Rank()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTier, tier)
+	}
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	from, _ := account.Tier.Rank()
+	if promote && to <= from || !promote && to >= from {
+		return nil, fmt.Errorf("%w: account %d, %s -> %s", ErrBadTierChange, accountID, account.Tier, tier)
+	}
+
+	now := s.now()
+	change := &types.TierChange{
+		ID:        changeID,
+		AccountID: accountID,
+		From:      account.Tier,
+		To:        tier,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+	err = s.repository().SaveTierChange(change)
+	if err != nil {
+		return nil, err
+	}
+
+	account.Tier = tier
+	err = s.repository().SaveAccount(account)
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// AccountTierChanges возвращает историю смены уровня счёта в порядке записи.
+func (s *Service) AccountTierChanges(accountID int64) ([]types.TierChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	return s.repository().TierChangesByAccount(accountID)
+}
+
+// checkTier проверяет операцию на сумму amount (в валюте счёта) по правилам
+// уровня счёта. Как и лимиты, проверяется до записи в журнал. Зачисление
+// (credit) упирается ещё и в потолок баланса, а всё, кроме пополнения, -
+// в потолок оборота.
+func (s *Service) checkTier(accountID int64, operation Operation, amount types.Money, credit bool) error {
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		// Несуществующий счёт отвергнет сама операция.
+		return nil
+	}
+	rule, ok := s.tierRules[account.Tier]
+	if !ok {
+		return nil
+	}
+
+	// Входящий перевод совершает не получатель: разрешения его уровня не
+	// проверяются, только потолки.
+	incoming := credit && operation == OperationTransfer
+	violation := TierViolation("")
+	switch {
+	case !incoming && !rule.allows(operation):
+		violation = TierOperation
+	case credit && rule.MaxBalance > 0 && amount > rule.MaxBalance-account.Balance:
+		violation = TierMaxBalance
+	case rule.MaxMonthlyTurnover > 0 && operation != OperationDeposit:
+		turnover, err := s.monthlyTurnover(accountID)
+		if err != nil {
+			return err
+		}
+		if amount <= rule.MaxMonthlyTurnover-turnover {
+			return nil
+		}
+		violation = TierMonthlyTurnover
+	default:
+		return nil
+	}
+	return &TierError{AccountID: accountID, Tier: account.Tier, Operation: operation, Violation: violation}
+}
+
+// checkPaymentTier - checkTier для платежа: в оборот платёж идёт вместе
+// с комиссией, поэтому и проверяется amount + fee.
+func (s *Service) checkPaymentTier(accountID int64, amount types.Money, fee types.Money) error {
+	total, err := amount.Add(fee)
+	if err != nil {
+		return err
+	}
+	return s.checkTier(accountID, OperationPay, total, false)
+}
+
+// monthlyTurnover - оборот счёта за текущий календарный месяц по сохранённым
+// платежам (с комиссиями) и переводам в обе стороны, поэтому он переживает
+// перезапуск и импорт. Как и в spending, отклонённые платежи и переводы
+// не считаются, возвраты оборот не уменьшают.
+func (s *Service) monthlyTurnover(accountID int64) (types.Money, error) {
+	now := s.now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	payments, err := s.repository().PaymentsByAccount(accountID)
+	if err != nil {
+		return 0, err
+	}
+	var turnover types.Money
+	for _, payment := range payments {
+		if payment.CreatedAt.Before(month) || !countsInTurnover(payment.Status) {
+			continue
+		}
+		if payment.ParentID != "" && payment.Category != types.PaymentCategoryFee {
+			continue
+		}
+		turnover += payment.Amount
+	}
+
+	transfers, err := s.repository().TransfersByAccount(accountID)
+	if err != nil {
+		return 0, err
+	}
+	for _, transfer := range transfers {
+		if transfer.CreatedAt.Before(month) || !countsInTurnover(transfer.Status) {
+			continue
+		}
+		turnover += transfer.Amount
+	}
+	return turnover, nil
+}
+
+func countsInTurnover(status types.PaymentStatus) bool {
+	switch status {
+	case types.PaymentStatusInProgress, types.PaymentStatusOk, types.PaymentStatusAuthorized:
+		return true
+	}
+	return false
+}
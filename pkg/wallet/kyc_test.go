@@ -0,0 +1,384 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestService_PromoteAccount_audit(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Tier != types.KYCAnonymous {
+		t.Errorf("RegisterAccount(): tier = %q, want %q", account.Tier, types.KYCAnonymous)
+		return
+	}
+
+	promoted, err := s.PromoteAccount(account.ID, types.KYCFull, "passport checked")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	demoted, err := s.DemoteAccount(account.ID, types.KYCSimplified, "passport expired")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Tier != types.KYCSimplified {
+		t.Errorf("account after DemoteAccount() = %v, error = %v", got, err)
+		return
+	}
+	changes, err := s.AccountTierChanges(account.ID)
+	if err != nil || len(changes) != 2 || changes[0] != *promoted || changes[1] != *demoted {
+		t.Errorf("AccountTierChanges() = %v, error = %v", changes, err)
+		return
+	}
+	if promoted.From != types.KYCAnonymous || promoted.To != types.KYCFull || promoted.Reason != "passport checked" {
+		t.Errorf("PromoteAccount(): audit entry = %v", promoted)
+		return
+	}
+
+	_, err = s.PromoteAccount(account.ID, types.KYCAnonymous, "")
+	if !errors.Is(err, ErrBadTierChange) {
+		t.Errorf("PromoteAccount(): to lower tier must return ErrBadTierChange, returned %v", err)
+		return
+	}
+	_, err = s.DemoteAccount(account.ID, types.KYCSimplified, "")
+	if !errors.Is(err, ErrBadTierChange) {
+		t.Errorf("DemoteAccount(): to same tier must return ErrBadTierChange, returned %v", err)
+		return
+	}
+	_, err = s.PromoteAccount(account.ID, "gold", "")
+	if !errors.Is(err, ErrUnknownTier) {
+		t.Errorf("PromoteAccount(): must return ErrUnknownTier, returned %v", err)
+		return
+	}
+	_, err = s.PromoteAccount(account.ID+1, types.KYCFull, "")
+	if err != ErrAccountNotFound {
+		t.Errorf("PromoteAccount(): must return ErrAccountNotFound, returned %v", err)
+	}
+}
+
+func TestService_Deposit_tierBalanceCap(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sender, err := s.addAccountWithBalance("+992880806777", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.PromoteAccount(sender.ID, types.KYCFull, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetTierRules(TierRules{types.KYCAnonymous: {MaxBalance: 500_00}})
+
+	err = s.Deposit(account.ID, 400_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 200_00)
+	var tierErr *TierError
+	if !errors.As(err, &tierErr) || tierErr.Violation != TierMaxBalance || tierErr.AccountID != account.ID || !errors.Is(err, ErrTierRestricted) {
+		t.Errorf("Deposit(): returned %v, want max-balance violation", err)
+		return
+	}
+	_, err = s.Transfer(sender.ID, account.ID, 200_00)
+	if !errors.As(err, &tierErr) || tierErr.Violation != TierMaxBalance {
+		t.Errorf("Transfer(): returned %v, want max-balance violation of recipient", err)
+		return
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 400_00 {
+		t.Errorf("restricted deposits must not be credited, account = %v, error = %v", got, err)
+		return
+	}
+
+	_, err = s.PromoteAccount(account.ID, types.KYCSimplified, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 200_00)
+	if err != nil {
+		t.Errorf("Deposit(): tier without rule is not limited, error = %v", err)
+	}
+}
+
+func TestService_Pay_tierOperations(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	other, err := s.RegisterAccount("+992880806777")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetTierRules(TierRules{
+		types.KYCAnonymous:  {Operations: []Operation{OperationDeposit}},
+		types.KYCSimplified: {Operations: []Operation{OperationDeposit, OperationPay}},
+	})
+
+	_, err = s.Pay(account.ID, 100_00, "auto")
+	var tierErr *TierError
+	if !errors.As(err, &tierErr) || tierErr.Violation != TierOperation || tierErr.Operation != OperationPay {
+		t.Errorf("Pay(): returned %v, want operation violation", err)
+		return
+	}
+	_, err = s.Authorize(account.ID, 100_00, "auto")
+	if !errors.Is(err, ErrTierRestricted) {
+		t.Errorf("Authorize(): must return ErrTierRestricted, returned %v", err)
+		return
+	}
+
+	_, err = s.PromoteAccount(account.ID, types.KYCSimplified, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	_, err = s.Transfer(account.ID, other.ID, 100_00)
+	if !errors.As(err, &tierErr) || tierErr.Operation != OperationTransfer {
+		t.Errorf("Transfer(): returned %v, want transfer violation", err)
+	}
+}
+
+func TestService_Pay_tierTurnover(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2020, 10, 20, 10, 0, 0, 0, time.UTC)}
+	s.SetClock(clock.Now)
+	s.SetTierRules(TierRules{types.KYCAnonymous: {MaxMonthlyTurnover: 1000_00}})
+	account, err := s.addAccountWithBalance("+992880806776", 5000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sender, err := s.addAccountWithBalance("+992880806777", 5000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.PromoteAccount(sender.ID, types.KYCFull, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.Pay(account.ID, 600_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Transfer(sender.ID, account.ID, 300_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 200_00, "auto")
+	var tierErr *TierError
+	if !errors.As(err, &tierErr) || tierErr.Violation != TierMonthlyTurnover {
+		t.Errorf("Pay(): returned %v, want monthly-turnover violation", err)
+		return
+	}
+	_, err = s.Transfer(sender.ID, account.ID, 200_00)
+	if !errors.As(err, &tierErr) || tierErr.AccountID != account.ID || tierErr.Violation != TierMonthlyTurnover {
+		t.Errorf("Transfer(): returned %v, want monthly-turnover violation of recipient", err)
+		return
+	}
+	err = s.Deposit(account.ID, 200_00)
+	if err != nil {
+		t.Errorf("Deposit(): deposits are limited by balance only, error = %v", err)
+		return
+	}
+
+	// В новом месяце оборот считается заново.
+	clock.add(15 * 24 * time.Hour)
+	_, err = s.Pay(account.ID, 200_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+	}
+}
+
+func TestService_Pay_tierTurnoverWithFee(t *testing.T) {
+	s := newTestService()
+	s.SetTierRules(TierRules{types.KYCAnonymous: {MaxMonthlyTurnover: 1000_00}})
+	s.SetFeeSchedule(FeeSchedule{Categories: map[types.PaymentCategory]FeeRule{"auto": {Fixed: 100_00}}})
+	account, err := s.addAccountWithBalance("+992880806776", 5000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Платёж с комиссией не должен вывести оборот за потолок.
+	_, err = s.Pay(account.ID, 1000_00, "auto")
+	if !errors.Is(err, ErrTierRestricted) {
+		t.Errorf("Pay(): amount + fee over turnover must return ErrTierRestricted, returned %v", err)
+		return
+	}
+	_, err = s.Pay(account.ID, 900_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): amount + fee at turnover cap, error = %v", err)
+		return
+	}
+	turnover, err := s.monthlyTurnover(account.ID)
+	if err != nil || turnover != 1000_00 {
+		t.Errorf("monthlyTurnover() = %v, error = %v, want %v", turnover, err, types.Money(1000_00))
+	}
+}
+
+func TestFileRepository_restartTierTurnover(t *testing.T) {
+	dir := t.TempDir()
+	clock := &testClock{now: time.Date(2020, 10, 20, 10, 0, 0, 0, time.UTC)}
+	rules := TierRules{types.KYCAnonymous: {MaxMonthlyTurnover: 1000_00}}
+
+	repo, err := OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := NewService(repo)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetClock(clock.Now)
+	s.SetTierRules(rules)
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 5000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 900_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Оборот считается по сохранённым платежам, а не по главной книге в памяти.
+	repo, err = OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err = NewService(repo)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetClock(clock.Now)
+	s.SetTierRules(rules)
+	_, err = s.Pay(account.ID, 200_00, "auto")
+	if !errors.Is(err, ErrTierRestricted) {
+		t.Errorf("Pay() after restart: must return ErrTierRestricted, returned %v", err)
+	}
+}
+
+func TestService_Export_tiers(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	change, err := s.PromoteAccount(account.ID, types.KYCFull, "passport checked")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+
+	got, err := imported.FindAccountByID(account.ID)
+	if err != nil || got.Tier != types.KYCFull {
+		t.Errorf("Import(): account = %v, error = %v, want full tier", got, err)
+		return
+	}
+	changes, err := imported.AccountTierChanges(account.ID)
+	if err != nil || len(changes) != 1 || changes[0] != *change {
+		t.Errorf("Import(): tier changes = %v, error = %v, want %v", changes, err, change)
+	}
+}
+
+func TestOpen_replayTierChanges(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.PromoteAccount(account.ID, types.KYCFull, "passport checked")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	change, err := s.DemoteAccount(account.ID, types.KYCSimplified, "passport expired")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s, err = Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Tier != types.KYCSimplified {
+		t.Errorf("account after replay = %v, error = %v", got, err)
+		return
+	}
+	changes, err := s.AccountTierChanges(account.ID)
+	if err != nil || len(changes) != 2 || changes[1] != *change {
+		t.Errorf("tier changes after replay = %v, error = %v, want last %v", changes, err, change)
+	}
+}
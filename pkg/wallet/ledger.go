@@ -0,0 +1,308 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gholib/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+// Системные счета главной книги. Клиентские счета называются "customer:<ID>".
+const (
+	LedgerDeposits         = "system:deposits"
+	LedgerMerchantClearing = "system:merchant-clearing"
+	LedgerRefunds          = "system:refunds"
+	LedgerFees             = "system:fees"
+	LedgerImport           = "system:import"
+	LedgerOpening          = "system:opening"
+)
+
+// Виды записей главной книги.
+const (
+	EntryDeposit        = "deposit"
+	EntryPayment        = "payment"
+	EntryFee            = "fee"
+	EntryRefund         = "refund"
+	EntryTransfer       = "transfer"
+	EntryTransferRevert = "transfer-revert"
+	EntryImport         = "import"
+	EntryOpening        = "opening"
+)
+
+var ErrUnbalancedEntry = errors.New("ledger entry is not balanced")
+var ErrLedgerMismatch = errors.New("ledger mismatch")
+var ErrLedgerEntryNotFound = errors.New("ledger entry not found")
+
+const customerLedgerPrefix = "customer:"
+
+func customerLedgerAccount(accountID int64) string {
+	return customerLedgerPrefix + strconv.FormatInt(accountID, 10)
+}
+
+func parseCustomerLedgerAccount(account string) (int64, bool) {
+	if !strings.HasPrefix(account, customerLedgerPrefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(account, customerLedgerPrefix), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// post записывает сбалансированную запись в главную книгу и пересчитывает
+// балансы затронутых клиентских счетов. Только через него меняется Account.Balance.
+// Если баланс не помещается в types.Money, запись не проводится.
+func (s *Service) post(kind string, reference string, postings ...types.Posting) (*types.LedgerEntry, error) {
+	var sum types.Money
+	for _, posting := range postings {
+		var err error
+		sum, err = sum.Add(posting.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if sum != 0 {
+		return nil, ErrUnbalancedEntry
+	}
+
+	accounts := map[int64]*types.Account{}
+	for _, posting := range postings {
+		id, ok := parseCustomerLedgerAccount(posting.Account)
+		if !ok {
+			continue
+		}
+		account, ok := accounts[id]
+		if !ok {
+			var err error
+			account, err = s.findAccountByID(id)
+			if err != nil {
+				return nil, err
+			}
+			accounts[id] = account
+		}
+		balance, err := account.Balance.Add(posting.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("account %d: %w", id, err)
+		}
+		account.Balance = balance
+	}
+
+	now := s.now()
+	for _, account := range accounts {
+		account.UpdatedAt = now
+		err := s.repository().SaveAccount(account)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.appendEntry(kind, reference, postings...)
+}
+
+// appendEntry только сохраняет запись в книге, не трогая балансы
+// (открывающие записи и записи, сверяющие книгу с балансами после импорта).
+func (s *Service) appendEntry(kind string, reference string, postings ...types.Posting) (*types.LedgerEntry, error) {
+	entry := &types.LedgerEntry{
+		ID:        uuid.New().String(),
+		Reference: reference,
+		Kind:      kind,
+		Postings:  postings,
+		CreatedAt: s.now(),
+	}
+	err := s.repository().SaveLedgerEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// reconcileLedger сверяет с книгой балансы счетов accountIDs, которые импорт
+// поставил как есть: на разницу с книгой заносится запись kind против
+// LedgerImport. Если в выгрузке была книга, разницы обычно нет.
+func (s *Service) reconcileLedger(accountIDs []int64, kind string) error {
+	if len(accountIDs) == 0 {
+		return nil
+	}
+	entries, err := s.repository().LedgerEntries()
+	if err != nil {
+		return err
+	}
+	balances := ledgerBalances(entries)
+
+	for _, id := range accountIDs {
+		account, err := s.findAccountByID(id)
+		if err != nil {
+			return err
+		}
+		diff := account.Balance - balances[id]
+		if diff == 0 {
+			continue
+		}
+		_, err = s.appendEntry(kind, strconv.FormatInt(id, 10),
+			types.Posting{Account: customerLedgerAccount(id), Amount: diff},
+			types.Posting{Account: LedgerImport, Amount: -diff},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ledgerBalances складывает проводки entries по клиентским счетам.
+func ledgerBalances(entries []types.LedgerEntry) map[int64]types.Money {
+	balances := map[int64]types.Money{}
+	for _, entry := range entries {
+		for _, posting := range entry.Postings {
+			id, ok := parseCustomerLedgerAccount(posting.Account)
+			if ok {
+				balances[id] += posting.Amount
+			}
+		}
+	}
+	return balances
+}
+
+// LedgerEntries возвращает все записи главной книги в порядке проведения.
+func (s *Service) LedgerEntries() []types.LedgerEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries, err := s.repository().LedgerEntries()
+	if err != nil {
+		log.Print(err)
+		return []types.LedgerEntry{}
+	}
+	return entries
+}
+
+// AccountLedger возвращает записи главной книги, затрагивающие счёт клиента:
+// из них складывается его баланс.
+func (s *Service) AccountLedger(accountID int64) ([]types.LedgerEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.accountLedger(accountID)
+}
+
+func (s *Service) accountLedger(accountID int64) ([]types.LedgerEntry, error) {
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := s.repository().LedgerEntries()
+	if err != nil {
+		return nil, err
+	}
+	name := customerLedgerAccount(accountID)
+	entries := []types.LedgerEntry{}
+	for _, entry := range all {
+		for _, posting := range entry.Postings {
+			if posting.Account == name {
+				entries = append(entries, entry)
+				break
+			}
+		}
+	}
+	return entries, nil
+}
+
+// LedgerBalance возвращает остаток любого счёта книги, в том числе системного.
+func (s *Service) LedgerBalance(account string) types.Money {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries, err := s.repository().LedgerEntries()
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	var balance types.Money
+	for _, entry := range entries {
+		for _, posting := range entry.Postings {
+			if posting.Account == account {
+				balance += posting.Amount
+			}
+		}
+	}
+	return balance
+}
+
+// LedgerMismatch описывает счёт, у которого баланс не совпадает с главной книгой.
+type LedgerMismatch struct {
+	AccountID int64
+	Balance   types.Money
+	Ledger    types.Money
+}
+
+// LedgerError возвращается VerifyLedger и перечисляет все найденные расхождения.
+type LedgerError struct {
+	Mismatches        []LedgerMismatch
+	UnbalancedEntries []string
+	UnknownAccounts   []string
+}
+
+func (e *LedgerError) Error() string {
+	return fmt.Sprintf("ledger mismatch: %d accounts, %d unbalanced entries, %d unknown accounts",
+		len(e.Mismatches), len(e.UnbalancedEntries), len(e.UnknownAccounts))
+}
+
+func (e *LedgerError) Unwrap() error {
+	return ErrLedgerMismatch
+}
+
+// VerifyLedger пересчитывает балансы всех счетов по проводкам из хранилища
+// и сравнивает их с Account.Balance. Возвращает nil, если расхождений нет,
+// иначе *LedgerError.
+func (s *Service) VerifyLedger() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries, err := s.repository().LedgerEntries()
+	if err != nil {
+		return err
+	}
+	report := &LedgerError{}
+	for _, entry := range entries {
+		var sum types.Money
+		for _, posting := range entry.Postings {
+			sum += posting.Amount
+		}
+		if sum != 0 {
+			report.UnbalancedEntries = append(report.UnbalancedEntries, entry.ID)
+		}
+	}
+	balances := ledgerBalances(entries)
+
+	accounts, err := s.repository().Accounts()
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		ledger := balances[account.ID]
+		if ledger != account.Balance {
+			report.Mismatches = append(report.Mismatches, LedgerMismatch{
+				AccountID: account.ID,
+				Balance:   account.Balance,
+				Ledger:    ledger,
+			})
+		}
+		delete(balances, account.ID)
+	}
+	for id := range balances {
+		report.UnknownAccounts = append(report.UnknownAccounts, customerLedgerAccount(id))
+	}
+	sort.Strings(report.UnknownAccounts)
+
+	if len(report.Mismatches) == 0 && len(report.UnbalancedEntries) == 0 && len(report.UnknownAccounts) == 0 {
+		return nil
+	}
+	return report
+}
@@ -0,0 +1,326 @@
+package wallet
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestService_VerifyLedger_success(t *testing.T) {
+	s := newTestService()
+
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	other, err := s.addAccountWithBalance("+992935444994", 100_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Transfer(account.ID, other.ID, 50_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Errorf("VerifyLedger(): error = %v", err)
+		return
+	}
+
+	if got := s.LedgerBalance(LedgerDeposits); got != -(defaultTestAccount.balance + 100_00) {
+		t.Errorf("LedgerBalance(): wrong deposits balance = %v", got)
+	}
+
+	entries, err := s.AccountLedger(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(entries) != 4 {
+		t.Errorf("AccountLedger(): must return 4 entries, returned %v", entries)
+	}
+}
+
+func TestService_VerifyLedger_mismatch(t *testing.T) {
+	s := newTestService()
+
+	account, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	saved, err := s.findAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	saved.Balance += 1
+	err = s.repository().SaveAccount(saved)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.VerifyLedger()
+	var ledgerErr *LedgerError
+	if !errors.As(err, &ledgerErr) {
+		t.Errorf("VerifyLedger(): must return LedgerError, returned %v", err)
+		return
+	}
+	if len(ledgerErr.Mismatches) != 1 || ledgerErr.Mismatches[0].AccountID != account.ID {
+		t.Errorf("VerifyLedger(): wrong mismatches = %v", ledgerErr.Mismatches)
+	}
+}
+
+func TestService_VerifyLedger_afterImport(t *testing.T) {
+	s := newTestService()
+
+	_, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = imported.VerifyLedger()
+	if err != nil {
+		t.Errorf("VerifyLedger(): error after Import = %v", err)
+		return
+	}
+	// Книга приходит из выгрузки, а не собирается заново из балансов.
+	if got, want := imported.LedgerEntries(), s.LedgerEntries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Import(): ledger = %v, want %v", got, want)
+	}
+}
+
+func TestService_VerifyLedger_afterRestart(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := NewService(repo)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := s.LedgerEntries()
+
+	// Баланс изменён в обход книги.
+	saved, err := repo.AccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	saved.Balance += 1
+	err = repo.SaveAccount(saved)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = repo.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	repo, err = OpenFileRepository(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer repo.Close()
+	restarted, err := NewService(repo)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got := restarted.LedgerEntries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewService(): ledger after restart = %v, want %v", got, want)
+		return
+	}
+	err = restarted.VerifyLedger()
+	var ledgerErr *LedgerError
+	if !errors.As(err, &ledgerErr) || len(ledgerErr.Mismatches) != 1 || ledgerErr.Mismatches[0].AccountID != account.ID {
+		t.Errorf("VerifyLedger(): must report tampered balance, returned %v", err)
+	}
+}
+
+func TestNewService_openingEntries(t *testing.T) {
+	repo := NewMemoryRepository()
+	err := repo.SaveAccount(&types.Account{ID: 1, Phone: "+992880806776", Balance: 500_00})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Хранилище без книги получает открывающие записи один раз.
+	for i := 0; i < 2; i++ {
+		s, err := NewService(repo)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		entries := s.LedgerEntries()
+		if len(entries) != 1 || entries[0].Kind != EntryOpening {
+			t.Errorf("NewService(): ledger = %v, want one opening entry", entries)
+			return
+		}
+		err = s.VerifyLedger()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func TestOpen_replayLedger(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Checkpoint()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := s.LedgerEntries()
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Запись пополнения - из снимка, запись платежа - из журнала.
+	s, err = Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+	got := s.LedgerEntries()
+	if len(got) != len(want) || !reflect.DeepEqual(got[0], want[0]) {
+		t.Errorf("Open(): ledger = %v, want %v", got, want)
+		return
+	}
+	for i := range got {
+		if got[i].Kind != want[i].Kind || !reflect.DeepEqual(got[i].Postings, want[i].Postings) {
+			t.Errorf("Open(): ledger entry %d = %v, want %v", i, got[i], want[i])
+			return
+		}
+	}
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_post_unbalanced(t *testing.T) {
+	s := newTestService()
+
+	account, err := s.addAccountWithBalance("+992880806776", 100_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.post(EntryDeposit, "test",
+		types.Posting{Account: customerLedgerAccount(account.ID), Amount: 10_00},
+	)
+	if err != ErrUnbalancedEntry {
+		t.Errorf("post(): must return ErrUnbalancedEntry, returned %v", err)
+	}
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 100_00 {
+		t.Errorf("post(): balance changed on unbalanced entry, account = %v", account)
+	}
+}
+
+func TestService_Deposit_overflow(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", math.MaxInt64-10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Deposit(account.ID, 11)
+	if !errors.Is(err, types.ErrMoneyOverflow) {
+		t.Errorf("Deposit(): must return ErrMoneyOverflow, returned %v", err)
+		return
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != math.MaxInt64-10 {
+		t.Errorf("Deposit(): balance changed on overflow, account = %v, error = %v", got, err)
+		return
+	}
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,253 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+var ErrLimitExceeded = errors.New("payment limit exceeded")
+
+// LimitKind - какой из лимитов Limit не пропустил платёж.
+type LimitKind string
+
+const (
+	LimitMaxPayment  LimitKind = "max-payment"  // сумма одного платежа
+	LimitDaily       LimitKind = "daily"        // сумма платежей за календарный день (UTC)
+	LimitMonthly     LimitKind = "monthly"      // сумма платежей за календарный месяц (UTC)
+	LimitHourlyCount LimitKind = "hourly-count" // число платежей за последний час
+)
+
+// Limit - ограничения на платежи в валюте счёта. Нулевое поле - без ограничения.
+type Limit struct {
+	MaxPayment  types.Money
+	Daily       types.Money
+	Monthly     types.Money
+	HourlyCount int
+}
+
+// Limits - лимиты платежей. Лимит счёта - Accounts[ID счёта] или, если его нет,
+// Default - считается по всем платежам счёта. Лимит категории Categories[...]
+// считается по платежам счёта в этой категории. Платёж должен пройти оба.
+// Нулевое значение - без лимитов.
+type Limits struct {
+	Default    Limit
+	Accounts   map[int64]Limit
+	Categories map[types.PaymentCategory]Limit
+}
+
+// LimitError возвращается, когда платёж превысил бы лимит. Category пуста,
+// если сработал лимит счёта, а не категории. Limit - значение лимита:
+// сумма или, для LimitHourlyCount, число платежей.
+type LimitError struct {
+	AccountID int64
+	Category  types.PaymentCategory
+	Kind      LimitKind
+	Limit     int64
+}
+
+func (e *LimitError) Error() string {
+	if e.Category == "" {
+		return fmt.Sprintf("account %d: %s limit %d exceeded", e.AccountID, e.Kind, e.Limit)
+	}
+	return fmt.Sprintf("account %d, category %q: %s limit %d exceeded", e.AccountID, e.Category, e.Kind, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// Unlimited - значение поля Allowance, если соответствующего лимита нет.
+const Unlimited = -1
+
+// Allowance - сколько ещё можно заплатить со счёта до лимитов: Payment -
+// самый большой платёж, который пройдёт сейчас, Daily и Monthly - остаток
+// дневного и месячного лимита, Payments - сколько ещё платежей можно в этот час.
+type Allowance struct {
+	Payment  types.Money
+	Daily    types.Money
+	Monthly  types.Money
+	Payments int
+}
+
+// SetLimits задаёт лимиты, которые проверяют Pay, PayIn, PayFromFavorite,
+// Repeat и Authorize.
+func (s *Service) SetLimits(limits Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits = limits
+}
+
+// RemainingAllowance возвращает остаток лимитов счёта для платежа в категории
+// category; с пустой категорией учитываются только лимиты счёта.
+func (s *Service) RemainingAllowance(accountID int64, category types.PaymentCategory) (*Allowance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	account, inCategory, err := s.spending(accountID, category)
+	if err != nil {
+		return nil, err
+	}
+
+	allowance := &Allowance{Payment: Unlimited, Daily: Unlimited, Monthly: Unlimited, Payments: Unlimited}
+	allowance.restrict(s.accountLimit(accountID), account)
+	if category != "" {
+		allowance.restrict(s.limits.Categories[category], inCategory)
+	}
+	allowance.Payment = minAllowed(allowance.Payment, allowance.Daily)
+	allowance.Payment = minAllowed(allowance.Payment, allowance.Monthly)
+	if allowance.Payments == 0 {
+		allowance.Payment = 0
+	}
+	return allowance, nil
+}
+
+// restrict урезает остаток по лимиту limit при тратах spent.
+func (a *Allowance) restrict(limit Limit, spent spending) {
+	if limit.MaxPayment > 0 {
+		a.Payment = minAllowed(a.Payment, limit.MaxPayment)
+	}
+	if limit.Daily > 0 {
+		a.Daily = minAllowed(a.Daily, nonNegative(limit.Daily-spent.daily))
+	}
+	if limit.Monthly > 0 {
+		a.Monthly = minAllowed(a.Monthly, nonNegative(limit.Monthly-spent.monthly))
+	}
+	if limit.HourlyCount > 0 {
+		left := limit.HourlyCount - spent.hourly
+		if left < 0 {
+			left = 0
+		}
+		if a.Payments == Unlimited || left < a.Payments {
+			a.Payments = left
+		}
+	}
+}
+
+// minAllowed - меньший из двух остатков, Unlimited больше любого.
+func minAllowed(current, limit types.Money) types.Money {
+	if limit != Unlimited && (current == Unlimited || limit < current) {
+		return limit
+	}
+	return current
+}
+
+func nonNegative(amount types.Money) types.Money {
+	if amount < 0 {
+		return 0
+	}
+	return amount
+}
+
+// accountLimit - лимит счёта: свой или Default.
+func (s *Service) accountLimit(accountID int64) Limit {
+	limit, ok := s.limits.Accounts[accountID]
+	if !ok {
+		return s.limits.Default
+	}
+	return limit
+}
+
+// checkLimits проверяет платёж amount в валюте счёта до записи операции
+// в журнал: при повторе журнала лимиты не проверяются, платёж уже прошёл.
+func (s *Service) checkLimits(accountID int64, amount types.Money, category types.PaymentCategory) error {
+	accountLimit := s.accountLimit(accountID)
+	categoryLimit, hasCategory := s.limits.Categories[category]
+	if accountLimit == (Limit{}) && (!hasCategory || categoryLimit == (Limit{})) {
+		return nil
+	}
+
+	account, inCategory, err := s.spending(accountID, category)
+	if err != nil {
+		return err
+	}
+	exceeded := checkLimit(accountLimit, account, amount)
+	if exceeded != nil {
+		exceeded.AccountID = accountID
+		return exceeded
+	}
+	if hasCategory {
+		exceeded = checkLimit(categoryLimit, inCategory, amount)
+		if exceeded != nil {
+			exceeded.AccountID = accountID
+			exceeded.Category = category
+			return exceeded
+		}
+	}
+	return nil
+}
+
+func checkLimit(limit Limit, spent spending, amount types.Money) *LimitError {
+	switch {
+	case limit.MaxPayment > 0 && amount > limit.MaxPayment:
+		return &LimitError{Kind: LimitMaxPayment, Limit: int64(limit.MaxPayment)}
+	case limit.Daily > 0 && amount > limit.Daily-spent.daily:
+		return &LimitError{Kind: LimitDaily, Limit: int64(limit.Daily)}
+	case limit.Monthly > 0 && amount > limit.Monthly-spent.monthly:
+		return &LimitError{Kind: LimitMonthly, Limit: int64(limit.Monthly)}
+	case limit.HourlyCount > 0 && spent.hourly >= limit.HourlyCount:
+		return &LimitError{Kind: LimitHourlyCount, Limit: int64(limit.HourlyCount)}
+	}
+	return nil
+}
+
+// spending - траты в окнах лимитов.
+type spending struct {
+	daily   types.Money
+	monthly types.Money
+	hourly  int
+}
+
+// spending считает траты счёта: все и отдельно в категории category. В счёт
+// идут платежи в статусах INPROGRESS и OK и действующие удержания, кроме
+// строк комиссии и возврата.
+func (s *Service) spending(accountID int64, category types.PaymentCategory) (spending, spending, error) {
+	var account, inCategory spending
+
+	payments, err := s.repository().PaymentsByAccount(accountID)
+	if err != nil {
+		return account, inCategory, err
+	}
+
+	now := s.now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	hour := now.Add(-time.Hour)
+	for _, payment := range payments {
+		if payment.ParentID != "" {
+			continue
+		}
+		switch payment.Status {
+		case types.PaymentStatusInProgress, types.PaymentStatusOk, types.PaymentStatusAuthorized:
+		default:
+			continue
+		}
+		account.add(payment, day, month, hour)
+		if payment.Category == category {
+			inCategory.add(payment, day, month, hour)
+		}
+	}
+	return account, inCategory, nil
+}
+
+// add учитывает платёж в окнах. Часовое окно не вложено в месяц: в начале
+// месяца в него попадают платежи конца прошлого.
+func (spent *spending) add(payment types.Payment, day, month, hour time.Time) {
+	if payment.CreatedAt.After(hour) {
+		spent.hourly++
+	}
+	if payment.CreatedAt.Before(month) {
+		return
+	}
+	spent.monthly += payment.Amount
+	if !payment.CreatedAt.Before(day) {
+		spent.daily += payment.Amount
+	}
+}
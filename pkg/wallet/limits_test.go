@@ -0,0 +1,269 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestService_Pay_limits(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC)}
+	s.SetClock(clock.Now)
+	account, err := s.addAccountWithBalance("+992880806776", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetLimits(Limits{
+		Accounts: map[int64]Limit{
+			account.ID: {MaxPayment: 500_00, Daily: 800_00, HourlyCount: 3},
+		},
+	})
+
+	tests := []struct {
+		amount types.Money
+		kind   LimitKind
+	}{
+		{600_00, LimitMaxPayment},
+		{500_00, ""},
+		{300_00, ""},
+		{1, LimitDaily},
+	}
+	for _, tt := range tests {
+		_, err = s.Pay(account.ID, tt.amount, "auto")
+		if tt.kind == "" {
+			if err != nil {
+				t.Errorf("Pay(%d): error = %v", tt.amount, err)
+				return
+			}
+			continue
+		}
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Kind != tt.kind || limitErr.AccountID != account.ID || !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("Pay(%d): returned %v, want %s limit", tt.amount, err, tt.kind)
+			return
+		}
+	}
+
+	// На следующий день дневной лимит снова свободен, а часовой считается заново.
+	clock.add(24 * time.Hour)
+	for i := 0; i < 3; i++ {
+		_, err = s.Pay(account.ID, 10_00, "auto")
+		if err != nil {
+			t.Errorf("Pay(): error = %v", err)
+			return
+		}
+	}
+	_, err = s.Pay(account.ID, 10_00, "auto")
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Kind != LimitHourlyCount {
+		t.Errorf("Pay(): returned %v, want hourly-count limit", err)
+		return
+	}
+	clock.add(time.Hour)
+	_, err = s.Pay(account.ID, 10_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+	}
+}
+
+func TestService_Pay_limitsMonthBoundary(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2020, 10, 31, 23, 30, 0, 0, time.UTC)}
+	s.SetClock(clock.Now)
+	account, err := s.addAccountWithBalance("+992880806776", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetLimits(Limits{Default: Limit{Monthly: 300_00, HourlyCount: 2}})
+
+	for i := 0; i < 2; i++ {
+		_, err = s.Pay(account.ID, 150_00, "auto")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Месячный лимит с 1-го числа свободен, но платежи последнего часа
+	// прошлого месяца всё ещё идут в часовой.
+	clock.add(40 * time.Minute)
+	_, err = s.Pay(account.ID, 150_00, "auto")
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Kind != LimitHourlyCount {
+		t.Errorf("Pay(): returned %v, want hourly-count limit", err)
+		return
+	}
+	clock.add(30 * time.Minute)
+	_, err = s.Pay(account.ID, 300_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+	}
+}
+
+func TestService_Pay_categoryLimit(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetLimits(Limits{
+		Categories: map[types.PaymentCategory]Limit{"casino": {Monthly: 100_00}},
+	})
+
+	casino, err := s.Pay(account.ID, 100_00, "casino")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 1000_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): other categories are not limited, error = %v", err)
+		return
+	}
+	_, err = s.Pay(account.ID, 1, "casino")
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Kind != LimitMonthly || limitErr.Category != "casino" {
+		t.Errorf("Pay(): returned %v, want monthly limit of category", err)
+		return
+	}
+
+	// Отклонённый платёж лимит не расходует.
+	err = s.Reject(casino.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 100_00, "casino")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+	}
+}
+
+func TestService_Repeat_limits(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payment.ID, "car")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s.SetLimits(Limits{Default: Limit{Daily: 250_00}})
+	_, err = s.Repeat(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Repeat(payment.ID)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Repeat(): must return ErrLimitExceeded, returned %v", err)
+		return
+	}
+	_, err = s.PayFromFavorite(favorite.ID)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("PayFromFavorite(): must return ErrLimitExceeded, returned %v", err)
+		return
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 10_000_00-200_00 {
+		t.Errorf("limited payments must not be debited, account = %v, error = %v", got, err)
+	}
+}
+
+func TestService_Authorize_limits(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetLimits(Limits{Default: Limit{MaxPayment: 500_00, Daily: 800_00}})
+
+	_, err = s.Authorize(account.ID, 600_00, "auto")
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Kind != LimitMaxPayment {
+		t.Errorf("Authorize(): returned %v, want max-payment limit", err)
+		return
+	}
+	hold, err := s.Authorize(account.ID, 500_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// Действующее удержание уже расходует дневной лимит.
+	_, err = s.Authorize(account.ID, 400_00, "auto")
+	if !errors.As(err, &limitErr) || limitErr.Kind != LimitDaily {
+		t.Errorf("Authorize(): returned %v, want daily limit", err)
+		return
+	}
+	_, err = s.Pay(account.ID, 400_00, "auto")
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Pay(): must return ErrLimitExceeded, returned %v", err)
+		return
+	}
+
+	err = s.Void(hold.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Authorize(account.ID, 400_00, "auto")
+	if err != nil {
+		t.Errorf("Authorize(): voided hold must not use the limit, error = %v", err)
+	}
+}
+
+func TestService_RemainingAllowance(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetLimits(Limits{
+		Default:    Limit{MaxPayment: 500_00, Daily: 1000_00, HourlyCount: 5},
+		Categories: map[types.PaymentCategory]Limit{"auto": {Daily: 300_00}},
+	})
+	_, err = s.Pay(account.ID, 200_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 400_00, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := s.RemainingAllowance(account.ID, "")
+	want := Allowance{Payment: 400_00, Daily: 400_00, Monthly: Unlimited, Payments: 3}
+	if err != nil || *got != want {
+		t.Errorf("RemainingAllowance() = %v, error = %v, want %v", got, err, want)
+		return
+	}
+	got, err = s.RemainingAllowance(account.ID, "auto")
+	want = Allowance{Payment: 100_00, Daily: 100_00, Monthly: Unlimited, Payments: 3}
+	if err != nil || *got != want {
+		t.Errorf("RemainingAllowance(auto) = %v, error = %v, want %v", got, err, want)
+		return
+	}
+	_, err = s.RemainingAllowance(account.ID+1, "")
+	if err != ErrAccountNotFound {
+		t.Errorf("RemainingAllowance(): must return ErrAccountNotFound, returned %v", err)
+	}
+}
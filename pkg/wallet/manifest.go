@@ -0,0 +1,283 @@
+package wallet
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrManifestMismatch = errors.New("export manifest mismatch")
+
+const (
+	manifestFile = "manifest.json"
+	// dumpFormatVersion - версия формата строк dump-файлов, которую пишет Export.
+	dumpFormatVersion = 2
+)
+
+// Manifest описывает одну выгрузку: какие dump-файлы в неё входят,
+// сколько в каждом записей и их SHA-256. Export пишет его последним,
+// поэтому недописанная выгрузка с ним не сходится.
+type Manifest struct {
+	Files []ManifestFile `json:"files"`
+}
+
+// ManifestFile - запись манифеста об одном dump-файле.
+type ManifestFile struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+	Format  int    `json:"format"`
+	SHA256  string `json:"sha256"`
+}
+
+// ManifestError перечисляет файлы выгрузки, которые не сошлись с манифестом.
+type ManifestError struct {
+	Dir      string
+	Problems []string
+}
+
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("%s: export manifest mismatch: %s", e.Dir, strings.Join(e.Problems, "; "))
+}
+
+func (e *ManifestError) Unwrap() error {
+	return ErrManifestMismatch
+}
+
+// dumpFile - dump-файл, подготовленный к записи: заголовок схемы и по строке
+// на каждую из records записей. Строки пишутся потоком, файл целиком в
+// памяти не собирается.
+type dumpFile struct {
+	name    string
+	schema  dumpSchema
+	records int
+	line    func(i int) string
+}
+
+func (f *dumpFile) writeTo(w io.Writer) error {
+	_, err := io.WriteString(w, f.schema.header())
+	for i := 0; i < f.records && err == nil; i++ {
+		_, err = io.WriteString(w, f.line(i))
+	}
+	return err
+}
+
+// writeExport пишет dump-файлы и манифест в dir. Каждый файл сначала
+// пишется во временный рядом и переименовывается, так что прерванная
+// запись не оставляет обрезанных файлов под настоящими именами.
+func writeExport(dir string, files []dumpFile) error {
+	manifest := Manifest{Files: []ManifestFile{}}
+	for i := range files {
+		file := &files[i]
+		hash := sha256.New()
+		err := writeFileAtomicFunc(filepath.Join(dir, file.name), func(w io.Writer) error {
+			return file.writeTo(io.MultiWriter(w, hash))
+		})
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Name:    file.name,
+			Records: file.records,
+			Format:  dumpFormatVersion,
+			SHA256:  hex.EncodeToString(hash.Sum(nil)),
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, manifestFile), append(data, '\n'))
+}
+
+// writeFileAtomic записывает data во временный файл, сбрасывает его на диск
+// и переименовывает в path.
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileAtomicFunc(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomicFunc - то же, но содержимое пишет write, так что большой
+// файл не нужно собирать в памяти целиком. Права берутся у файла, который
+// заменяется, а у нового - 0644, как у os.Create.
+func writeFileAtomicFunc(path string, write func(w io.Writer) error) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	err = tmp.Chmod(mode)
+	buffered := bufio.NewWriter(tmp)
+	if err == nil {
+		err = write(buffered)
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir сбрасывает на диск сам каталог, чтобы переименование пережило падение.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = file.Sync()
+	if err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
+
+// readManifest читает манифест выгрузки. Если манифеста нет (выгрузка
+// сделана старой версией), возвращает nil без ошибки.
+func readManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, &ManifestError{Dir: dir, Problems: []string{"can't parse manifest: " + err.Error()}}
+	}
+	return manifest, nil
+}
+
+// verifyManifest сверяет dump-файлы в dir с манифестом и возвращает
+// множество файлов, которые надо загрузить. Для выгрузки без манифеста
+// пишет предупреждение в лог и возвращает nil - загружаются все файлы.
+func verifyManifest(dir string) (map[string]bool, error) {
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		log.Printf("%s: no %s, export can't be verified", dir, manifestFile)
+		return nil, nil
+	}
+
+	listed := map[string]bool{}
+	report := &ManifestError{Dir: dir}
+	for _, file := range manifest.Files {
+		listed[file.Name] = true
+
+		if file.Format > dumpFormatVersion {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: unsupported format version %d", file.Name, file.Format))
+			continue
+		}
+
+		sum, records, err := scanDump(filepath.Join(dir, file.Name))
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %v", file.Name, err))
+			continue
+		}
+		if sum != file.SHA256 {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: checksum mismatch", file.Name))
+			continue
+		}
+		if records != file.Records {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %d records, manifest says %d", file.Name, records, file.Records))
+		}
+	}
+
+	if len(report.Problems) != 0 {
+		return nil, report
+	}
+	return listed, nil
+}
+
+// scanDump за один проход по файлу считает его SHA-256 и число записей,
+// не читая файл в память целиком.
+func scanDump(path string) (string, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	scanner := bufio.NewScanner(io.TeeReader(file, hash))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxDumpLine)
+	records := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) != 0 && !isDumpHeader(line) {
+			records++
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), records, nil
+}
+
+// updateManifest пересчитывает в манифесте записи о переписанных файлах.
+// Каталог без манифеста остаётся без него.
+func updateManifest(dir string, names []string) error {
+	manifest, err := readManifest(dir)
+	if err != nil || manifest == nil {
+		return err
+	}
+
+	changed := map[string]bool{}
+	for _, name := range names {
+		changed[name] = true
+	}
+	for i, file := range manifest.Files {
+		if !changed[file.Name] {
+			continue
+		}
+		sum, records, err := scanDump(filepath.Join(dir, file.Name))
+		if err != nil {
+			return err
+		}
+		manifest.Files[i].Records = records
+		manifest.Files[i].Format = dumpFormatVersion
+		manifest.Files[i].SHA256 = sum
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, manifestFile), append(data, '\n'))
+}
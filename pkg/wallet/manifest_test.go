@@ -0,0 +1,161 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestService_Export_manifest(t *testing.T) {
+	s := newTestService()
+	_, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Errorf("Export(): error = %v", err)
+		return
+	}
+
+	manifest, err := readManifest(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if manifest == nil || len(manifest.Files) != 7 {
+		t.Errorf("Export(): invalid manifest = %v", manifest)
+		return
+	}
+	records := map[string]int{}
+	for _, file := range manifest.Files {
+		if file.Format != dumpFormatVersion || len(file.SHA256) != 64 {
+			t.Errorf("Export(): invalid manifest file = %v", file)
+			return
+		}
+		records[file.Name] = file.Records
+	}
+	if records[accountsFile] != 1 || records[paymentsFile] != len(defaultTestAccount.payments) ||
+		records[ledgerFile] != len(s.LedgerEntries()) {
+		t.Errorf("Export(): invalid record counts = %v", records)
+		return
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(entries) != 8 {
+		t.Errorf("Export(): temp files left behind, entries = %d", len(entries))
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+	}
+}
+
+func TestService_Import_manifestMismatch(t *testing.T) {
+	s := newTestService()
+	_, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	path := filepath.Join(dir, accountsFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data[0] = '7'
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	var manifestErr *ManifestError
+	if !errors.As(err, &manifestErr) || !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("Import(): must return ManifestError, returned %v", err)
+		return
+	}
+	accounts, err := imported.repository().Accounts()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(accounts) != 0 {
+		t.Errorf("Import(): tampered export was loaded, accounts = %v", accounts)
+	}
+}
+
+func TestService_Import_manifestMissingFile(t *testing.T) {
+	s := newTestService()
+	_, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = os.Remove(filepath.Join(dir, paymentsFile))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = newTestService().Import(dir)
+	if !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("Import(): must return ErrManifestMismatch, returned %v", err)
+	}
+}
+
+func TestService_Export_permissions(t *testing.T) {
+	s := newTestService()
+	_, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, name := range []string{accountsFile, paymentsFile, manifestFile} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if info.Mode().Perm() != 0644 {
+			t.Errorf("Export(): %s mode = %v, want 0644", name, info.Mode())
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+var ErrRateNotFound = errors.New("exchange rate not found")
+var ErrBadRate = errors.New("bad exchange rate")
+var ErrUnsupportedRatesFormat = errors.New("unsupported rates file format")
+var ErrConversionOverflow = types.ErrMoneyOverflow
+
+// rateDigits - знаков после запятой в курсе (см. types.RateScale).
+const rateDigits = 6
+
+// RateProvider отдаёт средний курс from -> to, действующий на момент at:
+// сколько единиц валюты to дают за единицу валюты from.
+type RateProvider interface {
+	Rate(from, to types.Currency, at time.Time) (types.Rate, error)
+}
+
+// Rounding - способ округления суммы после пересчёта в другую валюту.
+type Rounding int
+
+const (
+	RoundHalfUp   Rounding = iota // половина - от нуля
+	RoundHalfEven                 // половина - к чётному (банковское округление)
+	RoundDown                     // отбросить остаток (к нулю)
+	RoundUp                       // любой остаток - от нуля
+)
+
+// ConversionOptions - правила пересчёта платежей и пополнений в валюту счёта.
+type ConversionOptions struct {
+	Rounding Rounding
+	// SpreadBasisPoints - наценка к среднему курсу в сотых долях процента:
+	// при оплате в чужой валюте курс выше среднего, при пополнении - ниже.
+	SpreadBasisPoints int64
+}
+
+// SetRateProvider включает пересчёт валют: платежи и пополнения в валюте,
+// отличной от валюты счёта, пересчитываются по курсам provider. Без провайдера
+// такие операции отклоняются с ErrCurrencyMismatch.
+func (s *Service) SetRateProvider(provider RateProvider, options ConversionOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rates = provider
+	s.conversion = options
+}
+
+// ExchangeRate - курс from -> to, действующий с EffectiveFrom.
+type ExchangeRate struct {
+	From          types.Currency
+	To            types.Currency
+	Rate          types.Rate
+	EffectiveFrom time.Time
+}
+
+type currencyPair struct {
+	from types.Currency
+	to   types.Currency
+}
+
+// RateTable - таблица курсов в памяти. На каждую пару валют курсы хранятся
+// по датам вступления в силу; действует последний вступивший.
+type RateTable struct {
+	rates map[currencyPair][]ExchangeRate
+}
+
+// NewRateTable собирает таблицу из списка курсов.
+func NewRateTable(rates []ExchangeRate) (*RateTable, error) {
+	table := &RateTable{rates: map[currencyPair][]ExchangeRate{}}
+	for _, rate := range rates {
+		if !rate.From.Known() || !rate.To.Known() {
+			return nil, fmt.Errorf("%w: %s -> %s", ErrUnknownCurrency, rate.From, rate.To)
+		}
+		if rate.Rate <= 0 || rate.From == rate.To {
+			return nil, fmt.Errorf("%w: %s -> %s %d", ErrBadRate, rate.From, rate.To, rate.Rate)
+		}
+		pair := currencyPair{rate.From, rate.To}
+		table.rates[pair] = append(table.rates[pair], rate)
+	}
+	for _, list := range table.rates {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].EffectiveFrom.Before(list[j].EffectiveFrom)
+		})
+	}
+	return table, nil
+}
+
+// Rate реализует RateProvider. Если прямого курса нет, берётся обратный.
+func (t *RateTable) Rate(from, to types.Currency, at time.Time) (types.Rate, error) {
+	if from == to {
+		return types.RateScale, nil
+	}
+	rate, ok := t.find(from, to, at)
+	if ok {
+		return rate, nil
+	}
+	rate, ok = t.find(to, from, at)
+	if ok {
+		inverse := (int64(types.RateScale)*int64(types.RateScale) + int64(rate)/2) / int64(rate)
+		if inverse > 0 {
+			return types.Rate(inverse), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s -> %s at %s", ErrRateNotFound, from, to, at.Format(time.RFC3339))
+}
+
+func (t *RateTable) find(from, to types.Currency, at time.Time) (types.Rate, bool) {
+	list := t.rates[currencyPair{from, to}]
+	i := sort.Search(len(list), func(i int) bool {
+		return list[i].EffectiveFrom.After(at)
+	})
+	if i == 0 {
+		return 0, false
+	}
+	return list[i-1].Rate, true
+}
+
+// LoadRateTable читает таблицу курсов из файла. Формат - по расширению:
+//
+//	.csv  - заголовок from,to,rate,effective_from и строки "USD,TJS,10.92,2020-10-01";
+//	.json - {"version":1,"rates":[{"from":"USD","to":"TJS","rate":10.92,"effective_from":"2020-10-01"}]}.
+//
+// Дата - в виде 2006-01-02 или RFC 3339.
+func LoadRateTable(path string) (*RateTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	name := filepath.Base(path)
+	var rates []ExchangeRate
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rates, err = readRatesCSV(file, name)
+	case ".json":
+		rates, err = readRatesJSON(file, name)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedRatesFormat, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewRateTable(rates)
+}
+
+func readRatesCSV(r io.Reader, name string) ([]ExchangeRate, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if strings.Join(header, ",") != "from,to,rate,effective_from" {
+		return nil, fmt.Errorf("%s: csv header: want from,to,rate,effective_from, got %q", name, strings.Join(header, ","))
+	}
+
+	rates := []ExchangeRate{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		rate, err := parseExchangeRate(record[0], record[1], record[2], record[3])
+		if err != nil {
+			return nil, &LineError{File: name, Line: line, Err: err}
+		}
+		rates = append(rates, rate)
+	}
+}
+
+type jsonRate struct {
+	From          types.Currency `json:"from"`
+	To            types.Currency `json:"to"`
+	Rate          json.Number    `json:"rate"`
+	EffectiveFrom string         `json:"effective_from"`
+}
+
+type jsonRates struct {
+	Version int        `json:"version"`
+	Rates   []jsonRate `json:"rates"`
+}
+
+func readRatesJSON(r io.Reader, name string) ([]ExchangeRate, error) {
+	document := jsonRates{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	err := decoder.Decode(&document)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if document.Version > jsonFormatVersion {
+		return nil, fmt.Errorf("%s: %w: %d", name, ErrUnsupportedDumpVersion, document.Version)
+	}
+
+	rates := []ExchangeRate{}
+	for i, item := range document.Rates {
+		rate, err := parseExchangeRate(string(item.From), string(item.To), item.Rate.String(), item.EffectiveFrom)
+		if err != nil {
+			return nil, fmt.Errorf("%s: record %d: %w", name, i+1, err)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+func parseExchangeRate(from, to, rate, effectiveFrom string) (ExchangeRate, error) {
+	value, err := parseRate(rate)
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+	at, err := time.Parse("2006-01-02", effectiveFrom)
+	if err != nil {
+		at, err = time.Parse(time.RFC3339, effectiveFrom)
+		if err != nil {
+			return ExchangeRate{}, err
+		}
+	}
+	return ExchangeRate{
+		From:          types.Currency(from),
+		To:            types.Currency(to),
+		Rate:          value,
+		EffectiveFrom: at,
+	}, nil
+}
+
+// parseRate разбирает курс "10.92" с rateDigits знаками после точки.
+// Курс должен быть положительным и помещаться в types.Rate.
+func parseRate(value string) (types.Rate, error) {
+	rate, err := types.ParseDecimal(value, rateDigits)
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("%w: %q", ErrBadRate, value)
+	}
+	return types.Rate(rate), nil
+}
+
+// Convert пересчитывает amount из валюты from в валюту to по курсу rate
+// с учётом разного числа знаков у валют и округляет результат.
+func Convert(amount types.Money, from, to types.Currency, rate types.Rate, rounding Rounding) (types.Money, error) {
+	fromUnits, ok := from.MinorUnits()
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownCurrency, from)
+	}
+	toUnits, ok := to.MinorUnits()
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownCurrency, to)
+	}
+	if rate <= 0 {
+		return 0, fmt.Errorf("%w: %d", ErrBadRate, rate)
+	}
+
+	num := new(big.Int).Mul(big.NewInt(int64(amount)), big.NewInt(int64(rate)))
+	den := big.NewInt(int64(types.RateScale))
+	if toUnits > fromUnits {
+		num.Mul(num, pow10(toUnits-fromUnits))
+	} else {
+		den.Mul(den, pow10(fromUnits-toUnits))
+	}
+
+	result := roundQuotient(num, den, rounding)
+	if !result.IsInt64() {
+		return 0, ErrConversionOverflow
+	}
+	return types.Money(result.Int64()), nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// roundQuotient делит num на положительный den с округлением rounding.
+func roundQuotient(num, den *big.Int, rounding Rounding) *big.Int {
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() == 0 {
+		return quo
+	}
+
+	away := false
+	switch rounding {
+	case RoundUp:
+		away = true
+	case RoundDown:
+		away = false
+	default:
+		twice := new(big.Int).Abs(rem)
+		twice.Lsh(twice, 1)
+		cmp := twice.Cmp(den)
+		away = cmp > 0 || cmp == 0 && (rounding == RoundHalfUp || quo.Bit(0) == 1)
+	}
+	if away {
+		quo.Add(quo, big.NewInt(int64(num.Sign())))
+	}
+	return quo
+}
+
+// Направление обмена: от него зависит, в какую сторону применяется спред.
+const (
+	exchangeSell = iota // клиент платит в чужой валюте - покупает её у нас
+	exchangeBuy         // клиент пополняет счёт чужой валютой - продаёт её нам
+)
+
+// conversion - пересчёт суммы операции в валюту счёта. Считается до записи
+// в журнал и пишется в него, чтобы повтор журнала не зависел от курсов.
+type conversion struct {
+	rate   types.Rate
+	amount types.Money // сумма в валюте счёта
+}
+
+// exchange пересчитывает amount в валюте currency в валюту счёта. Возвращает
+// nil, если пересчёт не нужен или невозможен без провайдера - тогда ошибку
+// вернёт сама операция.
+func (s *Service) exchange(accountID int64, amount types.Money, currency types.Currency, side int) (*conversion, error) {
+	if s.rates == nil || currency == "" || !currency.Known() || amount <= 0 {
+		return nil, nil
+	}
+	account, err := s.findAccountByID(accountID)
+	if err != nil || accountCurrency(account) == currency {
+		return nil, nil
+	}
+
+	to := accountCurrency(account)
+	rate, err := s.rates.Rate(currency, to, s.now())
+	if err != nil {
+		return nil, err
+	}
+	spread := big.NewInt(10_000 + s.conversion.SpreadBasisPoints)
+	if side == exchangeBuy {
+		spread = big.NewInt(10_000 - s.conversion.SpreadBasisPoints)
+	}
+	applied := roundQuotient(new(big.Int).Mul(big.NewInt(int64(rate)), spread), big.NewInt(10_000), RoundHalfUp)
+	if !applied.IsInt64() || applied.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: %s -> %s with spread %d", ErrBadRate, currency, to, s.conversion.SpreadBasisPoints)
+	}
+
+	converted, err := Convert(amount, currency, to, types.Rate(applied.Int64()), s.conversion.Rounding)
+	if err != nil {
+		return nil, err
+	}
+	// Сумма, которая при пересчёте округлилась до нуля, ничего не списывает и не зачисляет.
+	if converted <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	return &conversion{rate: types.Rate(applied.Int64()), amount: converted}, nil
+}
+
+// walConversion восстанавливает пересчёт из записи журнала.
+func walConversion(record walRecord) *conversion {
+	if record.Rate == 0 {
+		return nil
+	}
+	return &conversion{rate: record.Rate, amount: record.Converted}
+}
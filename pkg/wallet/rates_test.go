@@ -0,0 +1,289 @@
+package wallet
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+const testRatesCSV = "from,to,rate,effective_from\n" +
+	"USD,TJS,10.92,2020-10-01\n" +
+	"USD,TJS,11,2020-11-01\n" +
+	"JPY,USD,0.0067,2020-10-01\n"
+
+const testRatesJSON = `{"version":1,"rates":[
+	{"from":"USD","to":"TJS","rate":10.92,"effective_from":"2020-10-01"},
+	{"from":"USD","to":"TJS","rate":11,"effective_from":"2020-11-01T00:00:00Z"},
+	{"from":"JPY","to":"USD","rate":0.0067,"effective_from":"2020-10-01"}
+]}`
+
+func loadTestRates(t *testing.T, name string, data string) *RateTable {
+	path := filepath.Join(t.TempDir(), name)
+	err := WriteToFile(path, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := LoadRateTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestLoadRateTable_effectiveDates(t *testing.T) {
+	for _, file := range []struct{ name, data string }{
+		{"rates.csv", testRatesCSV},
+		{"rates.json", testRatesJSON},
+	} {
+		table := loadTestRates(t, file.name, file.data)
+
+		tests := []struct {
+			from, to types.Currency
+			at       time.Time
+			want     types.Rate
+		}{
+			{types.CurrencyUSD, types.CurrencyTJS, time.Date(2020, 10, 15, 0, 0, 0, 0, time.UTC), 10_920_000},
+			{types.CurrencyUSD, types.CurrencyTJS, time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC), 11_000_000},
+			{types.CurrencyTJS, types.CurrencyUSD, time.Date(2020, 10, 15, 0, 0, 0, 0, time.UTC), 91_575},
+			{types.CurrencyJPY, types.CurrencyUSD, time.Date(2020, 10, 15, 0, 0, 0, 0, time.UTC), 6_700},
+			{types.CurrencyEUR, types.CurrencyEUR, time.Date(2020, 10, 15, 0, 0, 0, 0, time.UTC), types.RateScale},
+		}
+		for _, tt := range tests {
+			got, err := table.Rate(tt.from, tt.to, tt.at)
+			if err != nil || got != tt.want {
+				t.Errorf("%s: Rate(%s, %s, %s) = %d, %v, want %d", file.name, tt.from, tt.to, tt.at, got, err, tt.want)
+			}
+		}
+
+		_, err := table.Rate(types.CurrencyUSD, types.CurrencyTJS, time.Date(2020, 9, 30, 0, 0, 0, 0, time.UTC))
+		if !errors.Is(err, ErrRateNotFound) {
+			t.Errorf("%s: Rate(): must return ErrRateNotFound before effective date, returned %v", file.name, err)
+		}
+	}
+}
+
+func TestLoadRateTable_badRate(t *testing.T) {
+	for _, rate := range []string{"-1", "0", "--1", "1.-5", "abc", "1.0000001", "10000000000000.000000"} {
+		path := filepath.Join(t.TempDir(), "rates.csv")
+		err := WriteToFile(path, "from,to,rate,effective_from\nUSD,TJS,10.92,2020-10-01\nUSD,TJS,"+rate+",2020-11-01\n")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		_, err = LoadRateTable(path)
+		var lineErr *LineError
+		if !errors.Is(err, ErrBadRate) || !errors.As(err, &lineErr) || lineErr.Line != 3 {
+			t.Errorf("LoadRateTable(%q): must return ErrBadRate on line 3, returned %v", rate, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "rates.json")
+	err := WriteToFile(path, `{"version":1,"rates":[{"from":"USD","to":"TJS","rate":-10.92,"effective_from":"2020-10-01"}]}`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = LoadRateTable(path)
+	if !errors.Is(err, ErrBadRate) {
+		t.Errorf("LoadRateTable(): must return ErrBadRate for negative JSON rate, returned %v", err)
+	}
+}
+
+func TestConvert_rounding(t *testing.T) {
+	tests := []struct {
+		amount   types.Money
+		rounding Rounding
+		want     types.Money
+	}{
+		{5, RoundHalfUp, 3},
+		{5, RoundHalfEven, 2},
+		{5, RoundDown, 2},
+		{5, RoundUp, 3},
+		{7, RoundHalfEven, 4},
+		{-5, RoundHalfUp, -3},
+		{-5, RoundDown, -2},
+	}
+	for _, tt := range tests {
+		got, err := Convert(tt.amount, types.CurrencyTJS, types.CurrencyUSD, types.RateScale/2, tt.rounding)
+		if err != nil || got != tt.want {
+			t.Errorf("Convert(%d, rounding %d) = %d, %v, want %d", tt.amount, tt.rounding, got, err, tt.want)
+		}
+	}
+
+	// JPY без минимальных единиц, USD - с двумя знаками.
+	got, err := Convert(1000, types.CurrencyJPY, types.CurrencyUSD, 6_700, RoundHalfUp)
+	if err != nil || got != 6_70 {
+		t.Errorf("Convert(1000 JPY) = %d, %v, want 670", got, err)
+	}
+}
+
+func TestService_PayIn_conversion(t *testing.T) {
+	s := newTestService()
+	s.SetClock((&testClock{now: time.Date(2020, 10, 15, 10, 0, 0, 0, time.UTC)}).Now)
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.PayIn(account.ID, 10_00, types.CurrencyUSD, "netflix")
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("PayIn(): without provider must return ErrCurrencyMismatch, returned %v", err)
+		return
+	}
+
+	s.SetRateProvider(loadTestRates(t, "rates.csv", testRatesCSV), ConversionOptions{SpreadBasisPoints: 100})
+	payment, err := s.PayIn(account.ID, 10_00, types.CurrencyUSD, "netflix")
+	if err != nil {
+		t.Errorf("PayIn(): error = %v", err)
+		return
+	}
+	// 10.92 + 1% = 11.0292, 10.00 USD = 110.292 TJS -> 110.29.
+	if payment.Amount != 110_29 || payment.Currency != types.CurrencyTJS ||
+		payment.OriginalAmount != 10_00 || payment.OriginalCurrency != types.CurrencyUSD || payment.Rate != 11_029_200 {
+		t.Errorf("PayIn(): wrong conversion, payment = %v", payment)
+		return
+	}
+
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 1000_00-110_29 {
+		t.Errorf("PayIn(): wrong balance, account = %v, error = %v", got, err)
+		return
+	}
+
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	got, err = s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 1000_00 {
+		t.Errorf("Reject(): converted amount not refunded, account = %v, error = %v", got, err)
+		return
+	}
+
+	// Пополнение чужой валютой - по курсу ниже среднего: 10.92 - 1% = 10.8108.
+	err = s.DepositIn(account.ID, 10_00, types.CurrencyUSD)
+	if err != nil {
+		t.Errorf("DepositIn(): error = %v", err)
+		return
+	}
+	got, err = s.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 1000_00+108_11 {
+		t.Errorf("DepositIn(): wrong balance, account = %v, error = %v", got, err)
+		return
+	}
+
+	want, err := s.FindPaymentByID(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, format := range []struct {
+		name   string
+		export func(dir string) error
+		load   func(s *testService, dir string) error
+	}{
+		{"dump", s.Export, func(s *testService, dir string) error { return s.Import(dir) }},
+		{"json", s.ExportJSON, func(s *testService, dir string) error { return s.ImportJSON(dir) }},
+	} {
+		dir := t.TempDir()
+		err = format.export(dir)
+		if err != nil {
+			t.Errorf("%s: export error = %v", format.name, err)
+			return
+		}
+		imported := newTestService()
+		err = format.load(imported, dir)
+		if err != nil {
+			t.Errorf("%s: import error = %v", format.name, err)
+			return
+		}
+		got, err := imported.FindPaymentByID(payment.ID)
+		if err != nil || *got != *want {
+			t.Errorf("%s: payment = %v, error = %v, want %v", format.name, got, err, want)
+			return
+		}
+	}
+}
+
+func TestService_PayIn_conversionRoundsToZero(t *testing.T) {
+	s := newTestService()
+	s.SetClock((&testClock{now: time.Date(2020, 10, 15, 10, 0, 0, 0, time.UTC)}).Now)
+	account, err := s.RegisterAccountWithCurrency("+992880806776", types.CurrencyUSD)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.DepositIn(account.ID, 10_00, types.CurrencyUSD)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetRateProvider(loadTestRates(t, "rates.csv", testRatesCSV), ConversionOptions{Rounding: RoundDown})
+
+	// 1 JPY = 0.0067 USD, с округлением вниз - 0 центов.
+	_, err = s.PayIn(account.ID, 1, types.CurrencyJPY, "auto")
+	if err != ErrAmountMustBePositive {
+		t.Errorf("PayIn(): must return ErrAmountMustBePositive, returned %v", err)
+		return
+	}
+	err = s.DepositIn(account.ID, 1, types.CurrencyJPY)
+	if err != ErrAmountMustBePositive {
+		t.Errorf("DepositIn(): must return ErrAmountMustBePositive, returned %v", err)
+		return
+	}
+	payments, err := s.repository().PaymentsByAccount(account.ID)
+	if err != nil || len(payments) != 0 {
+		t.Errorf("PayIn(): zero payment stored, payments = %v, error = %v", payments, err)
+	}
+}
+
+func TestOpen_replayConversion(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetClock((&testClock{now: time.Date(2020, 10, 15, 10, 0, 0, 0, time.UTC)}).Now)
+	s.SetRateProvider(loadTestRates(t, "rates.json", testRatesJSON), ConversionOptions{})
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want, err := s.PayIn(account.ID, 10_00, types.CurrencyUSD, "netflix")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Курсов при восстановлении нет - пересчёт берётся из журнала.
+	s, err = Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	got, err := s.FindPaymentByID(want.ID)
+	if err != nil || *got != *want {
+		t.Errorf("payment after replay = %v, error = %v, want %v", got, err, want)
+	}
+}
@@ -0,0 +1,123 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gholib/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrRefundLine = errors.New("operation is not allowed on a refund line")
+var ErrRefundExceedsPayment = errors.New("refund exceeds payment")
+
+// checkPrimary проверяет, что payment - сам платёж, а не связанная с ним
+// строка комиссии или возврата: такие строки следуют за своим платежом.
+func checkPrimary(payment *types.Payment) error {
+	switch {
+	case payment.ParentID == "":
+		return nil
+	case payment.Category == types.PaymentCategoryRefund:
+		return ErrRefundLine
+	}
+	return ErrFeeLine
+}
+
+// Refund возвращает на счёт часть платежа (amount - в валюте счёта) и
+// создаёт строку возврата, связанную с платежом. Сумма всех возвратов
+// не может превысить сумму платежа. Комиссия при частичном возврате не
+// возвращается - только при Reject или Cancel.
+func (s *Service) Refund(paymentID string, amount types.Money) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refundID := uuid.New().String()
+	err := s.begin(&walRecord{Op: opRefund, ID: refundID, Ref: paymentID, Amount: amount})
+	defer s.end()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.refund(refundID, paymentID, amount)
+}
+
+func (s *Service) refund(refundID string, paymentID string, amount types.Money) (*types.Payment, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPrimary(payment)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != types.PaymentStatusInProgress && payment.Status != types.PaymentStatusOk {
+		return nil, &TransitionError{PaymentID: payment.ID, From: payment.Status, To: types.PaymentStatusOk}
+	}
+
+	refunded, err := payment.Refunded.Add(amount)
+	if err != nil {
+		return nil, err
+	}
+	if refunded > payment.Amount {
+		return nil, fmt.Errorf("%w: payment %s, amount %d, already refunded %d, requested %d",
+			ErrRefundExceedsPayment, payment.ID, payment.Amount, payment.Refunded, amount)
+	}
+
+	entry, err := s.post(EntryRefund, refundID,
+		types.Posting{Account: customerLedgerAccount(payment.AccountID), Amount: amount},
+		types.Posting{Account: LedgerRefunds, Amount: -amount},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	line := &types.Payment{
+		ID:        refundID,
+		AccountID: payment.AccountID,
+		Amount:    -amount,
+		Currency:  payment.Currency,
+		Category:  types.PaymentCategoryRefund,
+		Status:    types.PaymentStatusOk,
+		CreatedAt: entry.CreatedAt,
+		UpdatedAt: entry.CreatedAt,
+		ParentID:  payment.ID,
+	}
+	err = s.repository().SavePayment(line)
+	if err != nil {
+		return nil, err
+	}
+
+	payment.Refunded = refunded
+	payment.UpdatedAt = entry.CreatedAt
+	err = s.repository().SavePayment(payment)
+	if err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+// PaymentRefunds возвращает строки возврата платежа в порядке создания.
+func (s *Service) PaymentRefunds(paymentID string) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	payments, err := s.repository().PaymentsByAccount(payment.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	refunds := []types.Payment{}
+	for _, line := range payments {
+		if line.ParentID == payment.ID && line.Category == types.PaymentCategoryRefund {
+			refunds = append(refunds, line)
+		}
+	}
+	return refunds, nil
+}
@@ -0,0 +1,184 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestService_Refund_partial(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	refund, err := s.Refund(payment.ID, 30_00)
+	if err != nil {
+		t.Errorf("Refund(): error = %v", err)
+		return
+	}
+	if refund.Amount != -30_00 || refund.ParentID != payment.ID || refund.Category != types.PaymentCategoryRefund {
+		t.Errorf("Refund(): wrong refund line, refund = %v", refund)
+		return
+	}
+
+	_, err = s.Refund(payment.ID, 80_00)
+	if !errors.Is(err, ErrRefundExceedsPayment) {
+		t.Errorf("Refund(): must return ErrRefundExceedsPayment, returned %v", err)
+		return
+	}
+	_, err = s.Refund(refund.ID, 1)
+	if !errors.Is(err, ErrRefundLine) {
+		t.Errorf("Refund(): must return ErrRefundLine for refund line, returned %v", err)
+		return
+	}
+	_, err = s.Refund(payment.ID, 20_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := s.FindPaymentByID(payment.ID)
+	if err != nil || got.Refunded != 50_00 {
+		t.Errorf("Refund(): refunded total not tracked, payment = %v, error = %v", got, err)
+		return
+	}
+	refunds, err := s.PaymentRefunds(payment.ID)
+	if err != nil || len(refunds) != 2 || refunds[0].ID != refund.ID {
+		t.Errorf("PaymentRefunds() = %v, error = %v", refunds, err)
+		return
+	}
+	history, err := s.ExportAccountHistory(account.ID)
+	if err != nil || len(history) != 3 {
+		t.Errorf("ExportAccountHistory(): refunds missing, history = %v, error = %v", history, err)
+		return
+	}
+
+	// Reject возвращает только то, что ещё не вернули.
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	balance, err := s.FindAccountByID(account.ID)
+	if err != nil || balance.Balance != 1000_00 {
+		t.Errorf("Reject(): double refund, account = %v, error = %v", balance, err)
+		return
+	}
+	_, err = s.Refund(payment.ID, 1)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Refund(): rejected payment can't be refunded, returned %v", err)
+		return
+	}
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_Export_refunds(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992880806776", 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	refund, err := s.Refund(payment.ID, 40_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+
+	got, err := imported.FindPaymentByID(payment.ID)
+	if err != nil || got.Refunded != 40_00 {
+		t.Errorf("Import(): refunded total lost, payment = %v, error = %v", got, err)
+		return
+	}
+	line, err := imported.FindPaymentByID(refund.ID)
+	if err != nil || *line != *refund {
+		t.Errorf("Import(): refund line = %v, error = %v, want %v", line, err, refund)
+		return
+	}
+	_, err = imported.Refund(payment.ID, 60_01)
+	if !errors.Is(err, ErrRefundExceedsPayment) {
+		t.Errorf("Refund(): must return ErrRefundExceedsPayment after import, returned %v", err)
+	}
+}
+
+func TestOpen_replayRefund(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	account, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	refund, err := s.Refund(payment.ID, 25_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s, err = Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	got, err := s.FindPaymentByID(refund.ID)
+	if err != nil || *got != *refund {
+		t.Errorf("refund after replay = %v, error = %v, want %v", got, err, refund)
+		return
+	}
+	balance, err := s.FindAccountByID(account.ID)
+	if err != nil || balance.Balance != 925_00 {
+		t.Errorf("balance after replay = %v, error = %v", balance, err)
+	}
+}
@@ -0,0 +1,607 @@
+package wallet
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+// Repository - хранилище счетов, платежей, избранного, переводов, ключей
+// идемпотентности, аудита уровней идентификации и главной книги, от которого
+// зависит Service. Методы поиска возвращают копии: чтобы изменить сущность,
+// её нужно сохранить через Save*. Save* добавляет новую сущность или
+// заменяет существующую с тем же ID, Delete* удаляет её (сервис удаляет
+// только то, что создал откатываемый импорт).
+type Repository interface {
+	Accounts() ([]types.Account, error)
+	AccountByID(accountID int64) (*types.Account, error)
+	AccountByPhone(phone types.Phone) (*types.Account, error)
+	SaveAccount(account *types.Account) error
+	DeleteAccount(accountID int64) error
+
+	Payments() ([]types.Payment, error)
+	PaymentByID(paymentID string) (*types.Payment, error)
+	PaymentsByAccount(accountID int64) ([]types.Payment, error)
+	SavePayment(payment *types.Payment) error
+	DeletePayment(paymentID string) error
+
+	Favorites() ([]types.Favorite, error)
+	FavoriteByID(favoriteID string) (*types.Favorite, error)
+	SaveFavorite(favorite *types.Favorite) error
+	DeleteFavorite(favoriteID string) error
+
+	Transfers() ([]types.Transfer, error)
+	TransferByID(transferID string) (*types.Transfer, error)
+	TransfersByAccount(accountID int64) ([]types.Transfer, error)
+	SaveTransfer(transfer *types.Transfer) error
+	DeleteTransfer(transferID string) error
+
+	IdempotencyKeys() ([]types.IdempotencyKey, error)
+	IdempotencyKey(key string) (*types.IdempotencyKey, error)
+	SaveIdempotencyKey(key *types.IdempotencyKey) error
+	DeleteIdempotencyKey(key string) error
+	// PruneIdempotencyKeys удаляет ключи, созданные не позже cutoff.
+	PruneIdempotencyKeys(cutoff time.Time) error
+
+	TierChanges() ([]types.TierChange, error)
+	TierChangeByID(changeID string) (*types.TierChange, error)
+	TierChangesByAccount(accountID int64) ([]types.TierChange, error)
+	SaveTierChange(change *types.TierChange) error
+	DeleteTierChange(changeID string) error
+
+	// Записи книги не меняются: LedgerEntries отдаёт их в порядке проведения.
+	LedgerEntries() ([]types.LedgerEntry, error)
+	LedgerEntryByID(entryID string) (*types.LedgerEntry, error)
+	SaveLedgerEntry(entry *types.LedgerEntry) error
+	DeleteLedgerEntry(entryID string) error
+}
+
+// MemoryRepository хранит всё в памяти. Слайсы держат порядок добавления
+// (он нужен для Export и параллельных обходов), а поиск по ID, телефону
+// и счёту идёт через карты за O(1).
+type MemoryRepository struct {
+	mu                   sync.RWMutex
+	accounts             []*types.Account
+	payments             []*types.Payment
+	favorites            []*types.Favorite
+	transfers            []*types.Transfer
+	keys                 []*types.IdempotencyKey
+	tierChanges          []*types.TierChange
+	ledger               []*types.LedgerEntry
+	accountsByID         map[int64]*types.Account
+	accountsByPhone      map[types.Phone]*types.Account
+	paymentsByID         map[string]*types.Payment
+	paymentsByAccount    map[int64][]*types.Payment
+	favoritesByID        map[string]*types.Favorite
+	transfersByID        map[string]*types.Transfer
+	transfersByAccount   map[int64][]*types.Transfer
+	keysByKey            map[string]*types.IdempotencyKey
+	tierChangesByID      map[string]*types.TierChange
+	tierChangesByAccount map[int64][]*types.TierChange
+	ledgerByID           map[string]*types.LedgerEntry
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		accountsByID:         map[int64]*types.Account{},
+		accountsByPhone:      map[types.Phone]*types.Account{},
+		paymentsByID:         map[string]*types.Payment{},
+		paymentsByAccount:    map[int64][]*types.Payment{},
+		favoritesByID:        map[string]*types.Favorite{},
+		transfersByID:        map[string]*types.Transfer{},
+		transfersByAccount:   map[int64][]*types.Transfer{},
+		keysByKey:            map[string]*types.IdempotencyKey{},
+		tierChangesByID:      map[string]*types.TierChange{},
+		tierChangesByAccount: map[int64][]*types.TierChange{},
+		ledgerByID:           map[string]*types.LedgerEntry{},
+	}
+}
+
+func (r *MemoryRepository) Accounts() ([]types.Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	accounts := make([]types.Account, 0, len(r.accounts))
+	for _, account := range r.accounts {
+		accounts = append(accounts, *account)
+	}
+	return accounts, nil
+}
+
+func (r *MemoryRepository) AccountByID(accountID int64) (*types.Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	account, ok := r.accountsByID[accountID]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	clone := *account
+	return &clone, nil
+}
+
+func (r *MemoryRepository) AccountByPhone(phone types.Phone) (*types.Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	account, ok := r.accountsByPhone[phone]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	clone := *account
+	return &clone, nil
+}
+
+func (r *MemoryRepository) SaveAccount(account *types.Account) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.accountsByID[account.ID]
+	if !ok {
+		saved = &types.Account{}
+		r.accounts = append(r.accounts, saved)
+		r.accountsByID[account.ID] = saved
+	} else if r.accountsByPhone[saved.Phone] == saved {
+		delete(r.accountsByPhone, saved.Phone)
+	}
+
+	*saved = *account
+	r.accountsByPhone[saved.Phone] = saved
+	return nil
+}
+
+func (r *MemoryRepository) DeleteAccount(accountID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.accountsByID[accountID]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	delete(r.accountsByID, accountID)
+	if r.accountsByPhone[saved.Phone] == saved {
+		delete(r.accountsByPhone, saved.Phone)
+	}
+	for i, account := range r.accounts {
+		if account == saved {
+			r.accounts = append(r.accounts[:i:i], r.accounts[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) Payments() ([]types.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	payments := make([]types.Payment, 0, len(r.payments))
+	for _, payment := range r.payments {
+		payments = append(payments, *payment)
+	}
+	return payments, nil
+}
+
+func (r *MemoryRepository) PaymentByID(paymentID string) (*types.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	payment, ok := r.paymentsByID[paymentID]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	clone := *payment
+	return &clone, nil
+}
+
+func (r *MemoryRepository) PaymentsByAccount(accountID int64) ([]types.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	payments := make([]types.Payment, 0, len(r.paymentsByAccount[accountID]))
+	for _, payment := range r.paymentsByAccount[accountID] {
+		payments = append(payments, *payment)
+	}
+	return payments, nil
+}
+
+func (r *MemoryRepository) SavePayment(payment *types.Payment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.paymentsByID[payment.ID]
+	reindex := !ok
+	if !ok {
+		saved = &types.Payment{}
+		r.payments = append(r.payments, saved)
+		r.paymentsByID[payment.ID] = saved
+	} else if saved.AccountID != payment.AccountID {
+		r.paymentsByAccount[saved.AccountID] = removePayment(r.paymentsByAccount[saved.AccountID], saved)
+		reindex = true
+	}
+
+	*saved = *payment
+	if reindex {
+		r.paymentsByAccount[saved.AccountID] = append(r.paymentsByAccount[saved.AccountID], saved)
+	}
+	return nil
+}
+
+func (r *MemoryRepository) DeletePayment(paymentID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.paymentsByID[paymentID]
+	if !ok {
+		return ErrPaymentNotFound
+	}
+	delete(r.paymentsByID, paymentID)
+	r.payments = removePayment(r.payments, saved)
+	r.paymentsByAccount[saved.AccountID] = removePayment(r.paymentsByAccount[saved.AccountID], saved)
+	return nil
+}
+
+func (r *MemoryRepository) Favorites() ([]types.Favorite, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	favorites := make([]types.Favorite, 0, len(r.favorites))
+	for _, favorite := range r.favorites {
+		favorites = append(favorites, *favorite)
+	}
+	return favorites, nil
+}
+
+func (r *MemoryRepository) FavoriteByID(favoriteID string) (*types.Favorite, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	favorite, ok := r.favoritesByID[favoriteID]
+	if !ok {
+		return nil, ErrFavoriteNotFound
+	}
+	clone := *favorite
+	return &clone, nil
+}
+
+func (r *MemoryRepository) SaveFavorite(favorite *types.Favorite) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.favoritesByID[favorite.ID]
+	if !ok {
+		saved = &types.Favorite{}
+		r.favorites = append(r.favorites, saved)
+		r.favoritesByID[favorite.ID] = saved
+	}
+
+	*saved = *favorite
+	return nil
+}
+
+func (r *MemoryRepository) DeleteFavorite(favoriteID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.favoritesByID[favoriteID]
+	if !ok {
+		return ErrFavoriteNotFound
+	}
+	delete(r.favoritesByID, favoriteID)
+	for i, favorite := range r.favorites {
+		if favorite == saved {
+			r.favorites = append(r.favorites[:i:i], r.favorites[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) Transfers() ([]types.Transfer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transfers := make([]types.Transfer, 0, len(r.transfers))
+	for _, transfer := range r.transfers {
+		transfers = append(transfers, *transfer)
+	}
+	return transfers, nil
+}
+
+func (r *MemoryRepository) TransferByID(transferID string) (*types.Transfer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transfer, ok := r.transfersByID[transferID]
+	if !ok {
+		return nil, ErrTransferNotFound
+	}
+	clone := *transfer
+	return &clone, nil
+}
+
+func (r *MemoryRepository) TransfersByAccount(accountID int64) ([]types.Transfer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transfers := make([]types.Transfer, 0, len(r.transfersByAccount[accountID]))
+	for _, transfer := range r.transfersByAccount[accountID] {
+		transfers = append(transfers, *transfer)
+	}
+	return transfers, nil
+}
+
+func (r *MemoryRepository) SaveTransfer(transfer *types.Transfer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.transfersByID[transfer.ID]
+	reindex := !ok
+	if !ok {
+		saved = &types.Transfer{}
+		r.transfers = append(r.transfers, saved)
+		r.transfersByID[transfer.ID] = saved
+	} else if saved.FromAccountID != transfer.FromAccountID || saved.ToAccountID != transfer.ToAccountID {
+		r.transfersByAccount[saved.FromAccountID] = removeTransfer(r.transfersByAccount[saved.FromAccountID], saved)
+		r.transfersByAccount[saved.ToAccountID] = removeTransfer(r.transfersByAccount[saved.ToAccountID], saved)
+		reindex = true
+	}
+
+	*saved = *transfer
+	if reindex {
+		r.transfersByAccount[saved.FromAccountID] = append(r.transfersByAccount[saved.FromAccountID], saved)
+		r.transfersByAccount[saved.ToAccountID] = append(r.transfersByAccount[saved.ToAccountID], saved)
+	}
+	return nil
+}
+
+func (r *MemoryRepository) DeleteTransfer(transferID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.transfersByID[transferID]
+	if !ok {
+		return ErrTransferNotFound
+	}
+	delete(r.transfersByID, transferID)
+	r.transfers = removeTransfer(r.transfers, saved)
+	r.transfersByAccount[saved.FromAccountID] = removeTransfer(r.transfersByAccount[saved.FromAccountID], saved)
+	r.transfersByAccount[saved.ToAccountID] = removeTransfer(r.transfersByAccount[saved.ToAccountID], saved)
+	return nil
+}
+
+func (r *MemoryRepository) IdempotencyKeys() ([]types.IdempotencyKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]types.IdempotencyKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, *key)
+	}
+	return keys, nil
+}
+
+func (r *MemoryRepository) IdempotencyKey(key string) (*types.IdempotencyKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	saved, ok := r.keysByKey[key]
+	if !ok {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+	clone := *saved
+	return &clone, nil
+}
+
+func (r *MemoryRepository) SaveIdempotencyKey(key *types.IdempotencyKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.keysByKey[key.Key]
+	if !ok {
+		saved = &types.IdempotencyKey{}
+		r.keys = append(r.keys, saved)
+		r.keysByKey[key.Key] = saved
+	}
+
+	*saved = *key
+	return nil
+}
+
+func (r *MemoryRepository) DeleteIdempotencyKey(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.keysByKey[key]
+	if !ok {
+		return ErrIdempotencyKeyNotFound
+	}
+	delete(r.keysByKey, key)
+	for i, k := range r.keys {
+		if k == saved {
+			r.keys = append(r.keys[:i:i], r.keys[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) PruneIdempotencyKeys(cutoff time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.keys[:0]
+	for _, key := range r.keys {
+		if key.CreatedAt.After(cutoff) {
+			kept = append(kept, key)
+		} else {
+			delete(r.keysByKey, key.Key)
+		}
+	}
+	for i := len(kept); i < len(r.keys); i++ {
+		r.keys[i] = nil
+	}
+	r.keys = kept
+	return nil
+}
+
+func (r *MemoryRepository) TierChanges() ([]types.TierChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	changes := make([]types.TierChange, 0, len(r.tierChanges))
+	for _, change := range r.tierChanges {
+		changes = append(changes, *change)
+	}
+	return changes, nil
+}
+
+func (r *MemoryRepository) TierChangeByID(changeID string) (*types.TierChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	saved, ok := r.tierChangesByID[changeID]
+	if !ok {
+		return nil, ErrTierChangeNotFound
+	}
+	clone := *saved
+	return &clone, nil
+}
+
+func (r *MemoryRepository) TierChangesByAccount(accountID int64) ([]types.TierChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	changes := make([]types.TierChange, 0, len(r.tierChangesByAccount[accountID]))
+	for _, change := range r.tierChangesByAccount[accountID] {
+		changes = append(changes, *change)
+	}
+	return changes, nil
+}
+
+func (r *MemoryRepository) SaveTierChange(change *types.TierChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.tierChangesByID[change.ID]
+	reindex := !ok
+	if !ok {
+		saved = &types.TierChange{}
+		r.tierChanges = append(r.tierChanges, saved)
+		r.tierChangesByID[change.ID] = saved
+	} else if saved.AccountID != change.AccountID {
+		r.tierChangesByAccount[saved.AccountID] = removeTierChange(r.tierChangesByAccount[saved.AccountID], saved)
+		reindex = true
+	}
+
+	*saved = *change
+	if reindex {
+		r.tierChangesByAccount[saved.AccountID] = append(r.tierChangesByAccount[saved.AccountID], saved)
+	}
+	return nil
+}
+
+func (r *MemoryRepository) DeleteTierChange(changeID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.tierChangesByID[changeID]
+	if !ok {
+		return ErrTierChangeNotFound
+	}
+	delete(r.tierChangesByID, changeID)
+	r.tierChanges = removeTierChange(r.tierChanges, saved)
+	r.tierChangesByAccount[saved.AccountID] = removeTierChange(r.tierChangesByAccount[saved.AccountID], saved)
+	return nil
+}
+
+func (r *MemoryRepository) LedgerEntries() ([]types.LedgerEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make([]types.LedgerEntry, 0, len(r.ledger))
+	for _, entry := range r.ledger {
+		entries = append(entries, cloneLedgerEntry(entry))
+	}
+	return entries, nil
+}
+
+func (r *MemoryRepository) LedgerEntryByID(entryID string) (*types.LedgerEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	saved, ok := r.ledgerByID[entryID]
+	if !ok {
+		return nil, ErrLedgerEntryNotFound
+	}
+	clone := cloneLedgerEntry(saved)
+	return &clone, nil
+}
+
+func (r *MemoryRepository) SaveLedgerEntry(entry *types.LedgerEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.ledgerByID[entry.ID]
+	if !ok {
+		saved = &types.LedgerEntry{}
+		r.ledger = append(r.ledger, saved)
+		r.ledgerByID[entry.ID] = saved
+	}
+	*saved = cloneLedgerEntry(entry)
+	return nil
+}
+
+func (r *MemoryRepository) DeleteLedgerEntry(entryID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.ledgerByID[entryID]
+	if !ok {
+		return ErrLedgerEntryNotFound
+	}
+	delete(r.ledgerByID, entryID)
+	for i, entry := range r.ledger {
+		if entry == saved {
+			r.ledger = append(r.ledger[:i:i], r.ledger[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// cloneLedgerEntry копирует запись вместе с проводками: слайс Postings
+// иначе остался бы общим с хранилищем.
+func cloneLedgerEntry(entry *types.LedgerEntry) types.LedgerEntry {
+	clone := *entry
+	clone.Postings = append([]types.Posting(nil), entry.Postings...)
+	return clone
+}
+
+func removePayment(payments []*types.Payment, payment *types.Payment) []*types.Payment {
+	for i, p := range payments {
+		if p == payment {
+			return append(payments[:i:i], payments[i+1:]...)
+		}
+	}
+	return payments
+}
+
+func removeTransfer(transfers []*types.Transfer, transfer *types.Transfer) []*types.Transfer {
+	for i, t := range transfers {
+		if t == transfer {
+			return append(transfers[:i:i], transfers[i+1:]...)
+		}
+	}
+	return transfers
+}
+
+func removeTierChange(changes []*types.TierChange, change *types.TierChange) []*types.TierChange {
+	for i, c := range changes {
+		if c == change {
+			return append(changes[:i:i], changes[i+1:]...)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,80 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+func TestMemoryRepository_SavePayment_reindex(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	payment := &types.Payment{ID: "1", AccountID: 1, Amount: 100, Status: types.PaymentStatusInProgress}
+	err := repo.SavePayment(payment)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment.AccountID = 2
+	err = repo.SavePayment(payment)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	first, _ := repo.PaymentsByAccount(1)
+	second, _ := repo.PaymentsByAccount(2)
+	if len(first) != 0 || len(second) != 1 {
+		t.Errorf("SavePayment(): index not updated, first = %v, second = %v", first, second)
+	}
+}
+
+func TestMemoryRepository_SaveAccount_phone(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	account := &types.Account{ID: 1, Phone: "+992880806776"}
+	err := repo.SaveAccount(account)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	account.Phone = "+992935444994"
+	err = repo.SaveAccount(account)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = repo.AccountByPhone("+992880806776")
+	if err != ErrAccountNotFound {
+		t.Errorf("AccountByPhone(): old phone must be released, error = %v", err)
+	}
+	got, err := repo.AccountByPhone("+992935444994")
+	if err != nil || got.ID != 1 {
+		t.Errorf("AccountByPhone(): account = %v, error = %v", got, err)
+	}
+}
+
+func TestMemoryRepository_AccountByID_copy(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	err := repo.SaveAccount(&types.Account{ID: 1, Phone: "+992880806776", Balance: 100})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	account, err := repo.AccountByID(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	account.Balance = 0
+
+	saved, _ := repo.AccountByID(1)
+	if saved.Balance != 100 {
+		t.Errorf("AccountByID(): must return copy, saved = %v", saved)
+	}
+}
@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
+	"math/big"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gholib/wallet/pkg/types"
 	"github.com/google/uuid"
@@ -29,36 +33,144 @@ var ErrNotEnoughBalance = errors.New("not enough balance")
 var ErrPaymentNotFound = errors.New("payment not found")
 var ErrFavoriteNotFound = errors.New("favorite not found")
 var ErrFileNotFound = errors.New("File Not found")
+var ErrTransferNotFound = errors.New("transfer not found")
+var ErrTransferToSameAccount = errors.New("can't transfer to the same account")
+var ErrTransferAlreadyRejected = errors.New("transfer already rejected")
 var Err = errors.New("gavno")
 
 //Service -
+// Все публичные методы безопасны для конкурентного вызова: изменяющие операции
+// берут мьютекс на запись, чтение и обходы - на чтение. Найденные сущности
+// возвращаются копиями, чтобы вызывающий код не читал данные в обход блокировки.
+// Нулевое значение Service работает поверх MemoryRepository.
 type Service struct {
+	mu            sync.RWMutex
+	once          sync.Once
+	repo          Repository
 	nextAccountID int64
-	accounts      []*types.Account
-	payments      []*types.Payment
-	favorites     []*types.Favorite
+	ledger        []*types.LedgerEntry
+	clock         func() time.Time
+	opTime        time.Time
+	wal           *wal
+	walDir        string
+	rates         RateProvider
+	conversion    ConversionOptions
+	fees          FeeSchedule
+	limits        Limits
+	tierRules     TierRules
+	holdTTL       time.Duration
+	keyRetention  time.Duration
+	keysPrunedAt  time.Time
+}
+
+// NewService создаёт сервис поверх хранилища repo. Главная книга хранится
+// в repo; если её там нет (хранилище заведено до появления книги), для
+// счетов с ненулевым балансом в неё один раз заносятся открывающие записи.
+func NewService(repo Repository) (*Service, error) {
+	s := &Service{repo: repo}
+
+	accounts, err := repo.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := repo.LedgerEntries()
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		if account.ID > s.nextAccountID {
+			s.nextAccountID = account.ID
+		}
+		if len(entries) == 0 && account.Balance != 0 {
+			_, err = s.appendEntry(EntryOpening, strconv.FormatInt(account.ID, 10),
+				types.Posting{Account: customerLedgerAccount(account.ID), Amount: account.Balance},
+				types.Posting{Account: LedgerOpening, Amount: -account.Balance},
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Service) repository() Repository {
+	s.once.Do(func() {
+		if s.repo == nil {
+			s.repo = NewMemoryRepository()
+		}
+	})
+	return s.repo
+}
+
+// SetClock подменяет часы сервиса, по ним проставляются даты создания и изменения.
+func (s *Service) SetClock(clock func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clock = clock
+}
+
+func (s *Service) now() time.Time {
+	if !s.opTime.IsZero() {
+		return s.opTime
+	}
+	if s.clock == nil {
+		return time.Now().UTC()
+	}
+	return s.clock()
 }
 
 //RegisterAccount создаем тут ак
 func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	for _, account := range s.accounts {
-		if account.Phone == phone {
-			return nil, ErrPhoneNumberRegistred
-		}
+	err := s.begin(&walRecord{Op: opRegisterAccount, Phone: phone})
+	defer s.end()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.registerAccount(phone, types.DefaultCurrency)
+}
+
+func (s *Service) registerAccount(phone types.Phone, currency types.Currency) (*types.Account, error) {
+	return s.createAccount(s.nextAccountID+1, phone, currency)
+}
+
+// createAccount заводит счёт с заданным ID. Импорт сохраняет ID из выгрузки,
+// поэтому nextAccountID поднимается до самого большого занятого ID.
+func (s *Service) createAccount(id int64, phone types.Phone, currency types.Currency) (*types.Account, error) {
+	if !currency.Known() {
+		return nil, ErrUnknownCurrency
+	}
+	_, err := s.repository().AccountByPhone(phone)
+	if err == nil {
+		return nil, ErrPhoneNumberRegistred
 	}
 
-	s.nextAccountID++
+	now := s.now()
 	account := &types.Account{
-		ID:      s.nextAccountID,
-		Phone:   phone,
-		Balance: 0,
+		ID:        id,
+		Phone:     phone,
+		Balance:   0,
+		Currency:  currency,
+		Tier:      types.KYCAnonymous,
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
 
-	s.accounts = append(s.accounts, account)
+	err = s.repository().SaveAccount(account)
+	if err != nil {
+		return nil, err
+	}
+	if id > s.nextAccountID {
+		s.nextAccountID = id
+	}
 
 	return account, nil
-
 }
 
 // так, он находит по ID
@@ -72,131 +184,317 @@ func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
 //
 
 func (s *Service) Deposit(accountID int64, amount types.Money) error {
+	return s.DepositWithKey("", accountID, amount)
+}
+
+// deposit зачисляет amount в валюте currency; пустая валюта - валюта счёта.
+// Сумма в чужой валюте зачисляется только с пересчётом conv.
+func (s *Service) deposit(accountID int64, amount types.Money, currency types.Currency, conv *conversion) error {
 	if amount <= 0 {
 		return ErrAmountMustBePositive
 
 	}
 
-	var account *types.Account
-
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-		}
-
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
 	}
-	if account == nil {
-		return ErrAccountNotFound
+	if conv != nil && currency != accountCurrency(account) {
+		amount = conv.amount
+		if amount <= 0 {
+			return ErrAmountMustBePositive
+		}
+	} else {
+		err = checkCurrency(account, currency)
+		if err != nil {
+			return err
+		}
 	}
-	account.Balance += amount
 
-	return nil
+	_, err = s.post(EntryDeposit, strconv.FormatInt(accountID, 10),
+		types.Posting{Account: customerLedgerAccount(accountID), Amount: amount},
+		types.Posting{Account: LedgerDeposits, Amount: -amount},
+	)
+	return err
 }
 
 func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	return s.PayWithKey("", accountID, amount, category)
+}
+
+// pay списывает amount в валюте currency; пустая валюта - валюта счёта.
+// Платёж в чужой валюте проходит только с пересчётом conv. Комиссия fee
+// списывается отдельной строкой, и на неё тоже должно хватать баланса.
+func (s *Service) pay(paymentID string, accountID int64, amount types.Money, currency types.Currency, conv *conversion, fee types.Money, category types.PaymentCategory) (*types.Payment, error) {
 	if amount <= 0 {
 		return nil, ErrAmountMustBePositive
 	}
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-			break
+	_, err := s.expireHolds(accountID)
+	if err != nil {
+		return nil, err
+	}
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	original := types.Money(0)
+	if conv != nil && currency != accountCurrency(account) {
+		original, amount = amount, conv.amount
+		if amount <= 0 {
+			return nil, ErrAmountMustBePositive
+		}
+	} else {
+		err = checkCurrency(account, currency)
+		if err != nil {
+			return nil, err
 		}
-
 	}
 
-	if account == nil {
-		return nil, ErrAccountNotFound
+	total, err := amount.Add(fee)
+	if err != nil {
+		return nil, err
 	}
-
-	if account.Balance < amount {
+	if account.Available() < total {
 		return nil, ErrNotEnoughBalance
 
 	}
-	account.Balance -= amount
+	entry, err := s.post(EntryPayment, paymentID,
+		types.Posting{Account: customerLedgerAccount(accountID), Amount: -amount},
+		types.Posting{Account: LedgerMerchantClearing, Amount: amount},
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	paymentID := uuid.New().String()
+	now := entry.CreatedAt
 	payment := &types.Payment{
 		ID:        paymentID,
 		AccountID: accountID,
 		Amount:    amount,
+		Currency:  accountCurrency(account),
 		Category:  category,
 		Status:    types.PaymentStatusInProgress,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if original != 0 {
+		payment.OriginalAmount = original
+		payment.OriginalCurrency = currency
+		payment.Rate = conv.rate
+	}
+	if fee > 0 {
+		payment.Fee = fee
+		payment.FeeID = feeLineID(paymentID)
 	}
 
-	s.payments = append(s.payments, payment)
+	err = s.repository().SavePayment(payment)
+	if err != nil {
+		return nil, err
+	}
+	if fee > 0 {
+		_, err = s.chargeFee(payment, fee)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return payment, nil
 
 }
 
-func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-		}
+// Transfer переводит деньги с одного счёта на другой одной операцией
+func (s *Service) Transfer(fromID, toID int64, amount types.Money) (*types.Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkTier(fromID, OperationTransfer, amount, false)
+	if err == nil {
+		err = s.checkTier(toID, OperationTransfer, amount, true)
 	}
-	if account == nil {
-		return nil, ErrAccountNotFound
+	if err != nil {
+		return nil, err
+	}
+	transferID := uuid.New().String()
+	err = s.begin(&walRecord{Op: opTransfer, ID: transferID, AccountID: fromID, ToID: toID, Amount: amount})
+	defer s.end()
+	if err != nil {
+		return nil, err
 	}
-	return account, nil
 
+	return s.makeTransfer(transferID, fromID, toID, amount)
 }
 
-func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
-	var payment *types.Payment
-	for _, pay := range s.payments {
-		if pay.ID == paymentID {
-			payment = pay
-			break
-		}
+func (s *Service) makeTransfer(transferID string, fromID, toID int64, amount types.Money) (*types.Transfer, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
 	}
-	if payment == nil {
-		return nil, ErrPaymentNotFound
+	if fromID == toID {
+		return nil, ErrTransferToSameAccount
 	}
-	return payment, nil
+
+	_, err := s.expireHolds(fromID)
+	if err != nil {
+		return nil, err
+	}
+	from, err := s.findAccountByID(fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.findAccountByID(toID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkCurrency(to, accountCurrency(from))
+	if err != nil {
+		return nil, err
+	}
+
+	if from.Available() < amount {
+		return nil, ErrNotEnoughBalance
+	}
+
+	entry, err := s.post(EntryTransfer, transferID,
+		types.Posting{Account: customerLedgerAccount(fromID), Amount: -amount},
+		types.Posting{Account: customerLedgerAccount(toID), Amount: amount},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	now := entry.CreatedAt
+	transfer := &types.Transfer{
+		ID:            transferID,
+		FromAccountID: fromID,
+		ToAccountID:   toID,
+		Amount:        amount,
+		Status:        types.PaymentStatusInProgress,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	err = s.repository().SaveTransfer(transfer)
+	if err != nil {
+		return nil, err
+	}
+	return transfer, nil
 }
 
-func (s *Service) Reject(paymentID string) error {
-	var targetPayment *types.Payment
+func (s *Service) FindTransferByID(transferID string) (*types.Transfer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	for _, payment := range s.payments {
-		if payment.ID == paymentID {
-			targetPayment = payment
-			break
-		}
+	return s.findTransferByID(transferID)
+}
+
+func (s *Service) findTransferByID(transferID string) (*types.Transfer, error) {
+	return s.repository().TransferByID(transferID)
+}
+
+// RejectTransfer отменяет перевод целиком: деньги возвращаются отправителю
+func (s *Service) RejectTransfer(transferID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.begin(&walRecord{Op: opRejectTransfer, Ref: transferID})
+	defer s.end()
+	if err != nil {
+		return err
+	}
+
+	return s.rejectTransfer(transferID)
+}
 
+func (s *Service) rejectTransfer(transferID string) error {
+	transfer, err := s.findTransferByID(transferID)
+	if err != nil {
+		return err
 	}
-	if targetPayment == nil {
-		return ErrPaymentNotFound
+	if transfer.Status == types.PaymentStatusFail {
+		return ErrTransferAlreadyRejected
 	}
-	var targetAccount *types.Account
 
-	for _, account := range s.accounts {
+	_, err = s.expireHolds(transfer.ToAccountID)
+	if err != nil {
+		return err
+	}
+	from, err := s.findAccountByID(transfer.FromAccountID)
+	if err != nil {
+		return err
+	}
+	to, err := s.findAccountByID(transfer.ToAccountID)
+	if err != nil {
+		return err
+	}
 
-		if account.ID == targetPayment.AccountID {
-			targetAccount = account
-			break
-		}
+	if to.Available() < transfer.Amount {
+		return ErrNotEnoughBalance
 	}
-	if targetAccount == nil {
-		return ErrAccountNotFound
+	entry, err := s.post(EntryTransferRevert, transfer.ID,
+		types.Posting{Account: customerLedgerAccount(to.ID), Amount: -transfer.Amount},
+		types.Posting{Account: customerLedgerAccount(from.ID), Amount: transfer.Amount},
+	)
+	if err != nil {
+		return err
 	}
-	targetPayment.Status = types.PaymentStatusFail
-	targetAccount.Balance += targetPayment.Amount
 
-	return nil
+	transfer.Status = types.PaymentStatusFail
+	transfer.UpdatedAt = entry.CreatedAt
+
+	return s.repository().SaveTransfer(transfer)
+}
+
+func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.findAccountByID(accountID)
+}
+
+func (s *Service) findAccountByID(accountID int64) (*types.Account, error) {
+	return s.repository().AccountByID(accountID)
+
+}
+
+func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
+	return s.findPaymentByID(paymentID)
+}
+
+func (s *Service) findPaymentByID(paymentID string) (*types.Payment, error) {
+	return s.repository().PaymentByID(paymentID)
+}
+
+func (s *Service) Reject(paymentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.begin(&walRecord{Op: opReject, Ref: paymentID})
+	defer s.end()
+	if err != nil {
+		return err
+	}
+
+	return s.closePayment(paymentID, types.PaymentStatusFail)
 }
 
 func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
-	pay, err := s.FindPaymentByID(paymentID)
+	return s.RepeatWithKey("", paymentID)
+}
+
+// repeat повторяет платёж в валюте счёта; комиссия - по текущему расписанию.
+// Строки комиссии и возврата повторить нельзя.
+func (s *Service) repeat(newPaymentID string, paymentID string, fee types.Money) (*types.Payment, error) {
+	pay, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPrimary(pay)
 	if err != nil {
 		return nil, err
 	}
 
-	payment, err := s.Pay(pay.AccountID, pay.Amount, pay.Category)
+	payment, err := s.pay(newPaymentID, pay.AccountID, pay.Amount, pay.Currency, nil, fee, pay.Category)
 	if err != nil {
 		return nil, err
 	}
@@ -206,42 +504,96 @@ func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
 
 // он создает FavoritePayment
 func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
-	payment, err := s.FindPaymentByID(paymentID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	favoriteID := uuid.New().String()
+	err := s.begin(&walRecord{Op: opFavoritePayment, ID: favoriteID, Ref: paymentID, Name: name})
+	defer s.end()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.favoritePayment(favoriteID, paymentID, name)
+}
+
+func (s *Service) favoritePayment(favoriteID string, paymentID string, name string) (*types.Favorite, error) {
+	payment, err := s.findPaymentByID(paymentID)
+
+	if err != nil {
+		return nil, err
+	}
+	err = checkPrimary(payment)
 	if err != nil {
 		return nil, err
 	}
 
-	favoriteID := uuid.New().String()
 	newFavorite := &types.Favorite{
 		ID:        favoriteID,
 		AccountID: payment.AccountID,
 		Name:      name,
 		Amount:    payment.Amount,
+		Currency:  payment.Currency,
 		Category:  payment.Category,
+		CreatedAt: s.now(),
 	}
 
-	s.favorites = append(s.favorites, newFavorite)
+	err = s.repository().SaveFavorite(newFavorite)
+	if err != nil {
+		return nil, err
+	}
 	return newFavorite, nil
 }
 
 func (s *Service) FindFavoriteByID(favoriteID string) (*types.Favorite, error) {
-	for _, favorite := range s.favorites {
-		if favorite.ID == favoriteID {
-			return favorite, nil
-		}
-	}
-	return nil, ErrFavoriteNotFound
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.findFavoriteByID(favoriteID)
+}
+
+func (s *Service) findFavoriteByID(favoriteID string) (*types.Favorite, error) {
+	return s.repository().FavoriteByID(favoriteID)
 }
 
 //PayFromFavorite для совершения платежа в Избранное
 func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
-	favorite, err := s.FindFavoriteByID(favoriteID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	favorite, err := s.findFavoriteByID(favoriteID)
 	if err != nil {
 		return nil, err
 	}
+	err = s.checkLimits(favorite.AccountID, favorite.Amount, favorite.Category)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := s.feeFor(favorite.Category, favorite.Amount)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkPaymentTier(favorite.AccountID, favorite.Amount, fee)
+	if err != nil {
+		return nil, err
+	}
+	paymentID := uuid.New().String()
+	err = s.begin(&walRecord{Op: opPayFromFavorite, ID: paymentID, Ref: favoriteID, Fee: fee})
+	defer s.end()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.payFromFavorite(paymentID, favoriteID, fee)
+}
 
-	payment, err := s.Pay(favorite.AccountID, favorite.Amount, favorite.Category)
+func (s *Service) payFromFavorite(paymentID string, favoriteID string, fee types.Money) (*types.Payment, error) {
+	favorite, err := s.findFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := s.pay(paymentID, favorite.AccountID, favorite.Amount, favorite.Currency, nil, fee, favorite.Category)
 	if err != nil {
 		return nil, err
 	}
@@ -251,6 +603,9 @@ func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
 
 //ExportToFile - для импорта данных
 func (s *Service) ExportToFile(path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	file, err := os.Create(path)
 	if err != nil {
 		log.Print(err)
@@ -263,11 +618,17 @@ func (s *Service) ExportToFile(path string) error {
 		}
 
 	}()
+	accounts, err := s.repository().Accounts()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+
 	str := ""
-	for _, account := range s.accounts {
+	for _, account := range accounts {
 		str += strconv.Itoa(int(account.ID)) + ";"
 		str += string(account.Phone) + ";"
-		str += strconv.Itoa(int(account.Balance)) + "|"
+		str += account.Balance.FormatMinor() + "|"
 	}
 
 	_, err = file.Write([]byte(str))
@@ -280,6 +641,8 @@ func (s *Service) ExportToFile(path string) error {
 }
 
 func (s *Service) ImportFromFile(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	byteData, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -287,45 +650,63 @@ func (s *Service) ImportFromFile(path string) error {
 		return err
 	}
 
-	// обязательно ли преобразовать слайс байтов преобразовывать в стринг?
-	data := string(byteData)
-	fmt.Println(data)
+	splitSlice := strings.Split(string(byteData), "|")
+	// Счета заводятся так же, как при Import: с проверкой телефона и с
+	// продолжением нумерации; при ошибке не загружается ничего.
+	err = s.staged(func(staging *Service) error {
+		imported := []int64{}
+		for _, split := range splitSlice {
+			if split != "" {
+				datas := strings.Split(split, ";")
+				if len(datas) < 3 {
+					return ErrBadRecord
+				}
 
-	splitSlice := strings.Split(data, "|")
-	// здесь он должен показать  весь список, тажкже убрать |
-	fmt.Println(splitSlice)
-	// тут он покажет 2 номер
-	fmt.Println(splitSlice[1])
-	// тут 1
-	fmt.Println(splitSlice[0])
-	//splitSlice = strings.Split(data, ";")
-	for _, split := range splitSlice {
-		if split != "" {
-			datas := strings.Split(split, ";")
+				id, err := strconv.Atoi(datas[0])
+				if err != nil {
+					log.Println(err)
+					return err
+				}
+				balance, err := types.ParseMinor(datas[2])
+				if err != nil {
+					log.Println(err)
+					return err
+				}
+				if balance < 0 {
+					return fmt.Errorf("%w: account %d", ErrNegativeBalance, id)
+				}
 
-			id, err := strconv.Atoi(datas[0])
-			if err != nil {
-				log.Println(err)
-				return err
-			}
-			fmt.Println(datas[0])
-			balance, err := strconv.Atoi(datas[2])
-			fmt.Println(datas[2])
-			if err != nil {
-				log.Println(err)
-				return err
-			}
+				record := &types.Account{
+					ID:       int64(id),
+					Phone:    types.Phone(datas[1]),
+					Balance:  balance,
+					Currency: types.DefaultCurrency,
+					Tier:     types.KYCAnonymous,
+				}
+				// В файле только телефон и баланс - остальное у имеющегося счёта не трогаем.
+				account, err := staging.findAccountByID(record.ID)
+				if err == nil {
+					record.Currency = account.Currency
+					record.Held = account.Held
+					record.Tier = account.Tier
+				}
 
-			newAccount := &types.Account{
-				ID:      int64(id),
-				Phone:   types.Phone(datas[1]),
-				Balance: types.Money(balance),
+				err = staging.importAccount(record)
+				if err != nil {
+					log.Println(err)
+					return err
+				}
+				imported = append(imported, record.ID)
 			}
-
-			s.accounts = append(s.accounts, newAccount)
 		}
+		return staging.reconcileLedger(imported, EntryImport)
+	})
+	if err != nil {
+		return err
+	}
+	if s.wal != nil {
+		return s.checkpoint()
 	}
-
 	return nil
 }
 
@@ -333,62 +714,31 @@ func (s *Service) ImportFromFile(path string) error {
 // если она пустая то дай ошибку
 // нужна отдельная функция для создания файлов, чтобы 3 раза не писать одно и тоже
 
+// Export выгружает данные в dir: dump-файлы пишутся атомарно (временный
+// файл + переименование), последним пишется манифест с контрольными суммами.
 func (s *Service) Export(dir string) error {
-	// внутри него данные
-	// будет тру
-	if s.accounts != nil {
-		acc := ""
-		for _, account := range s.accounts {
-			acc += strconv.Itoa(int(account.ID)) + ";"
-			acc += string(account.Phone) + ";"
-			acc += strconv.Itoa(int(account.Balance)) + ";"
-			acc += string('\n')
-		}
-		err := WriteToFile(dir+"/accounts.dump", acc)
-		if err != nil {
-			log.Print(err)
-			return err
-		}
-	}
-	if s.payments != nil {
-		pay := ""
-
-		for _, payment := range s.payments {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-			pay += payment.ID + ";"
-			pay += strconv.Itoa(int(payment.AccountID)) + ";"
-			pay += strconv.Itoa(int(payment.Amount)) + ";"
-			pay += string(payment.Category) + ";"
-			pay += string(payment.Status) + ";"
-			pay += "\n"
-		}
-		err := WriteToFile(dir+"/payments.dump", pay)
-		if err != nil {
-			log.Print(err)
-			return err
-		}
+	return s.export(dir)
+}
 
+func (s *Service) export(dir string) error {
+	// Ключи с вышедшим сроком уже ни от чего не защищают - их не выгружаем.
+	files, err := dumpFiles(s.repository(), func(key *types.IdempotencyKey) bool {
+		return !s.keyExpired(key)
+	})
+	if err != nil {
+		log.Print(err)
+		return err
 	}
-	if s.favorites != nil {
-		fav := ""
-		for _, favorite := range s.favorites {
-			fav += favorite.ID + ";"
-			fav += strconv.Itoa(int(favorite.AccountID)) + ";"
-			fav += favorite.Name + ";"
-			fav += strconv.Itoa(int(favorite.Amount)) + ";"
-			fav += string(favorite.Category) + ";"
-			fav += "\n"
-		}
-		err := WriteToFile(dir+"/favorites.dump", fav)
-		if err != nil {
-			log.Print(err)
-			return err
-		}
 
+	err = writeExport(dir, files)
+	if err != nil {
+		log.Print(err)
+		return err
 	}
-
 	return nil
-
 }
 
 func WriteToFile(path string, data string) error {
@@ -413,206 +763,239 @@ func WriteToFile(path string, data string) error {
 	}
 	return nil
 }
+
+// Import не пишется в журнал вызов за вызовом: если журнал включён,
+// после успешного импорта сразу делается Checkpoint.
 func (s *Service) Import(dir string) error {
-	err := s.actionByAccounts(dir + "/accounts.dump")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.importDir(dir)
 	if err != nil {
-		log.Println("err from actionByAccount")
 		return err
 	}
+	if s.wal != nil {
+		return s.checkpoint()
+	}
+	return nil
+}
+
+// importAccount применяет запись импорта: существующая запись с тем же ID
+// обновляется, новая добавляется с тем же ID, что в выгрузке. Баланс
+// ставится как есть - с книгой его потом сверяет reconcileLedger.
+func (s *Service) importAccount(record *types.Account) error {
+	owner, err := s.repository().AccountByPhone(record.Phone)
+	if err == nil && owner.ID != record.ID {
+		return fmt.Errorf("%w: %s, account %d", ErrDuplicatePhone, record.Phone, owner.ID)
+	}
 
-	err = s.actionByPayments(dir + "/payments.dump")
+	account, err := s.findAccountByID(record.ID)
 	if err != nil {
-		log.Println("err from actionByPayments")
-		return err
+		account, err = s.createAccount(record.ID, record.Phone, record.Currency)
+		if err != nil {
+			log.Println("err from register account")
+			return err
+		}
+	} else {
+		account.Phone = record.Phone
+		account.Currency = record.Currency
+		err = s.repository().SaveAccount(account)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
 	}
 
-	err = s.actionByFavorites(dir + "/favorites.dump")
+	account, err = s.findAccountByID(account.ID)
 	if err != nil {
-		log.Println("err from actionByFavorites")
 		return err
 	}
-
+	account.Balance = record.Balance
+	account.Held = record.Held
+	account.Tier = record.Tier
+	if !record.CreatedAt.IsZero() {
+		account.CreatedAt = record.CreatedAt
+	}
+	if !record.UpdatedAt.IsZero() {
+		account.UpdatedAt = record.UpdatedAt
+	}
+	err = s.repository().SaveAccount(account)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 	return nil
 }
 
-func (s *Service) actionByAccounts(path string) error {
-	byteData, err := ioutil.ReadFile(path)
-	if err == nil {
-		datas := string(byteData)
-		splits := strings.Split(datas, "\n")
-
-		for _, split := range splits {
-			if len(split) == 0 {
-				break
-			}
-
-			data := strings.Split(split, ";")
-
-			id, err := strconv.Atoi(data[0])
-			if err != nil {
-				log.Println("can't parse str to int")
-				return err
-			}
-
-			phone := types.Phone(data[1])
-
-			balance, err := strconv.Atoi(data[2])
-			if err != nil {
-				log.Println("can't parse str to int")
-				return err
-			}
-
-			account, err := s.FindAccountByID(int64(id))
-			if err != nil {
-				acc, err := s.RegisterAccount(phone)
-				if err != nil {
-					log.Println("err from register account")
-					return err
-				}
+// importPayment - то же для платежа. Статус существующего платежа
+// меняется только по таблице переходов.
+func (s *Service) importPayment(record *types.Payment) error {
+	payment, err := s.findPaymentByID(record.ID)
+	if err != nil {
+		payment = record
+	} else {
+		err = checkTransition(payment, record.Status)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
 
-				acc.Balance = types.Money(balance)
-			} else {
-				account.Phone = phone
-				account.Balance = types.Money(balance)
-			}
+		payment.AccountID = record.AccountID
+		payment.Amount = record.Amount
+		payment.Currency = record.Currency
+		payment.OriginalAmount = record.OriginalAmount
+		payment.OriginalCurrency = record.OriginalCurrency
+		payment.Rate = record.Rate
+		payment.Fee = record.Fee
+		payment.FeeID = record.FeeID
+		payment.ParentID = record.ParentID
+		payment.Refunded = record.Refunded
+		payment.Authorized = record.Authorized
+		payment.ExpiresAt = record.ExpiresAt
+		payment.Category = record.Category
+		payment.Status = record.Status
+		if !record.CreatedAt.IsZero() {
+			payment.CreatedAt = record.CreatedAt
+		}
+		if !record.UpdatedAt.IsZero() {
+			payment.UpdatedAt = record.UpdatedAt
 		}
-	} else {
-		log.Println(ErrFileNotFound.Error())
 	}
 
+	err = s.repository().SavePayment(payment)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 	return nil
 }
 
-func (s *Service) actionByPayments(path string) error {
-	byteData, err := ioutil.ReadFile(path)
-	if err == nil {
-		datas := string(byteData)
-		splits := strings.Split(datas, "\n")
-
-		for _, split := range splits {
-			if len(split) == 0 {
-				break
-			}
-
-			data := strings.Split(split, ";")
-			id := data[0]
-
-			accountID, err := strconv.Atoi(data[1])
-			if err != nil {
-				log.Println("can't parse str to int")
-				return err
-			}
-
-			amount, err := strconv.Atoi(data[2])
-			if err != nil {
-				log.Println("can't parse str to int")
-				return err
-			}
-
-			category := types.PaymentCategory(data[3])
-
-			status := types.PaymentStatus(data[4])
+// importFavorite - то же для избранного.
+func (s *Service) importFavorite(record *types.Favorite) error {
+	favorite, err := s.findFavoriteByID(record.ID)
+	if err != nil {
+		favorite = record
+	} else {
+		favorite.AccountID = record.AccountID
+		favorite.Name = record.Name
+		favorite.Amount = record.Amount
+		favorite.Currency = record.Currency
+		favorite.Category = record.Category
+		if !record.CreatedAt.IsZero() {
+			favorite.CreatedAt = record.CreatedAt
+		}
+	}
 
-			payment, err := s.FindPaymentByID(id)
-			if err != nil {
-				newPayment := &types.Payment{
-					ID:        id,
-					AccountID: int64(accountID),
-					Amount:    types.Money(amount),
-					Category:  types.PaymentCategory(category),
-					Status:    types.PaymentStatus(status),
-				}
+	err = s.repository().SaveFavorite(favorite)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
 
-				s.payments = append(s.payments, newPayment)
-			} else {
-				payment.AccountID = int64(accountID)
-				payment.Amount = types.Money(amount)
-				payment.Category = category
-				payment.Status = status
-			}
-		}
+// importTransfer - то же для перевода.
+func (s *Service) importTransfer(record *types.Transfer) error {
+	transfer, err := s.findTransferByID(record.ID)
+	if err != nil {
+		transfer = record
 	} else {
-		log.Println(ErrFileNotFound.Error())
+		transfer.FromAccountID = record.FromAccountID
+		transfer.ToAccountID = record.ToAccountID
+		transfer.Amount = record.Amount
+		transfer.Status = record.Status
+		if !record.CreatedAt.IsZero() {
+			transfer.CreatedAt = record.CreatedAt
+		}
+		if !record.UpdatedAt.IsZero() {
+			transfer.UpdatedAt = record.UpdatedAt
+		}
 	}
 
+	err = s.repository().SaveTransfer(transfer)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 	return nil
 }
 
-func (s *Service) actionByFavorites(path string) error {
-	byteData, err := ioutil.ReadFile(path)
-	if err == nil {
-		datas := string(byteData)
-		splits := strings.Split(datas, "\n")
+// ExportAccountHistory возвращает платежи и переводы счёта, отсортированные по дате создания.
+// Переводы попадают в историю с категорией "transfer": исходящий перевод
+// с положительной суммой, входящий - с отрицательной (деньги пришли на счёт).
+func (s *Service) ExportAccountHistory(accountID int64) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-		for _, split := range splits {
-			if len(split) == 0 {
-				break
-			}
+	return s.exportAccountHistory(accountID)
+}
 
-			data := strings.Split(split, ";")
-			id := data[0]
+func (s *Service) exportAccountHistory(accountID int64) ([]types.Payment, error) {
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+	accountPayments, err := s.repository().PaymentsByAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
 
-			accountID, err := strconv.Atoi(data[1])
-			if err != nil {
-				log.Println("can't parse str to int")
-				return err
-			}
+	transfers, err := s.repository().TransfersByAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+	for _, transfer := range transfers {
+		if transfer.FromAccountID == accountID {
+			accountPayments = append(accountPayments, transferToPayment(transfer, account, transfer.Amount))
+		}
+		if transfer.ToAccountID == accountID {
+			accountPayments = append(accountPayments, transferToPayment(transfer, account, -transfer.Amount))
+		}
+	}
 
-			name := data[2]
+	sort.SliceStable(accountPayments, func(i, j int) bool {
+		return accountPayments[i].CreatedAt.Before(accountPayments[j].CreatedAt)
+	})
 
-			amount, err := strconv.Atoi(data[3])
-			if err != nil {
-				log.Println("can't parse str to int")
-				return err
-			}
+	return accountPayments, nil
+}
 
-			category := types.PaymentCategory(data[4])
+// ExportAccountHistoryBetween возвращает историю счёта за период [from, to).
+// Нулевое значение from или to означает, что граница не задана.
+func (s *Service) ExportAccountHistoryBetween(accountID int64, from, to time.Time) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-			favorite, err := s.FindFavoriteByID(id)
-			if err != nil {
-				newFavorite := &types.Favorite{
-					ID:        id,
-					AccountID: int64(accountID),
-					Name:      name,
-					Amount:    types.Money(amount),
-					Category:  types.PaymentCategory(category),
-				}
+	history, err := s.exportAccountHistory(accountID)
+	if err != nil {
+		return nil, err
+	}
 
-				s.favorites = append(s.favorites, newFavorite)
-			} else {
-				favorite.AccountID = int64(accountID)
-				favorite.Name = name
-				favorite.Amount = types.Money(amount)
-				favorite.Category = category
-			}
+	filtered := []types.Payment{}
+	for _, payment := range history {
+		if !from.IsZero() && payment.CreatedAt.Before(from) {
+			continue
 		}
-	} else {
-		log.Println(ErrFileNotFound.Error())
+		if !to.IsZero() && !payment.CreatedAt.Before(to) {
+			continue
+		}
+		filtered = append(filtered, payment)
 	}
 
-	return nil
+	return filtered, nil
 }
 
-func (s *Service) ExportAccountHistory(accountID int64) ([]types.Payment, error) {
-	_, err := s.FindAccountByID(accountID)
-	if err != nil {
-		return nil, ErrAccountNotFound
-	}
-	accountPayments := []types.Payment{}
-
-	for _, payment := range s.payments {
-		if payment.AccountID == accountID {
-			accountPayments = append(accountPayments, types.Payment{
-				ID:        payment.ID,
-				AccountID: payment.AccountID,
-				Amount:    payment.Amount,
-				Category:  payment.Category,
-				Status:    payment.Status,
-			})
-		}
+func transferToPayment(transfer types.Transfer, account *types.Account, amount types.Money) types.Payment {
+	return types.Payment{
+		ID:        transfer.ID,
+		AccountID: account.ID,
+		Amount:    amount,
+		Currency:  accountCurrency(account),
+		Category:  types.PaymentCategoryTransfer,
+		Status:    transfer.Status,
+		CreatedAt: transfer.CreatedAt,
+		UpdatedAt: transfer.UpdatedAt,
 	}
-
-	return accountPayments, nil
 }
 
 func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records int) error {
@@ -642,16 +1025,10 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 }
 
 func exportPayments(payments []types.Payment, path string) error {
-	pay := ""
+	pay := paymentsSchema.header()
 
 	for _, payment := range payments {
-
-		pay += payment.ID + ";"
-		pay += strconv.Itoa(int(payment.AccountID)) + ";"
-		pay += strconv.Itoa(int(payment.Amount)) + ";"
-		pay += string(payment.Category) + ";"
-		pay += string(payment.Status) + ";"
-		pay += "\n"
+		pay += formatPaymentLine(&payment)
 	}
 	err := WriteToFile(path, pay)
 	if err != nil {
@@ -661,95 +1038,107 @@ func exportPayments(payments []types.Payment, path string) error {
 	return nil
 }
 
+// SumPayments возвращает сумму всех платежей. Сумма, которая не помещается
+// в types.Money, насыщается до math.MaxInt64 (или math.MinInt64), а
+// переполнение пишется в лог - так его не спутать с отсутствием платежей.
 func (s *Service) SumPayments(goroutines int) types.Money {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	payments, err := s.repository().Payments()
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+
 	wg := sync.WaitGroup{}
 	mu := sync.Mutex{}
-	var summ types.Money = 0
+	summ := new(big.Int)
+	// sum складывает свою часть платежей и добавляет её к общей сумме.
+	sum := func(payments []types.Payment) {
+		defer wg.Done()
+		s := new(big.Int)
+		for _, payment := range payments {
+			s.Add(s, big.NewInt(int64(payment.Amount)))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		summ.Add(summ, s)
+	}
 	if goroutines == 0 || goroutines == 1 {
 		wg.Add(1)
-		go func(payments []*types.Payment) {
-			defer wg.Done()
-			for _, payment := range payments {
-				summ += payment.Amount
-			}
-		}(s.payments)
+		go sum(payments)
 	} else {
 		from := 0
-		count := len(s.payments) / goroutines
+		count := len(payments) / goroutines
 		for i := 1; i <= goroutines; i++ {
 			wg.Add(1)
-			last := len(s.payments) - i*count
+			last := len(payments) - i*count
 			if i == goroutines {
 				last = 0
 			}
-			to := len(s.payments) - last
-			go func(payments []*types.Payment) {
-				defer wg.Done()
-				s := types.Money(0)
-				for _, payment := range payments {
-					s += payment.Amount
-				}
-				mu.Lock()
-				defer mu.Unlock()
-				summ += s
-			}(s.payments[from:to])
+			to := len(payments) - last
+			go sum(payments[from:to])
 			from += count
 		}
 	}
 
 	wg.Wait()
 
-	return summ
+	if summ.IsInt64() {
+		return types.Money(summ.Int64())
+	}
+	log.Printf("%v: payments sum %s", types.ErrMoneyOverflow, summ)
+	if summ.Sign() > 0 {
+		return math.MaxInt64
+	}
+	return math.MinInt64
 }
 
 func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// обходим только платежи этого счёта из индекса, а не все платежи сервиса
+	accountPayments, err := s.repository().PaymentsByAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
 	filteredPayments := []types.Payment{}
 	wg := sync.WaitGroup{}
 	mu := sync.Mutex{}
 	if goroutines == 0 || goroutines == 1 {
 		wg.Add(1)
-		go func(payments []*types.Payment) {
+		go func(payments []types.Payment) {
 			defer wg.Done()
 			for _, payment := range payments {
 				if payment.AccountID == accountID {
-					filteredPayments = append(filteredPayments, types.Payment{
-						ID:        payment.ID,
-						AccountID: payment.AccountID,
-						Amount:    payment.Amount,
-						Category:  payment.Category,
-						Status:    payment.Status,
-					})
+					filteredPayments = append(filteredPayments, payment)
 				}
 			}
-		}(s.payments)
+		}(accountPayments)
 	} else {
 		from := 0
-		count := len(s.payments) / goroutines
+		count := len(accountPayments) / goroutines
 		for i := 1; i <= goroutines; i++ {
 			wg.Add(1)
-			last := len(s.payments) - i*count
+			last := len(accountPayments) - i*count
 			if i == goroutines {
 				last = 0
 			}
-			to := len(s.payments) - last
-			go func(payments []*types.Payment) {
+			to := len(accountPayments) - last
+			go func(payments []types.Payment) {
 				defer wg.Done()
 				separetePayments := []types.Payment{}
 				for _, payment := range payments {
 					if payment.AccountID == accountID {
-						separetePayments = append(separetePayments, types.Payment{
-							ID:        payment.ID,
-							AccountID: payment.AccountID,
-							Amount:    payment.Amount,
-							Category:  payment.Category,
-							Status:    payment.Status,
-						})
+						separetePayments = append(separetePayments, payment)
 					}
 				}
 				mu.Lock()
 				defer mu.Unlock()
 				filteredPayments = append(filteredPayments, separetePayments...)
-			}(s.payments[from:to])
+			}(accountPayments[from:to])
 			from += count
 		}
 	}
@@ -764,41 +1153,50 @@ func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payme
 }
 
 func (s *Service) FilterPaymentsByFn(filter func(payment types.Payment) bool, goroutines int) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	payments, err := s.repository().Payments()
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
 	filteredPayments := []types.Payment{}
 	wg := sync.WaitGroup{}
 	mu := sync.Mutex{}
 	if goroutines == 0 || goroutines == 1 {
 		wg.Add(1)
-		go func(payments []*types.Payment) {
+		go func(payments []types.Payment) {
 			defer wg.Done()
 			for _, payment := range payments {
-				if filter(*payment) {
-					filteredPayments = append(filteredPayments, *payment)
+				if filter(payment) {
+					filteredPayments = append(filteredPayments, payment)
 				}
 			}
-		}(s.payments)
+		}(payments)
 	} else {
 		from := 0
-		count := len(s.payments) / goroutines
+		count := len(payments) / goroutines
 		for i := 1; i <= goroutines; i++ {
 			wg.Add(1)
-			last := len(s.payments) - i*count
+			last := len(payments) - i*count
 			if i == goroutines {
 				last = 0
 			}
-			to := len(s.payments) - last
-			go func(payments []*types.Payment) {
+			to := len(payments) - last
+			go func(payments []types.Payment) {
 				defer wg.Done()
 				separetePayments := []types.Payment{}
 				for _, payment := range payments {
-					if filter(*payment) {
-						separetePayments = append(separetePayments, *payment)
+					if filter(payment) {
+						separetePayments = append(separetePayments, payment)
 					}
 				}
 				mu.Lock()
 				defer mu.Unlock()
 				filteredPayments = append(filteredPayments, separetePayments...)
-			}(s.payments[from:to])
+			}(payments[from:to])
 			from += count
 		}
 	}
@@ -815,8 +1213,15 @@ func (s *Service) FilterPaymentsByFn(filter func(payment types.Payment) bool, go
 func (s *Service) SumPaymentsWithProgress() <-chan types.Progress {
 	size := 100_0000
 
-	amountOfMoney := make([]types.Money, 0)
-	for _, pay := range s.payments {
+	s.mu.RLock()
+	payments, err := s.repository().Payments()
+	s.mu.RUnlock()
+	if err != nil {
+		log.Print(err)
+	}
+
+	amountOfMoney := make([]types.Money, 0, len(payments))
+	for _, pay := range payments {
 		amountOfMoney = append(amountOfMoney, pay.Amount)
 	}
 
@@ -829,14 +1234,18 @@ func (s *Service) SumPaymentsWithProgress() <-chan types.Progress {
 	for i := 0; i < goroutines; i++ {
 		wg.Add(1)
 		go func(ch chan<- types.Progress, amountOfMoney []types.Money, part int) {
-			sum := 0
+			sum := types.Money(0)
 			defer wg.Done()
 			for _, val := range amountOfMoney {
-				sum += int(val)
-
+				var err error
+				sum, err = sum.Add(val)
+				if err != nil {
+					log.Print(err)
+					break
+				}
 			}
 			ch <- types.Progress{
-				Result: types.Money(sum),
+				Result: sum,
 			}
 		}(ch, amountOfMoney, i)
 	}
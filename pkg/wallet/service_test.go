@@ -1,9 +1,16 @@
 package wallet
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gholib/wallet/pkg/types"
 	"github.com/google/uuid"
@@ -274,19 +281,85 @@ func TestService_Export_success(t *testing.T) {
 		t.Errorf("PayFromFavorite() can't for an favorite(%v), error = %v", paymentFavorite, err)
 	}
 
-	err = s.Export("data")
+	err = s.Export(t.TempDir())
 	if err != nil {
 		t.Errorf("Export() Error can't export error = %v", err)
 	}
 }
 
+// copyTestData копирует выгрузку старого формата из data во временный
+// каталог: тесты не должны переписывать файлы в репозитории.
+func copyTestData(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	paths, err := filepath.Glob(filepath.Join("data", "*.dump"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no test data, error = %v", err)
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.Base(path)), data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
 func TestService_Import_success(t *testing.T) {
 	s := newTestService()
 
-	err := s.Import("data")
+	err := s.Import(copyTestData(t))
 
 	if err != nil {
 		t.Errorf("Import() Error can't import error = %v", err)
+		return
+	}
+	account, err := s.FindAccountByID(2)
+	if err != nil || account.Phone != "+992935444994" || account.Balance != 8_000_00 {
+		t.Errorf("Import(): legacy account = %v, error = %v", account, err)
+		return
+	}
+	favorite, err := s.FindFavoriteByID("934a1b43-52b0-4023-919e-d4e9d7a31b3b")
+	if err != nil || favorite.AccountID != 2 || favorite.Name != "ogastus" {
+		t.Errorf("Import(): legacy favorite = %v, error = %v", favorite, err)
+	}
+}
+
+func TestMigrateDumps_legacyData(t *testing.T) {
+	dir := copyTestData(t)
+
+	migrated, err := MigrateDumps(dir)
+	want := []string{accountsFile, favoritesFile, "payments1.dump", "payments2.dump"}
+	if err != nil || !reflect.DeepEqual(migrated, want) {
+		t.Errorf("MigrateDumps() = %v, error = %v, want %v", migrated, err, want)
+		return
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, accountsFile))
+	if err != nil || !strings.HasPrefix(string(data), accountsSchema.header()) {
+		t.Errorf("MigrateDumps(): accounts not in current format, data = %q, error = %v", data, err)
+		return
+	}
+
+	// Второй раз переписывать нечего, а импорт читает новый формат так же.
+	migrated, err = MigrateDumps(dir)
+	if err != nil || len(migrated) != 0 {
+		t.Errorf("MigrateDumps() again = %v, error = %v", migrated, err)
+		return
+	}
+	s := newTestService()
+	err = s.Import(dir)
+	if err != nil {
+		t.Errorf("Import() after MigrateDumps: error = %v", err)
+		return
+	}
+	account, err := s.FindAccountByID(1)
+	if err != nil || account.Balance != 9_000_00 {
+		t.Errorf("Import() after MigrateDumps: account = %v, error = %v", account, err)
 	}
 }
 
@@ -329,11 +402,16 @@ func TestService_HistoryToFiles_success(t *testing.T) {
 		return
 	}
 
-	err = s.HistoryToFiles(payments, "data", 2)
+	dir := t.TempDir()
+	err = s.HistoryToFiles(payments, dir, 2)
 	if err != nil {
 		t.Errorf("HistoryToFiles() Error can't export to file, error = %v", err)
 		return
 	}
+	files, err := filepath.Glob(filepath.Join(dir, "payments*.dump"))
+	if err != nil || len(files) != 2 {
+		t.Errorf("HistoryToFiles(): files = %v, error = %v, want 2 files of 2 records", files, err)
+	}
 }
 
 func BenchmarkSumPayments(b *testing.B) {
@@ -399,3 +477,568 @@ func BenchmarkSumPayments(b *testing.B) {
 		}
 	}
 }
+
+func newBenchmarkService(b *testing.B, accounts int, paymentsPerAccount int) *testService {
+	s := newTestService()
+	for i := 0; i < accounts; i++ {
+		account, err := s.addAccountWithBalance(types.Phone(fmt.Sprintf("+992%09d", i)), types.Money(paymentsPerAccount)*100)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < paymentsPerAccount; j++ {
+			_, err = s.Pay(account.ID, 100, "auto")
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	return s
+}
+
+func BenchmarkFindPaymentByID(b *testing.B) {
+	s := newBenchmarkService(b, 100, 1000)
+	payments, err := s.repository().PaymentsByAccount(50)
+	if err != nil {
+		b.Fatal(err)
+	}
+	id := payments[len(payments)/2].ID
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.FindPaymentByID(id)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExportAccountHistory(b *testing.B) {
+	s := newBenchmarkService(b, 100, 1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		payments, err := s.ExportAccountHistory(50)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(payments) != 1000 {
+			b.Fatalf("invalid result, got = %v want = %v", len(payments), 1000)
+		}
+	}
+}
+
+func BenchmarkFilterPayments(b *testing.B) {
+	s := newBenchmarkService(b, 100, 1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		payments, err := s.FilterPayments(50, 4)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(payments) != 1000 {
+			b.Fatalf("invalid result, got = %v want = %v", len(payments), 1000)
+		}
+	}
+}
+
+func BenchmarkRegisterAccount(b *testing.B) {
+	s := newTestService()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.RegisterAccount(types.Phone(fmt.Sprintf("+992%09d", i)))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestService_Transfer_success(t *testing.T) {
+	s := newTestService()
+
+	from, err := s.addAccountWithBalance("+992880806776", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	to, err := s.RegisterAccount("+992935444994")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	transfer, err := s.Transfer(from.ID, to.ID, 1000_00)
+	if err != nil {
+		t.Errorf("Transfer(): error = %v", err)
+		return
+	}
+
+	from, _ = s.FindAccountByID(from.ID)
+	to, _ = s.FindAccountByID(to.ID)
+	if from.Balance != 9_000_00 || to.Balance != 1000_00 {
+		t.Errorf("Transfer(): wrong balances, from = %v, to = %v", from, to)
+		return
+	}
+
+	got, err := s.FindTransferByID(transfer.ID)
+	if err != nil || !reflect.DeepEqual(got, transfer) {
+		t.Errorf("FindTransferByID(): got = %v, error = %v", got, err)
+		return
+	}
+
+	history, err := s.ExportAccountHistory(to.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(history) != 1 || history[0].Amount != -1000_00 || history[0].Category != types.PaymentCategoryTransfer {
+		t.Errorf("ExportAccountHistory(): transfer not in history, history = %v", history)
+	}
+}
+
+func TestService_RejectTransfer_success(t *testing.T) {
+	s := newTestService()
+
+	from, err := s.addAccountWithBalance("+992880806776", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	to, err := s.addAccountWithBalance("+992935444994", 1_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	transfer, err := s.Transfer(from.ID, to.ID, 1000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.RejectTransfer(transfer.ID)
+	if err != nil {
+		t.Errorf("RejectTransfer(): error = %v", err)
+		return
+	}
+
+	from, _ = s.FindAccountByID(from.ID)
+	to, _ = s.FindAccountByID(to.ID)
+	if from.Balance != 10_000_00 || to.Balance != 1_00 {
+		t.Errorf("RejectTransfer(): balances not restored, from = %v, to = %v", from, to)
+		return
+	}
+
+	err = s.RejectTransfer(transfer.ID)
+	if err != ErrTransferAlreadyRejected {
+		t.Errorf("RejectTransfer(): must return ErrTransferAlreadyRejected, returned %v", err)
+	}
+}
+
+func TestService_Transfer_notEnoughBalance(t *testing.T) {
+	s := newTestService()
+
+	from, err := s.addAccountWithBalance("+992880806776", 1_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	to, err := s.addAccountWithBalance("+992935444994", 1_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.Transfer(from.ID, to.ID, 2_00)
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Transfer(): must return ErrNotEnoughBalance, returned %v", err)
+	}
+}
+
+func TestService_Confirm_success(t *testing.T) {
+	s := newTestService()
+
+	_, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment := payments[0]
+	err = s.Confirm(payment.ID)
+	if err != nil {
+		t.Errorf("Confirm(): error = %v", err)
+		return
+	}
+	payment, err = s.FindPaymentByID(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if payment.Status != types.PaymentStatusOk {
+		t.Errorf("Confirm(): status didnt changed, payment = %v", payment)
+		return
+	}
+
+	err = s.Reject(payment.ID)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Reject(): must return ErrIllegalTransition for OK payment, returned %v", err)
+	}
+}
+
+func TestService_Reject_twice(t *testing.T) {
+	s := newTestService()
+
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment := payments[0]
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Errorf("Reject(): error = %v", err)
+		return
+	}
+
+	err = s.Reject(payment.ID)
+	var transitionErr *TransitionError
+	if !errors.As(err, &transitionErr) || transitionErr.From != types.PaymentStatusFail {
+		t.Errorf("Reject(): must return TransitionError, returned %v", err)
+		return
+	}
+
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != defaultTestAccount.balance {
+		t.Errorf("Reject(): payment refunded twice, account = %v", account)
+	}
+}
+
+func TestService_Cancel_success(t *testing.T) {
+	s := newTestService()
+
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment := payments[0]
+	err = s.Cancel(payment.ID)
+	if err != nil {
+		t.Errorf("Cancel(): error = %v", err)
+		return
+	}
+	payment, _ = s.FindPaymentByID(payment.ID)
+	account, _ = s.FindAccountByID(account.ID)
+	if payment.Status != types.PaymentStatusCancelled || account.Balance != defaultTestAccount.balance {
+		t.Errorf("Cancel(): payment = %v, account = %v", payment, account)
+		return
+	}
+
+	err = s.Confirm(payment.ID)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Confirm(): must return ErrIllegalTransition for cancelled payment, returned %v", err)
+	}
+}
+
+func TestService_Import_illegalTransition(t *testing.T) {
+	s := newTestService()
+
+	_, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Import(dir)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Import(): must return ErrIllegalTransition for FAIL -> INPROGRESS, returned %v", err)
+	}
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestService_ExportAccountHistoryBetween_success(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC)}
+	s.SetClock(clock.Now)
+
+	account, err := s.addAccountWithBalance("+992880806776", 10_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err = s.Pay(account.ID, 100_00, "auto")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		clock.add(24 * time.Hour)
+	}
+
+	from := time.Date(2020, 10, 2, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 10, 3, 0, 0, 0, 0, time.UTC)
+	history, err := s.ExportAccountHistoryBetween(account.ID, from, to)
+	if err != nil {
+		t.Errorf("ExportAccountHistoryBetween(): error = %v", err)
+		return
+	}
+
+	if len(history) != 1 || !history[0].CreatedAt.Equal(time.Date(2020, 10, 2, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("ExportAccountHistoryBetween(): wrong history = %v", history)
+	}
+}
+
+func TestService_Import_timestamps(t *testing.T) {
+	s := newTestService()
+	clock := &testClock{now: time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC)}
+	s.SetClock(clock.Now)
+
+	_, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+
+	got, err := imported.FindPaymentByID(payments[0].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !got.CreatedAt.Equal(clock.now) || !got.UpdatedAt.Equal(clock.now) {
+		t.Errorf("Import(): timestamps lost, payment = %v", got)
+	}
+}
+
+func TestService_Import_legacyDump(t *testing.T) {
+	dir := t.TempDir()
+	err := WriteToFile(dir+"/accounts.dump", "1;+992880806776;900000;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = WriteToFile(dir+"/payments.dump", "fc10959f-5f28-40e2-81bc-a70348e0549a;1;100000;auto;INPROGRESS;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s := newTestService()
+	err = s.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+
+	payment, err := s.FindPaymentByID("fc10959f-5f28-40e2-81bc-a70348e0549a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !payment.CreatedAt.IsZero() {
+		t.Errorf("Import(): legacy payment must have zero time, payment = %v", payment)
+	}
+}
+
+func TestService_Pay_concurrent(t *testing.T) {
+	s := newTestService()
+
+	account, err := s.addAccountWithBalance("+992880806776", 100_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	succeeded := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := s.Pay(account.ID, 10_00, "auto")
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			s.SumPayments(2)
+			_, _ = s.ExportAccountHistory(account.ID)
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 10 {
+		t.Errorf("Pay(): must succeed 10 times, succeeded %v", succeeded)
+	}
+
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 0 {
+		t.Errorf("Pay(): account overdrawn, account = %v", account)
+	}
+
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Errorf("VerifyLedger(): error = %v", err)
+	}
+}
+
+func TestService_Import_reindex(t *testing.T) {
+	s := newTestService()
+
+	first, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.addAccountWithBalance("+992935444994", 100_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	err = WriteToFile(dir+"/payments.dump", payments[0].ID+";"+fmt.Sprint(second.ID)+";100000;auto;INPROGRESS;\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.FilterPayments(first.ID, 1)
+	if err != ErrAccountNotFound {
+		t.Errorf("FilterPayments(): payment must move to another account, error = %v", err)
+		return
+	}
+	moved, err := s.FilterPayments(second.ID, 1)
+	if err != nil || len(moved) != 1 {
+		t.Errorf("FilterPayments(): moved = %v, error = %v", moved, err)
+	}
+}
+
+func TestService_ImportFromFile_validation(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	err = WriteToFile(path, "2;+992935444994;50000|3;+992880806776;10000|")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ImportFromFile(path)
+	if !errors.Is(err, ErrDuplicatePhone) {
+		t.Errorf("ImportFromFile(): must return ErrDuplicatePhone, returned %v", err)
+		return
+	}
+	_, err = s.FindAccountByID(2)
+	if err != ErrAccountNotFound {
+		t.Errorf("ImportFromFile(): failed import created account, error = %v", err)
+		return
+	}
+
+	err = WriteToFile(path, "2;+992935444994;50000|")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ImportFromFile(path)
+	if err != nil {
+		t.Errorf("ImportFromFile(): error = %v", err)
+		return
+	}
+	account, err := s.FindAccountByID(2)
+	if err != nil || account.Balance != 50000 || account.Currency != types.DefaultCurrency {
+		t.Errorf("ImportFromFile(): account = %v, error = %v", account, err)
+		return
+	}
+	next, err := s.RegisterAccount("+992935444995")
+	if err != nil || next.ID != 3 {
+		t.Errorf("RegisterAccount(): must continue numbering after import, account = %v, error = %v", next, err)
+	}
+}
+
+func TestService_SumPayments_overflow(t *testing.T) {
+	s := newTestService()
+	for _, id := range []string{"p1", "p2"} {
+		err := s.repository().SavePayment(&types.Payment{ID: id, AccountID: 1, Amount: math.MaxInt64 / 2, Status: types.PaymentStatusOk})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	err := s.repository().SavePayment(&types.Payment{ID: "p3", AccountID: 1, Amount: 10, Status: types.PaymentStatusOk})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, goroutines := range []int{1, 3} {
+		got := s.SumPayments(goroutines)
+		if got != math.MaxInt64 {
+			t.Errorf("SumPayments(%d) = %v, want saturated %v", goroutines, got, types.Money(math.MaxInt64))
+		}
+	}
+}
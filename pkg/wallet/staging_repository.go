@@ -0,0 +1,490 @@
+package wallet
+
+import (
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+// stagingRepository - хранилище для staged: записи пишутся в changes,
+// а читаются сначала из changes, потом из base. Так импорт видит всё
+// состояние сервиса, а в памяти держит только то, что загрузил сам.
+type stagingRepository struct {
+	base    Repository
+	changes *MemoryRepository
+}
+
+func newStagingRepository(base Repository) *stagingRepository {
+	return &stagingRepository{base: base, changes: NewMemoryRepository()}
+}
+
+func (r *stagingRepository) Accounts() ([]types.Account, error) {
+	accounts, err := r.base.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	for i := range accounts {
+		changed, err := r.changes.AccountByID(accounts[i].ID)
+		if err == nil {
+			accounts[i] = *changed
+		}
+	}
+	added, _ := r.changes.Accounts()
+	for _, account := range added {
+		if _, err := r.base.AccountByID(account.ID); err != nil {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
+}
+
+func (r *stagingRepository) AccountByID(accountID int64) (*types.Account, error) {
+	account, err := r.changes.AccountByID(accountID)
+	if err == nil {
+		return account, nil
+	}
+	return r.base.AccountByID(accountID)
+}
+
+func (r *stagingRepository) AccountByPhone(phone types.Phone) (*types.Account, error) {
+	account, err := r.changes.AccountByPhone(phone)
+	if err == nil {
+		return account, nil
+	}
+	account, err = r.base.AccountByPhone(phone)
+	if err != nil {
+		return nil, err
+	}
+	// Счёт мог сменить телефон уже при импорте.
+	if _, err := r.changes.AccountByID(account.ID); err == nil {
+		return nil, ErrAccountNotFound
+	}
+	return account, nil
+}
+
+func (r *stagingRepository) SaveAccount(account *types.Account) error {
+	return r.changes.SaveAccount(account)
+}
+
+// Delete* убирают только изменения: записи base черновик не удаляет.
+func (r *stagingRepository) DeleteAccount(accountID int64) error {
+	return r.changes.DeleteAccount(accountID)
+}
+
+func (r *stagingRepository) Payments() ([]types.Payment, error) {
+	payments, err := r.base.Payments()
+	if err != nil {
+		return nil, err
+	}
+	added, _ := r.changes.Payments()
+	return mergePayments(payments, added, func(types.Payment) bool { return true }), nil
+}
+
+func (r *stagingRepository) PaymentByID(paymentID string) (*types.Payment, error) {
+	payment, err := r.changes.PaymentByID(paymentID)
+	if err == nil {
+		return payment, nil
+	}
+	return r.base.PaymentByID(paymentID)
+}
+
+func (r *stagingRepository) PaymentsByAccount(accountID int64) ([]types.Payment, error) {
+	payments, err := r.base.PaymentsByAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+	added, _ := r.changes.Payments()
+	return mergePayments(payments, added, func(payment types.Payment) bool {
+		return payment.AccountID == accountID
+	}), nil
+}
+
+// mergePayments подменяет в base записи, изменённые в changes, и дописывает
+// новые; keep отбирает записи из changes, как base отобран запросом.
+func mergePayments(base, changes []types.Payment, keep func(types.Payment) bool) []types.Payment {
+	index := map[string]int{}
+	for i, payment := range changes {
+		index[payment.ID] = i
+	}
+	merged := base[:0]
+	for _, payment := range base {
+		i, ok := index[payment.ID]
+		if ok {
+			delete(index, payment.ID)
+			payment = changes[i]
+			if !keep(payment) {
+				continue
+			}
+		}
+		merged = append(merged, payment)
+	}
+	for _, payment := range changes {
+		if _, ok := index[payment.ID]; ok && keep(payment) {
+			merged = append(merged, payment)
+		}
+	}
+	return merged
+}
+
+func (r *stagingRepository) SavePayment(payment *types.Payment) error {
+	return r.changes.SavePayment(payment)
+}
+
+func (r *stagingRepository) DeletePayment(paymentID string) error {
+	return r.changes.DeletePayment(paymentID)
+}
+
+func (r *stagingRepository) Favorites() ([]types.Favorite, error) {
+	favorites, err := r.base.Favorites()
+	if err != nil {
+		return nil, err
+	}
+	for i := range favorites {
+		changed, err := r.changes.FavoriteByID(favorites[i].ID)
+		if err == nil {
+			favorites[i] = *changed
+		}
+	}
+	added, _ := r.changes.Favorites()
+	for _, favorite := range added {
+		if _, err := r.base.FavoriteByID(favorite.ID); err != nil {
+			favorites = append(favorites, favorite)
+		}
+	}
+	return favorites, nil
+}
+
+func (r *stagingRepository) FavoriteByID(favoriteID string) (*types.Favorite, error) {
+	favorite, err := r.changes.FavoriteByID(favoriteID)
+	if err == nil {
+		return favorite, nil
+	}
+	return r.base.FavoriteByID(favoriteID)
+}
+
+func (r *stagingRepository) SaveFavorite(favorite *types.Favorite) error {
+	return r.changes.SaveFavorite(favorite)
+}
+
+func (r *stagingRepository) DeleteFavorite(favoriteID string) error {
+	return r.changes.DeleteFavorite(favoriteID)
+}
+
+func (r *stagingRepository) Transfers() ([]types.Transfer, error) {
+	transfers, err := r.base.Transfers()
+	if err != nil {
+		return nil, err
+	}
+	added, _ := r.changes.Transfers()
+	return mergeTransfers(transfers, added, func(types.Transfer) bool { return true }), nil
+}
+
+func (r *stagingRepository) TransferByID(transferID string) (*types.Transfer, error) {
+	transfer, err := r.changes.TransferByID(transferID)
+	if err == nil {
+		return transfer, nil
+	}
+	return r.base.TransferByID(transferID)
+}
+
+func (r *stagingRepository) TransfersByAccount(accountID int64) ([]types.Transfer, error) {
+	transfers, err := r.base.TransfersByAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+	added, _ := r.changes.Transfers()
+	return mergeTransfers(transfers, added, func(transfer types.Transfer) bool {
+		return transfer.FromAccountID == accountID || transfer.ToAccountID == accountID
+	}), nil
+}
+
+// mergeTransfers - то же, что mergePayments, для переводов.
+func mergeTransfers(base, changes []types.Transfer, keep func(types.Transfer) bool) []types.Transfer {
+	index := map[string]int{}
+	for i, transfer := range changes {
+		index[transfer.ID] = i
+	}
+	merged := base[:0]
+	for _, transfer := range base {
+		i, ok := index[transfer.ID]
+		if ok {
+			delete(index, transfer.ID)
+			transfer = changes[i]
+			if !keep(transfer) {
+				continue
+			}
+		}
+		merged = append(merged, transfer)
+	}
+	for _, transfer := range changes {
+		if _, ok := index[transfer.ID]; ok && keep(transfer) {
+			merged = append(merged, transfer)
+		}
+	}
+	return merged
+}
+
+func (r *stagingRepository) SaveTransfer(transfer *types.Transfer) error {
+	return r.changes.SaveTransfer(transfer)
+}
+
+func (r *stagingRepository) DeleteTransfer(transferID string) error {
+	return r.changes.DeleteTransfer(transferID)
+}
+
+func (r *stagingRepository) IdempotencyKeys() ([]types.IdempotencyKey, error) {
+	keys, err := r.base.IdempotencyKeys()
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		changed, err := r.changes.IdempotencyKey(keys[i].Key)
+		if err == nil {
+			keys[i] = *changed
+		}
+	}
+	added, _ := r.changes.IdempotencyKeys()
+	for _, key := range added {
+		if _, err := r.base.IdempotencyKey(key.Key); err != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (r *stagingRepository) IdempotencyKey(key string) (*types.IdempotencyKey, error) {
+	record, err := r.changes.IdempotencyKey(key)
+	if err == nil {
+		return record, nil
+	}
+	return r.base.IdempotencyKey(key)
+}
+
+func (r *stagingRepository) SaveIdempotencyKey(key *types.IdempotencyKey) error {
+	return r.changes.SaveIdempotencyKey(key)
+}
+
+func (r *stagingRepository) DeleteIdempotencyKey(key string) error {
+	return r.changes.DeleteIdempotencyKey(key)
+}
+
+// PruneIdempotencyKeys чистит только изменения: хранилище сервиса черновик
+// не меняет до commit.
+func (r *stagingRepository) PruneIdempotencyKeys(cutoff time.Time) error {
+	return r.changes.PruneIdempotencyKeys(cutoff)
+}
+
+// Записи аудита уровней не меняются, поэтому изменения только дописываются.
+func (r *stagingRepository) TierChanges() ([]types.TierChange, error) {
+	changes, err := r.base.TierChanges()
+	if err != nil {
+		return nil, err
+	}
+	added, _ := r.changes.TierChanges()
+	for _, change := range added {
+		if _, err := r.base.TierChangeByID(change.ID); err != nil {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func (r *stagingRepository) TierChangeByID(changeID string) (*types.TierChange, error) {
+	change, err := r.changes.TierChangeByID(changeID)
+	if err == nil {
+		return change, nil
+	}
+	return r.base.TierChangeByID(changeID)
+}
+
+func (r *stagingRepository) TierChangesByAccount(accountID int64) ([]types.TierChange, error) {
+	changes, err := r.base.TierChangesByAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+	added, _ := r.changes.TierChangesByAccount(accountID)
+	for _, change := range added {
+		if _, err := r.base.TierChangeByID(change.ID); err != nil {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func (r *stagingRepository) SaveTierChange(change *types.TierChange) error {
+	return r.changes.SaveTierChange(change)
+}
+
+func (r *stagingRepository) DeleteTierChange(changeID string) error {
+	return r.changes.DeleteTierChange(changeID)
+}
+
+// Записи книги тоже только дописываются.
+func (r *stagingRepository) LedgerEntries() ([]types.LedgerEntry, error) {
+	entries, err := r.base.LedgerEntries()
+	if err != nil {
+		return nil, err
+	}
+	added, _ := r.changes.LedgerEntries()
+	for _, entry := range added {
+		if _, err := r.base.LedgerEntryByID(entry.ID); err != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (r *stagingRepository) LedgerEntryByID(entryID string) (*types.LedgerEntry, error) {
+	entry, err := r.changes.LedgerEntryByID(entryID)
+	if err == nil {
+		return entry, nil
+	}
+	return r.base.LedgerEntryByID(entryID)
+}
+
+func (r *stagingRepository) SaveLedgerEntry(entry *types.LedgerEntry) error {
+	return r.changes.SaveLedgerEntry(entry)
+}
+
+func (r *stagingRepository) DeleteLedgerEntry(entryID string) error {
+	return r.changes.DeleteLedgerEntry(entryID)
+}
+
+// commit переносит изменения в base. Перед каждой записью запоминает, что
+// было в base, и при ошибке в обратном порядке возвращает прежние версии, а
+// созданные записи удаляет.
+func (r *stagingRepository) commit() error {
+	undo := []func() error{}
+	err := r.apply(&undo)
+	if err != nil {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+	return err
+}
+
+func (r *stagingRepository) apply(undo *[]func() error) error {
+	accounts, _ := r.changes.Accounts()
+	for i := range accounts {
+		account := &accounts[i]
+		previous, err := r.base.AccountByID(account.ID)
+		if err == nil && *previous == *account {
+			continue
+		}
+		err = r.base.SaveAccount(account)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			id := account.ID
+			*undo = append(*undo, func() error { return r.base.DeleteAccount(id) })
+		} else {
+			*undo = append(*undo, func() error { return r.base.SaveAccount(previous) })
+		}
+	}
+	payments, _ := r.changes.Payments()
+	for i := range payments {
+		payment := &payments[i]
+		previous, err := r.base.PaymentByID(payment.ID)
+		if err == nil && *previous == *payment {
+			continue
+		}
+		err = r.base.SavePayment(payment)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			id := payment.ID
+			*undo = append(*undo, func() error { return r.base.DeletePayment(id) })
+		} else {
+			*undo = append(*undo, func() error { return r.base.SavePayment(previous) })
+		}
+	}
+	favorites, _ := r.changes.Favorites()
+	for i := range favorites {
+		favorite := &favorites[i]
+		previous, err := r.base.FavoriteByID(favorite.ID)
+		if err == nil && *previous == *favorite {
+			continue
+		}
+		err = r.base.SaveFavorite(favorite)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			id := favorite.ID
+			*undo = append(*undo, func() error { return r.base.DeleteFavorite(id) })
+		} else {
+			*undo = append(*undo, func() error { return r.base.SaveFavorite(previous) })
+		}
+	}
+	transfers, _ := r.changes.Transfers()
+	for i := range transfers {
+		transfer := &transfers[i]
+		previous, err := r.base.TransferByID(transfer.ID)
+		if err == nil && *previous == *transfer {
+			continue
+		}
+		err = r.base.SaveTransfer(transfer)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			id := transfer.ID
+			*undo = append(*undo, func() error { return r.base.DeleteTransfer(id) })
+		} else {
+			*undo = append(*undo, func() error { return r.base.SaveTransfer(previous) })
+		}
+	}
+	keys, _ := r.changes.IdempotencyKeys()
+	for i := range keys {
+		key := &keys[i]
+		previous, err := r.base.IdempotencyKey(key.Key)
+		if err == nil && *previous == *key {
+			continue
+		}
+		err = r.base.SaveIdempotencyKey(key)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			id := key.Key
+			*undo = append(*undo, func() error { return r.base.DeleteIdempotencyKey(id) })
+		} else {
+			*undo = append(*undo, func() error { return r.base.SaveIdempotencyKey(previous) })
+		}
+	}
+	changes, _ := r.changes.TierChanges()
+	for i := range changes {
+		change := &changes[i]
+		previous, err := r.base.TierChangeByID(change.ID)
+		if err == nil && *previous == *change {
+			continue
+		}
+		err = r.base.SaveTierChange(change)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			id := change.ID
+			*undo = append(*undo, func() error { return r.base.DeleteTierChange(id) })
+		} else {
+			*undo = append(*undo, func() error { return r.base.SaveTierChange(previous) })
+		}
+	}
+	entries, _ := r.changes.LedgerEntries()
+	for i := range entries {
+		entry := &entries[i]
+		if _, err := r.base.LedgerEntryByID(entry.ID); err == nil {
+			continue
+		}
+		err := r.base.SaveLedgerEntry(entry)
+		if err != nil {
+			return err
+		}
+		id := entry.ID
+		*undo = append(*undo, func() error { return r.base.DeleteLedgerEntry(id) })
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+var ErrIllegalTransition = errors.New("illegal payment status transition")
+var ErrUnknownPaymentStatus = errors.New("unknown payment status")
+
+// TransitionError возвращается, когда платёж нельзя перевести в запрошенный статус
+// (например, отклонить уже проведённый или уже отклонённый платёж).
+type TransitionError struct {
+	PaymentID string
+	From      types.PaymentStatus
+	To        types.PaymentStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("payment %s: can't change status from %q to %q", e.PaymentID, e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrIllegalTransition
+}
+
+// transitions - таблица разрешённых переходов статусов платежа.
+// OK, FAIL, CANCELLED, VOIDED и EXPIRED - конечные статусы, из них никуда
+// перейти нельзя.
+var transitions = map[types.PaymentStatus][]types.PaymentStatus{
+	types.PaymentStatusInProgress: {
+		types.PaymentStatusOk,
+		types.PaymentStatusFail,
+		types.PaymentStatusCancelled,
+	},
+	types.PaymentStatusAuthorized: {
+		types.PaymentStatusOk,
+		types.PaymentStatusVoided,
+		types.PaymentStatusExpired,
+	},
+	types.PaymentStatusOk:        {},
+	types.PaymentStatusFail:      {},
+	types.PaymentStatusCancelled: {},
+	types.PaymentStatusVoided:    {},
+	types.PaymentStatusExpired:   {},
+}
+
+func isKnownStatus(status types.PaymentStatus) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// checkTransition проверяет, можно ли перевести платёж в статус to.
+// Переход в тот же статус разрешён - так повторный Import не ломается.
+func checkTransition(payment *types.Payment, to types.PaymentStatus) error {
+	if payment.Status == to && isKnownStatus(to) {
+		return nil
+	}
+	for _, allowed := range transitions[payment.Status] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return &TransitionError{PaymentID: payment.ID, From: payment.Status, To: to}
+}
+
+// Confirm проводит платёж: INPROGRESS -> OK.
+func (s *Service) Confirm(paymentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.begin(&walRecord{Op: opConfirm, Ref: paymentID})
+	defer s.end()
+	if err != nil {
+		return err
+	}
+
+	return s.confirm(paymentID)
+}
+
+// confirm проводит платёж вместе с его строкой комиссии. Строка комиссии
+// сама по себе статус не меняет - она следует за платежом.
+func (s *Service) confirm(paymentID string) error {
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+	err = checkPrimary(payment)
+	if err != nil {
+		return err
+	}
+	// Удержание проводится только через Capture: Confirm денег не списывает.
+	if payment.Status == types.PaymentStatusOk || payment.Status == types.PaymentStatusAuthorized {
+		return &TransitionError{PaymentID: payment.ID, From: payment.Status, To: types.PaymentStatusOk}
+	}
+	err = checkTransition(payment, types.PaymentStatusOk)
+	if err != nil {
+		return err
+	}
+
+	payment.Status = types.PaymentStatusOk
+	payment.UpdatedAt = s.now()
+	err = s.repository().SavePayment(payment)
+	if err != nil {
+		return err
+	}
+
+	fee, err := s.feeLine(payment)
+	if err != nil || fee == nil || fee.Status != types.PaymentStatusInProgress {
+		return err
+	}
+	fee.Status = types.PaymentStatusOk
+	fee.UpdatedAt = payment.UpdatedAt
+	return s.repository().SavePayment(fee)
+}
+
+// Cancel отменяет платёж по инициативе плательщика: INPROGRESS -> CANCELLED,
+// деньги возвращаются на счёт.
+func (s *Service) Cancel(paymentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.begin(&walRecord{Op: opCancel, Ref: paymentID})
+	defer s.end()
+	if err != nil {
+		return err
+	}
+
+	return s.closePayment(paymentID, types.PaymentStatusCancelled)
+}
+
+// closePayment переводит платёж в FAIL или CANCELLED и возвращает на счёт
+// то, что ещё не вернул Refund, а вместе с этим - и комиссию.
+func (s *Service) closePayment(paymentID string, status types.PaymentStatus) error {
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+	err = checkPrimary(payment)
+	if err != nil {
+		return err
+	}
+	if payment.Status == status {
+		return &TransitionError{PaymentID: payment.ID, From: payment.Status, To: status}
+	}
+	err = checkTransition(payment, status)
+	if err != nil {
+		return err
+	}
+
+	payment.Status = status
+	payment.UpdatedAt = s.now()
+	rest := payment.Amount - payment.Refunded
+	if rest > 0 {
+		entry, err := s.post(EntryRefund, payment.ID,
+			types.Posting{Account: customerLedgerAccount(payment.AccountID), Amount: rest},
+			types.Posting{Account: LedgerRefunds, Amount: -rest},
+		)
+		if err != nil {
+			return err
+		}
+		payment.UpdatedAt = entry.CreatedAt
+	}
+
+	err = s.repository().SavePayment(payment)
+	if err != nil {
+		return err
+	}
+
+	fee, err := s.feeLine(payment)
+	if err != nil || fee == nil || fee.Status != types.PaymentStatusInProgress {
+		return err
+	}
+	entry, err := s.post(EntryRefund, fee.ID,
+		types.Posting{Account: customerLedgerAccount(fee.AccountID), Amount: fee.Amount},
+		types.Posting{Account: LedgerFees, Amount: -fee.Amount},
+	)
+	if err != nil {
+		return err
+	}
+	fee.Status = status
+	fee.UpdatedAt = entry.CreatedAt
+	return s.repository().SavePayment(fee)
+}
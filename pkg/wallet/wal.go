@@ -0,0 +1,496 @@
+package wallet
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gholib/wallet/pkg/types"
+)
+
+var ErrWALCorrupted = errors.New("write-ahead log is corrupted")
+var ErrUnknownWALOperation = errors.New("unknown write-ahead log operation")
+
+// SyncPolicy задаёт, когда журнал сбрасывается на диск (fsync).
+type SyncPolicy int
+
+const (
+	// SyncAlways - fsync после каждой записи: подтверждённый вызов не теряется.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval - fsync в фоне раз в WALOptions.Interval: при падении
+	// теряются вызовы за последний интервал.
+	SyncInterval
+	// SyncNever - сброс на диск остаётся на усмотрение ОС.
+	SyncNever
+)
+
+// WALOptions - настройки журнала для Open.
+type WALOptions struct {
+	Sync     SyncPolicy
+	Interval time.Duration
+}
+
+// Операции журнала.
+const (
+	opRegisterAccount = "register-account"
+	opDeposit         = "deposit"
+	opPay             = "pay"
+	opTransfer        = "transfer"
+	opRejectTransfer  = "reject-transfer"
+	opReject          = "reject"
+	opCancel          = "cancel"
+	opConfirm         = "confirm"
+	opRepeat          = "repeat"
+	opFavoritePayment = "favorite-payment"
+	opPayFromFavorite = "pay-from-favorite"
+	opRefund          = "refund"
+	opAuthorize       = "authorize"
+	opCapture         = "capture"
+	opVoid            = "void"
+	opExpireHolds     = "expire-holds"
+	opPromoteAccount  = "promote-account"
+	opDemoteAccount   = "demote-account"
+)
+
+// walRecord - один вызов изменяющего метода Service. ID - идентификатор,
+// который вызов создал (платёж, перевод, избранное): при восстановлении
+// он берётся из журнала, а не генерируется заново. Ref - сущность, над
+// которой работает вызов. Key - ключ идемпотентности, если вызов был с ним.
+type walRecord struct {
+	Seq       uint64                `json:"seq"`
+	Op        string                `json:"op"`
+	Time      time.Time             `json:"time"`
+	ID        string                `json:"id,omitempty"`
+	Ref       string                `json:"ref,omitempty"`
+	AccountID int64                 `json:"account_id,omitempty"`
+	ToID      int64                 `json:"to_id,omitempty"`
+	Phone     types.Phone           `json:"phone,omitempty"`
+	Amount    types.Money           `json:"amount,omitempty"`
+	Currency  types.Currency        `json:"currency,omitempty"`
+	Category  types.PaymentCategory `json:"category,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Rate      types.Rate            `json:"rate,omitempty"`
+	Converted types.Money           `json:"converted,omitempty"`
+	Fee       types.Money           `json:"fee,omitempty"`
+	TTL       time.Duration         `json:"ttl,omitempty"`
+	Key       string                `json:"key,omitempty"`
+	Tier      types.KYCTier         `json:"tier,omitempty"`
+}
+
+// wal - журнал упреждающей записи. Каждая запись - строка
+// "<crc32 в hex> <json>\n", так что оборванная последняя запись
+// опознаётся и отрезается при открытии.
+type wal struct {
+	mu      sync.Mutex
+	file    *os.File
+	options WALOptions
+	seq     uint64
+	dirty   bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+const (
+	walFile      = "wallet.wal"
+	snapshotDir  = "snapshot"
+	snapshotTmp  = "snapshot.tmp"
+	snapshotOld  = "snapshot.old"
+	snapshotSeq  = "wal.seq"
+	walFilePerms = 0644
+)
+
+// openWAL открывает журнал, читает все целые записи и отрезает оборванный хвост.
+func openWAL(path string, options WALOptions) (*wal, []walRecord, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, walFilePerms)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records, good, err := readWAL(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	err = file.Truncate(good)
+	if err == nil {
+		_, err = file.Seek(good, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	w := &wal{file: file, options: options}
+	if len(records) != 0 {
+		w.seq = records[len(records)-1].Seq
+	}
+	if options.Sync == SyncInterval && options.Interval > 0 {
+		w.done = make(chan struct{})
+		w.stopped = make(chan struct{})
+		go w.syncLoop()
+	}
+
+	return w, records, nil
+}
+
+// readWAL возвращает целые записи и смещение, до которого файл корректен.
+// Испорченная запись допустима только в самом конце файла - это запись,
+// на которой процесс упал. Испорченная запись в середине - ErrWALCorrupted.
+func readWAL(file *os.File) ([]walRecord, int64, error) {
+	records := []walRecord{}
+	var good int64
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return records, good, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		record, ok := decodeWALRecord(strings.TrimSuffix(line, "\n"))
+		if !ok {
+			_, err = reader.Peek(1)
+			if err == io.EOF {
+				return records, good, nil
+			}
+			return nil, 0, fmt.Errorf("%w: bad record at offset %d", ErrWALCorrupted, good)
+		}
+
+		records = append(records, record)
+		good += int64(len(line))
+	}
+}
+
+func encodeWALRecord(record *walRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(payload), payload)
+	return []byte(line), nil
+}
+
+func decodeWALRecord(line string) (walRecord, bool) {
+	var record walRecord
+
+	parts := strings.SplitN(line, " ", 2)
+	if len(parts) != 2 {
+		return record, false
+	}
+	sum, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil || uint32(sum) != crc32.ChecksumIEEE([]byte(parts[1])) {
+		return record, false
+	}
+	err = json.Unmarshal([]byte(parts[1]), &record)
+	if err != nil {
+		return record, false
+	}
+	return record, true
+}
+
+func (w *wal) append(record *walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	record.Seq = w.seq + 1
+	data, err := encodeWALRecord(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.file.Write(data)
+	if err != nil {
+		return err
+	}
+	w.seq = record.Seq
+
+	if w.options.Sync == SyncAlways {
+		return w.file.Sync()
+	}
+	w.dirty = true
+	return nil
+}
+
+func (w *wal) syncLoop() {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(w.options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty {
+				w.file.Sync()
+				w.dirty = false
+			}
+			w.mu.Unlock()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// reset очищает журнал после того, как его записи попали в снимок.
+func (w *wal) reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = w.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	w.dirty = false
+	return w.file.Sync()
+}
+
+func (w *wal) close() error {
+	if w.done != nil {
+		close(w.done)
+		<-w.stopped
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.file.Sync()
+	if err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Open восстанавливает сервис из каталога dir: загружает последний снимок
+// (dir/snapshot, его пишет Checkpoint) и проигрывает поверх него журнал
+// dir/wallet.wal. Дальше каждый изменяющий вызов сначала пишется в журнал.
+func Open(dir string, options WALOptions) (*Service, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{}
+
+	// Checkpoint мог упасть между переименованиями каталогов снимка.
+	snapshot := filepath.Join(dir, snapshotDir)
+	if _, err := os.Stat(snapshot); os.IsNotExist(err) {
+		if _, err := os.Stat(filepath.Join(dir, snapshotOld)); err == nil {
+			err = os.Rename(filepath.Join(dir, snapshotOld), snapshot)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var covered uint64
+	if _, err := os.Stat(snapshot); err == nil {
+		err = s.importDir(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		covered, err = readSnapshotSeq(snapshot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w, records, err := openWAL(filepath.Join(dir, walFile), options)
+	if err != nil {
+		return nil, err
+	}
+	if w.seq < covered {
+		w.seq = covered
+	}
+
+	for _, record := range records {
+		if record.Seq <= covered {
+			continue
+		}
+		err = s.replay(record)
+		if errors.Is(err, ErrUnknownWALOperation) {
+			w.close()
+			return nil, err
+		}
+	}
+
+	s.wal = w
+	s.walDir = dir
+	return s, nil
+}
+
+// replay повторяет вызов из журнала с исходным временем и идентификаторами.
+// Ошибки бизнес-логики (не хватило денег и т.п.) повторяются так же, как
+// при исходном вызове, и пропускаются.
+func (s *Service) replay(record walRecord) error {
+	s.opTime = record.Time
+	defer s.end()
+
+	var err error
+	switch record.Op {
+	case opRegisterAccount:
+		currency := record.Currency
+		if currency == "" {
+			currency = types.DefaultCurrency
+		}
+		_, err = s.registerAccount(record.Phone, currency)
+	case opDeposit:
+		err = s.deposit(record.AccountID, record.Amount, record.Currency, walConversion(record))
+	case opPay:
+		_, err = s.pay(record.ID, record.AccountID, record.Amount, record.Currency, walConversion(record), record.Fee, record.Category)
+	case opTransfer:
+		_, err = s.makeTransfer(record.ID, record.AccountID, record.ToID, record.Amount)
+	case opRejectTransfer:
+		err = s.rejectTransfer(record.Ref)
+	case opReject:
+		err = s.closePayment(record.Ref, types.PaymentStatusFail)
+	case opCancel:
+		err = s.closePayment(record.Ref, types.PaymentStatusCancelled)
+	case opConfirm:
+		err = s.confirm(record.Ref)
+	case opRepeat:
+		_, err = s.repeat(record.ID, record.Ref, record.Fee)
+	case opFavoritePayment:
+		_, err = s.favoritePayment(record.ID, record.Ref, record.Name)
+	case opPayFromFavorite:
+		_, err = s.payFromFavorite(record.ID, record.Ref, record.Fee)
+	case opRefund:
+		_, err = s.refund(record.ID, record.Ref, record.Amount)
+	case opAuthorize:
+		_, err = s.authorize(record.ID, record.AccountID, record.Amount, record.Category, record.TTL)
+	case opCapture:
+		_, err = s.capture(record.Ref, record.Amount)
+	case opVoid:
+		err = s.void(record.Ref)
+	case opExpireHolds:
+		_, err = s.expireAllHolds()
+	case opPromoteAccount:
+		_, err = s.changeTier(record.ID, record.AccountID, record.Tier, record.Name, true)
+	case opDemoteAccount:
+		_, err = s.changeTier(record.ID, record.AccountID, record.Tier, record.Name, false)
+	default:
+		err = fmt.Errorf("%w: %q", ErrUnknownWALOperation, record.Op)
+	}
+	if err == nil {
+		err = s.saveKey(&record)
+	}
+	return err
+}
+
+// begin фиксирует время операции и, если журнал включён, пишет вызов
+// в журнал до того, как он будет применён. end снимает фиксацию времени.
+func (s *Service) begin(record *walRecord) error {
+	s.opTime = time.Time{}
+	s.opTime = s.now()
+	record.Time = s.opTime
+	if s.wal == nil {
+		return nil
+	}
+	return s.wal.append(record)
+}
+
+func (s *Service) end() {
+	s.opTime = time.Time{}
+}
+
+// Checkpoint записывает снимок состояния в каталог журнала и очищает журнал.
+// Снимок помнит номер последней вошедшей в него записи, поэтому падение
+// между записью снимка и очисткой журнала не приводит к повтору вызовов.
+func (s *Service) Checkpoint() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.checkpoint()
+}
+
+func (s *Service) checkpoint() error {
+	if s.wal == nil {
+		return nil
+	}
+
+	err := s.pruneKeys()
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.walDir, snapshotTmp)
+	err = os.RemoveAll(tmp)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(tmp, 0755)
+	if err != nil {
+		return err
+	}
+	err = s.export(tmp)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(tmp, snapshotSeq), []byte(strconv.FormatUint(s.wal.seq, 10)), walFilePerms)
+	if err != nil {
+		return err
+	}
+
+	snapshot := filepath.Join(s.walDir, snapshotDir)
+	old := filepath.Join(s.walDir, snapshotOld)
+	err = os.RemoveAll(old)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(snapshot); err == nil {
+		err = os.Rename(snapshot, old)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Rename(tmp, snapshot)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(old)
+	if err != nil {
+		return err
+	}
+
+	return s.wal.reset()
+}
+
+func readSnapshotSeq(dir string) (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, snapshotSeq))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// Close сбрасывает журнал на диск и закрывает его.
+func (s *Service) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wal == nil {
+		return nil
+	}
+	err := s.wal.close()
+	s.wal = nil
+	return err
+}
@@ -0,0 +1,305 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func fillWALService(t *testing.T, s *Service) (string, string) {
+	t.Helper()
+
+	clock := &testClock{now: time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC)}
+	s.SetClock(clock.Now)
+
+	from, err := s.RegisterAccount("+992880806776")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.RegisterAccount("+992880806777")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.add(time.Hour)
+	err = s.Deposit(from.ID, 10_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.add(time.Hour)
+	payment, err := s.Pay(from.ID, 1000_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.add(time.Hour)
+	favorite, err := s.FavoritePayment(payment.ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.PayFromFavorite(favorite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer, err := s.Transfer(from.ID, to.ID, 500_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.add(time.Hour)
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payment.ID, transfer.ID
+}
+
+func TestOpen_replay(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	paymentID, transferID := fillWALService(t, s)
+	want, err := s.ExportAccountHistory(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s, err = Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	got, err := s.ExportAccountHistory(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid history after replay: got %v, want %v", got, want)
+		return
+	}
+	_, err = s.FindPaymentByID(paymentID)
+	if err != nil {
+		t.Errorf("payment not restored: %v", err)
+		return
+	}
+	_, err = s.FindTransferByID(transferID)
+	if err != nil {
+		t.Errorf("transfer not restored: %v", err)
+		return
+	}
+	account, err := s.FindAccountByID(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 10_000_00-1000_00-500_00 {
+		t.Errorf("invalid balance after replay: %v", account.Balance)
+		return
+	}
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	account, err = s.RegisterAccount("+992880806778")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.ID != 3 {
+		t.Errorf("invalid next account ID after replay: %v", account.ID)
+	}
+}
+
+func TestOpen_tornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fillWALService(t, s)
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	path := filepath.Join(dir, walFile)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = file.WriteString(`1234abcd {"seq":99,"op":"deposit","acc`)
+	file.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s, err = Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(1, 1_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s, err = Open(dir, WALOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	account, err := s.FindAccountByID(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 10_000_00-1000_00-500_00+1_00 {
+		t.Errorf("invalid balance after torn record: %v", account.Balance)
+	}
+}
+
+func TestOpen_corrupted(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fillWALService(t, s)
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	path := filepath.Join(dir, walFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data[0] ^= 0x01
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = Open(dir, WALOptions{})
+	if !errors.Is(err, ErrWALCorrupted) {
+		t.Errorf("invalid error: %v", err)
+	}
+}
+
+func TestService_Checkpoint_success(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, WALOptions{Sync: SyncInterval, Interval: time.Millisecond})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fillWALService(t, s)
+	err = s.Checkpoint()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(2, 1_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s, err = Open(dir, WALOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	account, err := s.FindAccountByID(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 10_000_00-1000_00-500_00 {
+		t.Errorf("invalid balance after checkpoint: %v", account.Balance)
+		return
+	}
+	account, err = s.FindAccountByID(2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 500_00+1_00 {
+		t.Errorf("invalid balance after checkpoint: %v", account.Balance)
+		return
+	}
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOpen_payFromMissingFavorite(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+	fillWALService(t, s)
+	before, err := os.Stat(filepath.Join(dir, walFile))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.PayFromFavorite("missing")
+	if err != ErrFavoriteNotFound {
+		t.Errorf("PayFromFavorite(): must return ErrFavoriteNotFound, returned %v", err)
+		return
+	}
+	after, err := os.Stat(filepath.Join(dir, walFile))
+	if err != nil || after.Size() != before.Size() {
+		t.Errorf("PayFromFavorite(): failed lookup must not be journaled, wal size %d -> %v, error = %v", before.Size(), after, err)
+	}
+}